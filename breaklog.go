@@ -0,0 +1,95 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// States of a BreakerLogWriter
+const (
+	breakerClosed   = iota // records are written
+	breakerOpen            // records are dropped until the cooldown is over
+	breakerHalfOpen        // one record is written to probe the writer
+)
+
+// Returned for records dropped while the breaker is open
+var errBreakerOpen = errors.New("circuit breaker open, record dropped")
+
+// This log writer stops calling a failing writer for a while.  After
+// threshold consecutive failures the breaker opens and drops records for the
+// cooldown, then writes a single record to probe the writer.  If that
+// succeeds the breaker closes and writing resumes; otherwise it opens again.
+type BreakerLogWriter struct {
+	w         ErrorLogWriter
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	opened   time.Time
+	dropped  int
+}
+
+// NewBreakerLogWriter wraps w in a circuit breaker.
+func NewBreakerLogWriter(w ErrorLogWriter, threshold int, cooldown time.Duration) *BreakerLogWriter {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &BreakerLogWriter{
+		w:         w,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+func (b *BreakerLogWriter) LogWrite(rec *LogRecord) {
+	b.LogWriteErr(rec)
+}
+
+// LogWriteErr writes the record through the breaker.  Failures of the wrapped
+// writer and changes of state are reported on stderr.
+func (b *BreakerLogWriter) LogWriteErr(rec *LogRecord) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		if timeNow().Sub(b.opened) < b.cooldown {
+			b.dropped++
+			return errBreakerOpen
+		}
+		b.state = breakerHalfOpen
+	}
+
+	err := b.w.LogWriteErr(rec)
+	if err == nil {
+		if b.state == breakerHalfOpen {
+			fmt.Fprintf(stderr, "BreakerLogWriter: writer recovered, %d records were dropped while open\n", b.dropped)
+		}
+		b.state, b.failures, b.dropped = breakerClosed, 0, 0
+		return nil
+	}
+
+	if b.state == breakerHalfOpen {
+		b.state, b.opened = breakerOpen, timeNow()
+		fmt.Fprintf(stderr, "BreakerLogWriter: writer still failing, dropping records for %s: %v\n", b.cooldown, err)
+		return err
+	}
+
+	b.failures++
+	if b.failures < b.threshold {
+		fmt.Fprintf(stderr, "BreakerLogWriter: %v\n", err)
+		return err
+	}
+	b.state, b.opened = breakerOpen, timeNow()
+	fmt.Fprintf(stderr, "BreakerLogWriter: %d consecutive failures, dropping records for %s: %v\n", b.failures, b.cooldown, err)
+	return err
+}
+
+func (b *BreakerLogWriter) Close() {
+	b.w.Close()
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CEF severities (0 lowest .. 10 highest) of FINEST .. CRITICAL
+var cefSeverities = [...]int{0, 1, 2, 3, 4, 6, 8, 10}
+
+// Escapes of the header fields and of the extension values of a CEF line
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+// Keep the letters, digits and underscores of a field key, as CEF keys
+// cannot be escaped
+func cefKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, key)
+}
+
+// NewCEFFormatter returns a Formatter rendering the records as ArcSight
+// Common Event Format lines, for SIEMs:
+//
+//	CEF:0|vendor|product|version|source|message|severity|rt=... key=value
+//
+// The source is the signature id and the message the name.  The levels map
+// to the severities 0 (FINEST), 1, 2, 3, 4 (INFO), 6 (WARNING), 8 (ERROR) and
+// 10 (CRITICAL).  The extension holds the time of the record as rt, in
+// milliseconds since the epoch, and the fields; characters other than letters,
+// digits and underscores are dropped from their keys.
+func NewCEFFormatter(vendor, product, version string) Formatter {
+	prefix := "CEF:0|" + cefHeaderEscaper.Replace(vendor) + "|" +
+		cefHeaderEscaper.Replace(product) + "|" + cefHeaderEscaper.Replace(version) + "|"
+	return func(rec *LogRecord) ([]byte, error) {
+		severity := cefSeverities[len(cefSeverities)-1]
+		if rec.Level < 0 {
+			severity = cefSeverities[0]
+		} else if int(rec.Level) < len(cefSeverities) {
+			severity = cefSeverities[rec.Level]
+		}
+
+		out := bytes.NewBuffer(make([]byte, 0, 128))
+		out.WriteString(prefix)
+		out.WriteString(cefHeaderEscaper.Replace(rec.Source))
+		out.WriteByte('|')
+		out.WriteString(cefHeaderEscaper.Replace(rec.Message))
+		out.WriteByte('|')
+		out.WriteString(strconv.Itoa(severity))
+		out.WriteString("|rt=")
+		out.WriteString(strconv.FormatInt(rec.Created.UnixNano()/1e6, 10))
+		for _, f := range rec.Fields {
+			key := cefKey(f.Key)
+			if len(key) == 0 {
+				continue
+			}
+			out.WriteByte(' ')
+			out.WriteString(key)
+			out.WriteByte('=')
+			out.WriteString(cefValueEscaper.Replace(fmt.Sprint(f.Value)))
+		}
+		out.WriteByte('\n')
+		return out.Bytes(), nil
+	}
+}
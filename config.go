@@ -3,31 +3,107 @@
 package log4go
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/xml"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"path"
+	"sort"
 	"encoding/json"
+	"time"
 )
 
+// LevelEnv names the environment variable that makes the filters of a loaded
+// configuration at least as verbose as the level it holds, e.g.
+// LOG4GO_LEVEL=DEBUG turns a WARNING filter into a DEBUG filter and leaves a
+// FINEST filter alone.  An unknown level is ignored with a warning.  Empty
+// disables the override.
+var LevelEnv = "LOG4GO_LEVEL"
+
+// The level of LevelEnv, if set to a known level
+func envLevel(filename string) (Level, bool) {
+	if len(LevelEnv) == 0 {
+		return 0, false
+	}
+	name, ok := os.LookupEnv(LevelEnv)
+	if !ok || len(name) == 0 {
+		return 0, false
+	}
+	lvl, ok := levelByName(strings.TrimSpace(name))
+	if !ok {
+		fmt.Fprintf(stderr, "LoadConfig: Warning: Unknown level %q in %s for %s, using the configured levels\n", name, LevelEnv, filename)
+	}
+	return lvl, ok
+}
+
 type kvProperty struct {
-	Name  string `xml:"name,attr"`
-	Value string `xml:",chardata"`
+	Name  string `xml:"name,attr" json:"name"`
+	Value string `xml:",chardata" json:"value"`
+}
+
+// The properties of a filter.  In JSON they are either an array of
+// {"name": ..., "value": ...} objects like the XML elements, or an object
+// mapping names to values: {"color": false, "format": "%M"}.
+type kvProperties []kvProperty
+
+// UnmarshalJSON reads either form of the properties.  The names of the object
+// form are sorted, as objects have no order.
+func (props *kvProperties) UnmarshalJSON(data []byte) error {
+	var list []kvProperty
+	if err := json.Unmarshal(data, &list); err == nil {
+		*props = list
+		return nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list = make([]kvProperty, 0, len(obj))
+	for _, name := range names {
+		var value string
+		if err := json.Unmarshal(obj[name], &value); err != nil {
+			// Numbers and booleans are used as written
+			value = string(obj[name])
+		}
+		list = append(list, kvProperty{Name: name, Value: value})
+	}
+	*props = list
+	return nil
 }
 
 type kvFilter struct {
-	Enabled  string        `xml:"enabled,attr"`
-	Tag      string        `xml:"tag"`
-	Level    string        `xml:"level"`
-	Type     string        `xml:"type"`
-	Properties []kvProperty `xml:"property"`
+	Enabled    string       `xml:"enabled,attr" json:"enabled"`
+	Tag        string       `xml:"tag" json:"tag"`
+	Level      string       `xml:"level" json:"level"`
+	Type       string       `xml:"type" json:"type"`
+	Properties kvProperties `xml:"property" json:"properties"`
 }
 
 type Config struct {
-	Filters []kvFilter `xml:"filter"`
+	// Format of the console, file, syslog and eventlog filters without a format
+	// property of their own
+	Format string `xml:"format,omitempty" json:"format,omitempty"`
+
+	// Strict makes the load fail when a destination is not usable at load
+	// time instead of waiting for the first log message, and a tag used by
+	// several filters an error rather than a warning.  Only a TCP socket
+	// filter is checked: dialing UDP sends nothing, so an unreachable UDP
+	// endpoint still loads.  Socket filters can also set the "strict"
+	// property individually.
+	Strict bool `xml:"strict,attr,omitempty" json:"strict,omitempty"`
+
+	Filters []kvFilter `xml:"filter" json:"filters"`
 }
 
 func (log Logger) LoadConfig(filename string) {
@@ -41,63 +117,134 @@ func (log Logger) LoadConfig(filename string) {
 		fmt.Fprintf(os.Stderr, "LoadConfig: Error: Could not open %q for reading: %s\n", filename, err)
 		os.Exit(1)
 	}
+	defer fd.Close()
 
-	buf, err := ioutil.ReadAll(fd)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "LoadConfig: Error: Could not read %q: %s\n", filename, err)
+	log.Close()
+	if err := log.loadConfig(filename, fd, configFormat(filename)); err != nil {
 		os.Exit(1)
 	}
-
-	log.LoadConfigBuf(filename, buf)
-	return
 }
 
+// LoadConfigBuf loads a configuration in JSON if filename ends in ".json",
+// and in XML otherwise.
 func (log Logger) LoadConfigBuf(filename string, buf []byte) {
-	ext := path.Ext(filename)
-	ext = ext[1:]
-
-	switch ext {
-	case "xml":
-		log.LoadXMLConfig(filename, buf)
-		break
+	switch configFormat(filename) {
 	case "json":
 		log.LoadJSONConfig(filename, buf)
-		break
 	default:
-		fmt.Fprintf(os.Stderr, "LoadConfig: Error: Unknown config file type %v. XML or JSON are supported types\n", ext)
+		log.LoadXMLConfig(filename, buf)
 	}
 }
 
 // Parse Json configuration; see examples/example.json for documentation
 func (log Logger) LoadJSONConfig(filename string, contents []byte) {
 	log.Close()
-
-	jc := new(Config)
-	if err := json.Unmarshal(contents, jc); err != nil {
-		fmt.Fprintf(os.Stderr, "LoadConfig: Error: Could not parse Json configuration in %q: %s\n", filename, err)
+	if err := log.loadConfig(filename, bytes.NewReader(contents), "json"); err != nil {
 		os.Exit(1)
 	}
-
-	log.ConfigToLogWriter(filename, jc)
 }
 
 // Parse XML configuration; see examples/example.xml for documentation
 func (log Logger) LoadXMLConfig(filename string, contents []byte) {
 	log.Close()
-
-	xc := new(Config)
-	if err := xml.Unmarshal(contents, xc); err != nil {
-		fmt.Fprintf(os.Stderr, "LoadConfig: Error: Could not parse XML configuration in %q: %s\n", filename, err)
+	if err := log.loadConfig(filename, bytes.NewReader(contents), "xml"); err != nil {
 		os.Exit(1)
 	}
+}
+
+// LoadConfigReader loads a configuration read from r, e.g. one fetched over
+// the network, in format "xml" or "json".  An empty format picks JSON if the
+// configuration starts with '{', and XML otherwise.  Unlike LoadConfig it
+// does not exit the program: the problems are reported on stderr and an
+// error is returned.  A configuration which cannot be decoded leaves the
+// logger untouched; one with an invalid filter leaves it without filters.
+func (log Logger) LoadConfigReader(r io.Reader, format string) error {
+	return log.loadConfig("LoadConfigReader", r, format)
+}
+
+// The format of a configuration file, "json" if its name ends in ".json"
+func configFormat(filename string) string {
+	if path.Ext(filename) == ".json" {
+		return "json"
+	}
+	return "xml"
+}
 
-	log.ConfigToLogWriter(filename, xc)
+// Guess the format of a configuration from its first character which is not
+// a space, without consuming anything
+func sniffConfigFormat(r *bufio.Reader) string {
+	for i := 1; ; i++ {
+		b, _ := r.Peek(i)
+		if len(b) < i {
+			return "xml"
+		}
+		switch b[i-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '{':
+			return "json"
+		}
+		return "xml"
+	}
+}
+
+// Decode a configuration from r and replace the filters of the logger with
+// its filters
+func (log Logger) loadConfig(filename string, r io.Reader, format string) error {
+	if len(format) == 0 {
+		br := bufio.NewReader(r)
+		format, r = sniffConfigFormat(br), br
+	}
+
+	cfg := new(Config)
+	var err error
+	switch format {
+	case "json":
+		err = json.NewDecoder(r).Decode(cfg)
+	case "xml":
+		err = xml.NewDecoder(r).Decode(cfg)
+	default:
+		err = fmt.Errorf("unknown configuration format %q", format)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "LoadConfig: Error: Could not parse %s configuration in %q: %s\n", strings.ToUpper(format), filename, err)
+		return fmt.Errorf("LoadConfig: %s: %s", filename, err)
+	}
+
+	log.Close()
+	if !log.configToFilters(filename, cfg) {
+		log.Close()
+		return fmt.Errorf("LoadConfig: %s: invalid configuration", filename)
+	}
+	return nil
 }
 
 func (log Logger) ConfigToLogWriter(filename string, cfg *Config) {
+	if !log.configToFilters(filename, cfg) {
+		os.Exit(1)
+	}
+}
+
+// ApplyConfig replaces the filters of the logger with those of cfg, e.g. one
+// made by a ConfigBuilder.  It is checked like a configuration file; if it is
+// invalid, the problems are reported on stderr, the logger is left without
+// filters and an error is returned.
+func (log Logger) ApplyConfig(cfg *Config) error {
+	log.Close()
+	if !log.configToFilters("ApplyConfig", cfg) {
+		log.Close()
+		return errors.New("ApplyConfig: invalid configuration")
+	}
+	return nil
+}
+
+// Add the filters of cfg.  Returns false after reporting the errors of the
+// first invalid filter.
+func (log Logger) configToFilters(filename string, cfg *Config) bool {
+	tags := make(map[string]bool)
+	override, overridden := envLevel(filename)
 	for _, kvfilt := range cfg.Filters {
 		var lw LogWriter
-		var lvl Level
 		bad, good, enabled := false, true, false
 
 		// Check required children
@@ -110,7 +257,16 @@ func (log Logger) ConfigToLogWriter(filename string, cfg *Config) {
 		if len(kvfilt.Tag) == 0 {
 			fmt.Fprintf(os.Stderr, "LoadConfig: Error: Required child <%s> for filter missing in %s\n", "tag", filename)
 			bad = true
+		} else if tags[kvfilt.Tag] {
+			// The later filter replaces the earlier one
+			if cfg.Strict {
+				fmt.Fprintf(stderr, "LoadConfig: Error: Duplicate tag %q for filter in %s\n", kvfilt.Tag, filename)
+				bad = true
+			} else {
+				fmt.Fprintf(stderr, "LoadConfig: Warning: Duplicate tag %q for filter in %s, the last one is used\n", kvfilt.Tag, filename)
+			}
 		}
+		tags[kvfilt.Tag] = true
 		if len(kvfilt.Type) == 0 {
 			fmt.Fprintf(os.Stderr, "LoadConfig: Error: Required child <%s> for filter missing in %s\n", "type", filename)
 			bad = true
@@ -120,50 +276,58 @@ func (log Logger) ConfigToLogWriter(filename string, cfg *Config) {
 			bad = true
 		}
 
-		switch kvfilt.Level {
-		case "FINEST":
-			lvl = FINEST
-		case "FINE":
-			lvl = FINE
-		case "DEBUG":
-			lvl = DEBUG
-		case "TRACE":
-			lvl = TRACE
-		case "INFO":
-			lvl = INFO
-		case "WARNING":
-			lvl = WARNING
-		case "ERROR":
-			lvl = ERROR
-		case "CRITICAL":
-			lvl = CRITICAL
-		default:
-			fmt.Fprintf(os.Stderr, "LoadConfig: Error: Required child <%s> for filter has unknown value in %s: %s\n", "level", filename, kvfilt.Level)
+		lvl, ok := levelByName(kvfilt.Level)
+		if !ok && len(kvfilt.Level) > 0 {
+			fmt.Fprintf(os.Stderr, "LoadConfig: Error: Required child <%s> for filter has unknown value in %s: %s (known levels: %s)\n", "level", filename, kvfilt.Level, strings.Join(knownLevelNames(), ", "))
 			bad = true
 		}
 
 		// Just so all of the required attributes are errored at the same time if missing
 		if bad {
-			os.Exit(1)
+			return false
+		}
+		if overridden && override < lvl {
+			lvl = override
+		}
+
+		// The options are checked before the writer opens a file or a
+		// connection it would leak
+		props, async, buflen, optsGood := propToFilterOptions(filename, kvfilt.Properties)
+		if !optsGood {
+			return false
 		}
+		props = withDefaultFormat(props, kvfilt.Type, cfg.Format)
 
 		switch kvfilt.Type {
 		case "console":
-			lw, good = propToConsoleLogWriter(filename, kvfilt.Properties, enabled)
+			lw, good = propToConsoleLogWriter(filename, props, enabled)
 		case "file":
-			lw, good = propToFileLogWriter(filename, kvfilt.Properties, enabled)
+			lw, good = propToFileLogWriter(filename, props, enabled)
 		case "xml":
-			lw, good = propToXMLLogWriter(filename, kvfilt.Properties, enabled)
+			lw, good = propToXMLLogWriter(filename, props, enabled)
 		case "socket":
-			lw, good = propToSocketLogWriter(filename, kvfilt.Properties, enabled)
+			lw, good = propToSocketLogWriter(filename, props, enabled, cfg.Strict)
+		case "syslog":
+			lw, good = propToSyslogLogWriter(filename, props, enabled)
+		case "http":
+			lw, good = propToHTTPLogWriter(filename, props, enabled)
 		default:
+			if maker, ok := writerMaker(kvfilt.Type); ok {
+				lw, good = propToRegisteredWriter(filename, kvfilt.Type, maker, props, enabled)
+				break
+			}
+			// e.g. a type registered by a package the program does not import
+			if !enabled {
+				fmt.Fprintf(stderr, "LoadConfig: Warning: Unknown filter type \"%s\" for disabled filter %q in %s\n", kvfilt.Type, kvfilt.Tag, filename)
+				continue
+			}
 			fmt.Fprintf(os.Stderr, "LoadConfig: Error: Could not load configuration in %s: unknown filter type \"%s\"\n", filename, kvfilt.Type)
-			os.Exit(1)
+			return false
 		}
 
 		// Just so all of the required params are errored at the same time if wrong
 		if !good {
-			os.Exit(1)
+			return false
 		}
 
 		// If we're disabled (syntax and correctness checks only), don't add to logger
@@ -171,13 +335,208 @@ func (log Logger) ConfigToLogWriter(filename string, cfg *Config) {
 			continue
 		}
 
-		log[kvfilt.Tag] = NewFilter(lvl, lw)
+		if old, ok := log[kvfilt.Tag]; ok {
+			old.Close()
+		}
+		if async {
+			log[kvfilt.Tag] = NewBufferedFilter(lvl, lw, buflen)
+		} else {
+			log[kvfilt.Tag] = NewSyncFilter(lvl, lw)
+		}
+	}
+
+	log.filtersAdded()
+	return true
+}
+
+// A ConfigBuilder makes a Config in code, in the shape of a configuration
+// file, for Logger.ApplyConfig:
+//
+//	cfg := NewConfigBuilder().
+//		AddConsole("stdout", INFO, Property("color", false)).
+//		AddFile("file", FINEST, "app.log", Property("rotate", true), Property("maxsize", "10M")).
+//		Build()
+//	err := log.ApplyConfig(cfg)
+type ConfigBuilder struct {
+	cfg Config
+}
+
+// A ConfigOption sets a property of a filter added to a ConfigBuilder
+type ConfigOption func(filt *kvFilter)
+
+// Property sets the property name of a filter, as in a configuration file.
+// The value is rendered with fmt.Sprint.
+func Property(name string, value interface{}) ConfigOption {
+	return func(filt *kvFilter) {
+		filt.Properties = append(filt.Properties, kvProperty{Name: name, Value: fmt.Sprint(value)})
+	}
+}
+
+// NewConfigBuilder starts an empty configuration.
+func NewConfigBuilder() *ConfigBuilder {
+	return &ConfigBuilder{}
+}
+
+// Add adds a filter of any type, e.g. "xml" or "syslog" (chainable).
+func (b *ConfigBuilder) Add(tag, typ string, lvl Level, opts ...ConfigOption) *ConfigBuilder {
+	filt := kvFilter{
+		Enabled: "true",
+		Tag:     tag,
+		Level:   levelName(lvl),
+		Type:    typ,
+	}
+	for _, opt := range opts {
+		opt(&filt)
 	}
+	b.cfg.Filters = append(b.cfg.Filters, filt)
+	return b
+}
+
+// AddConsole adds a console filter (chainable).
+func (b *ConfigBuilder) AddConsole(tag string, lvl Level, opts ...ConfigOption) *ConfigBuilder {
+	return b.Add(tag, "console", lvl, opts...)
+}
+
+// AddFile adds a file filter writing to filename (chainable).
+func (b *ConfigBuilder) AddFile(tag string, lvl Level, filename string, opts ...ConfigOption) *ConfigBuilder {
+	return b.Add(tag, "file", lvl, append([]ConfigOption{Property("filename", filename)}, opts...)...)
+}
+
+// AddSocket adds a socket filter sending to endpoint over protocol, "udp" or
+// "tcp" (chainable).
+func (b *ConfigBuilder) AddSocket(tag string, lvl Level, protocol, endpoint string, opts ...ConfigOption) *ConfigBuilder {
+	return b.Add(tag, "socket", lvl, append([]ConfigOption{Property("protocol", protocol), Property("endpoint", endpoint)}, opts...)...)
+}
+
+// Strict makes the configuration fail to apply when a destination is not
+// usable, as Config.Strict (chainable).
+func (b *ConfigBuilder) Strict() *ConfigBuilder {
+	b.cfg.Strict = true
+	return b
+}
+
+// Build returns the configuration.
+func (b *ConfigBuilder) Build() *Config {
+	cfg := &Config{Strict: b.cfg.Strict, Filters: make([]kvFilter, len(b.cfg.Filters))}
+	copy(cfg.Filters, b.cfg.Filters)
+	return cfg
+}
+
+// Take the properties of the filter itself out of props: "async" (true by
+// default) writes in the background, false writes before the log call returns;
+// "buffer" is the number of records queued for a background writer.
+func propToFilterOptions(filename string, props []kvProperty) ([]kvProperty, bool, int, bool) {
+	async, buflen, good := true, DefaultBufferLength, true
+	rest := make([]kvProperty, 0, len(props))
+	for _, prop := range props {
+		switch prop.Name {
+		case "async":
+			async = strings.Trim(prop.Value, " \r\n") != "false"
+		case "buffer":
+			n, err := strconv.Atoi(strings.Trim(prop.Value, " \r\n"))
+			if err != nil || n < 0 {
+				fmt.Fprintf(os.Stderr, "LoadConfig: Error: Invalid buffer size %q for filter in %s\n", prop.Value, filename)
+				good = false
+				continue
+			}
+			buflen = n
+		default:
+			rest = append(rest, prop)
+		}
+	}
+	return rest, async, buflen, good
+}
+
+// A WriterMaker makes the writer of a filter of a type added with
+// RegisterWriterType from the properties of the filter, name to value.  Like
+// the built-in types it reports the problems on stderr, naming filename, and
+// returns false for an invalid filter.  If enabled is false the properties
+// are only checked and no writer is made.
+type WriterMaker func(filename string, props map[string]string, enabled bool) (LogWriter, bool)
+
+var (
+	writerMakersMu sync.RWMutex
+	writerMakers   = make(map[string]WriterMaker)
+)
+
+// RegisterWriterType adds a filter type to configurations, so that a writer
+// depending on packages the core does without lives in a package of its own,
+// e.g. the "eventlog" type of github.com/ccpaging/log4go/eventlog.  The
+// package usually registers its type from an init function.  Registering a
+// type again replaces its maker.  RegisterWriterType panics for a built-in
+// type.
+func RegisterWriterType(typ string, maker WriterMaker) {
+	switch typ {
+	case "console", "file", "xml", "socket", "syslog", "http":
+		panic("log4go: RegisterWriterType of built-in type " + typ)
+	}
+	writerMakersMu.Lock()
+	defer writerMakersMu.Unlock()
+	writerMakers[typ] = maker
+}
+
+func writerMaker(typ string) (WriterMaker, bool) {
+	writerMakersMu.RLock()
+	defer writerMakersMu.RUnlock()
+	maker, ok := writerMakers[typ]
+	return maker, ok
+}
+
+func propToRegisteredWriter(filename, typ string, maker WriterMaker, props []kvProperty, enabled bool) (LogWriter, bool) {
+	m := make(map[string]string, len(props))
+	for _, prop := range props {
+		m[prop.Name] = strings.Trim(prop.Value, " \r\n")
+	}
+	if format, ok := m["format"]; ok {
+		checkFormat(filename, typ, format)
+	}
+	return maker(filename, m, enabled)
+}
+
+// Add the default format of the configuration to the properties of a filter
+// which takes a format but sets none
+func withDefaultFormat(props []kvProperty, filtType, format string) []kvProperty {
+	if len(format) == 0 {
+		return props
+	}
+	switch filtType {
+	case "console", "file", "syslog", "eventlog": // eventlog is registered by its package
+	default:
+		return props
+	}
+	for _, prop := range props {
+		if prop.Name == "format" {
+			return props
+		}
+	}
+	return append([]kvProperty{{Name: "format", Value: format}}, props...)
+}
+
+// Warn about a format which is empty or has codes that render nothing
+func checkFormat(filename, filtType, format string) {
+	if len(format) == 0 {
+		fmt.Fprintf(stderr, "LoadConfig: Warning: Empty format for %s filter in %s\n", filtType, filename)
+		return
+	}
+	if unknown := unknownFormatVerbs(format); len(unknown) > 0 {
+		fmt.Fprintf(stderr, "LoadConfig: Warning: Unknown format codes %s in %q for %s filter in %s\n", strings.Join(unknown, " "), format, filtType, filename)
+	}
+}
+
+// Load the location named by a timezone property
+func propToLocation(filename, filtType, value string) (*time.Location, bool) {
+	loc, err := time.LoadLocation(strings.Trim(value, " \r\n"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "LoadConfig: Error: Invalid timezone %q for %s filter in %s: %s\n", value, filtType, filename, err)
+		return nil, false
+	}
+	return loc, true
 }
 
 func propToConsoleLogWriter(filename string, props []kvProperty, enabled bool) (*ConsoleLogWriter, bool) {
 	color := true
 	format := "[%D %T] [%L] (%S) %M"
+	var loc *time.Location
 	// Parse properties
 	for _, prop := range props {
 		switch prop.Name {
@@ -185,10 +544,16 @@ func propToConsoleLogWriter(filename string, props []kvProperty, enabled bool) (
 			color = strings.Trim(prop.Value, " \r\n") != "false"
 		case "format":
 			format = strings.Trim(prop.Value, " \r\n")
+		case "timezone":
+			var ok bool
+			if loc, ok = propToLocation(filename, "console", prop.Value); !ok {
+				return nil, false
+			}
 		default:
 			fmt.Fprintf(os.Stderr, "LoadConfig: Warning: Unknown property \"%s\" for console filter in %s\n", prop.Name, filename)
 		}
 	}
+	checkFormat(filename, "console", format)
 
 	// If it's disabled, we're just checking syntax
 	if !enabled {
@@ -198,6 +563,7 @@ func propToConsoleLogWriter(filename string, props []kvProperty, enabled bool) (
 	clw := NewConsoleLogWriter()
 	clw.SetColor(color)
 	clw.SetFormat(format)
+	clw.SetTimezone(loc)
 	return clw, true
 }
 
@@ -230,6 +596,9 @@ func propToFileLogWriter(filename string, props []kvProperty, enabled bool) (*Fi
 	rotate := false
 	maxbackup := 999
 	maxdays := 0
+	rotateonopen := false
+	var maxage time.Duration
+	var loc *time.Location
 
 	// Parse properties
 	for _, prop := range props {
@@ -250,6 +619,19 @@ func propToFileLogWriter(filename string, props []kvProperty, enabled bool) (*Fi
 			rotate = strings.Trim(prop.Value, " \r\n") != "false"
 		case "maxBackup":
 			maxbackup = strToNumSuffix(strings.Trim(prop.Value, " \r\n"), 1)
+		case "rotateonopen":
+			rotateonopen = strings.Trim(prop.Value, " \r\n") != "false"
+		case "maxage":
+			var err error
+			if maxage, err = time.ParseDuration(strings.Trim(prop.Value, " \r\n")); err != nil {
+				fmt.Fprintf(os.Stderr, "LoadConfig: Error: Invalid maxage %q for file filter in %s: %s\n", prop.Value, filename, err)
+				return nil, false
+			}
+		case "timezone":
+			var ok bool
+			if loc, ok = propToLocation(filename, "file", prop.Value); !ok {
+				return nil, false
+			}
 		default:
 			fmt.Fprintf(os.Stderr, "LoadConfig: Warning: Unknown property \"%s\" for file filter in %s\n", prop.Name, filename)
 		}
@@ -260,6 +642,7 @@ func propToFileLogWriter(filename string, props []kvProperty, enabled bool) (*Fi
 		fmt.Fprintf(os.Stderr, "LoadConfig: Error: Required property \"%s\" for file filter missing in %s\n", "filename", filename)
 		return nil, false
 	}
+	checkFormat(filename, "file", format)
 
 	// If it's disabled, we're just checking syntax
 	if !enabled {
@@ -276,6 +659,9 @@ func propToFileLogWriter(filename string, props []kvProperty, enabled bool) (*Fi
 	flw.SetRotateDays(maxdays)
 	flw.SetRotateDaily(daily)
 	flw.SetRotateBackup(maxbackup)
+	flw.SetMaxAge(maxage)
+	flw.SetRotateOnOpen(rotateonopen)
+	flw.SetTimezone(loc)
 	return flw, true
 }
 
@@ -322,7 +708,7 @@ func propToXMLLogWriter(filename string, props []kvProperty, enabled bool) (*Fil
 	return xlw, true
 }
 
-func propToSocketLogWriter(filename string, props []kvProperty, enabled bool) (*SocketLogWriter, bool) {
+func propToSocketLogWriter(filename string, props []kvProperty, enabled, strict bool) (*SocketLogWriter, bool) {
 	endpoint := ""
 	protocol := "udp"
 
@@ -333,6 +719,8 @@ func propToSocketLogWriter(filename string, props []kvProperty, enabled bool) (*
 			endpoint = strings.Trim(prop.Value, " \r\n")
 		case "protocol":
 			protocol = strings.Trim(prop.Value, " \r\n")
+		case "strict":
+			strict = strings.Trim(prop.Value, " \r\n") != "false"
 		default:
 			fmt.Fprintf(os.Stderr, "LoadConfig: Warning: Unknown property \"%s\" for file filter in %s\n", prop.Name, filename)
 		}
@@ -349,5 +737,167 @@ func propToSocketLogWriter(filename string, props []kvProperty, enabled bool) (*
 		return nil, true
 	}
 
-	return NewSocketLogWriter(protocol, endpoint), true
+	slw := NewSocketLogWriter(protocol, endpoint)
+	if strict {
+		if err := slw.Dial(); err != nil {
+			fmt.Fprintf(os.Stderr, "LoadConfig: Error: Could not connect to \"%s\" for socket filter in %s: %s\n", endpoint, filename, err)
+			return nil, false
+		}
+	}
+	return slw, true
+}
+
+func propToHTTPLogWriter(filename string, props []kvProperty, enabled bool) (*HTTPLogWriter, bool) {
+	url := ""
+	batchsize := 0
+	cacert, cert, key, token := "", "", "", ""
+	good := true
+
+	// Parse properties
+	for _, prop := range props {
+		switch prop.Name {
+		case "url":
+			url = strings.Trim(prop.Value, " \r\n")
+		case "batchsize":
+			n, err := strconv.Atoi(strings.Trim(prop.Value, " \r\n"))
+			if err != nil || n < 1 {
+				fmt.Fprintf(os.Stderr, "LoadConfig: Error: Invalid batchsize %q for http filter in %s\n", prop.Value, filename)
+				good = false
+				continue
+			}
+			batchsize = n
+		case "cacert":
+			cacert = strings.Trim(prop.Value, " \r\n")
+		case "cert":
+			cert = strings.Trim(prop.Value, " \r\n")
+		case "key":
+			key = strings.Trim(prop.Value, " \r\n")
+		case "token":
+			token = strings.Trim(prop.Value, " \r\n")
+		default:
+			fmt.Fprintf(os.Stderr, "LoadConfig: Warning: Unknown property \"%s\" for http filter in %s\n", prop.Name, filename)
+		}
+	}
+
+	// Check properties
+	if len(url) == 0 {
+		fmt.Fprintf(os.Stderr, "LoadConfig: Error: Required property \"%s\" for http filter missing in %s\n", "url", filename)
+		return nil, false
+	}
+
+	// The files are checked even if it's disabled
+	tlsConfig, err := loadTLSConfig(cacert, cert, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "LoadConfig: Error: Invalid TLS properties for http filter in %s: %s\n", filename, err)
+		good = false
+	}
+
+	// If it's disabled, we're just checking syntax
+	if !good || !enabled {
+		return nil, good
+	}
+
+	hlw := NewHTTPLogWriter(url)
+	if batchsize > 0 {
+		hlw.SetBatchSize(batchsize)
+	}
+	if len(cacert) > 0 || len(cert) > 0 {
+		hlw.SetTLSConfig(tlsConfig)
+	}
+	if len(token) > 0 {
+		hlw.SetToken(token)
+	}
+	return hlw, true
+}
+
+// DumpConfig describes the filters of the logger as a configuration in the
+// given format, "xml" or "json", which LoadConfigBuf reads back.  Only the
+// console, file and socket writers can be described; a file writer is
+// described as a "file" filter even if it writes XML.
+func (log Logger) DumpConfig(format string) ([]byte, error) {
+	tags := make([]string, 0, len(log))
+	for tag := range log {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	cfg := &Config{}
+	for _, tag := range tags {
+		filt := log[tag]
+		kvfilt := kvFilter{
+			Enabled: "true",
+			Tag:     tag,
+			Level:   levelName(filt.level()),
+		}
+
+		var props []kvProperty
+		prop := func(name string, value interface{}) {
+			props = append(props, kvProperty{Name: name, Value: fmt.Sprint(value)})
+		}
+		switch w := filt.LogWriter.(type) {
+		case *ConsoleLogWriter:
+			if w.formatter != nil {
+				return nil, fmt.Errorf("DumpConfig: filter %q: a Formatter cannot be described in a configuration", tag)
+			}
+			kvfilt.Type = "console"
+			prop("color", w.color)
+			prop("format", w.format)
+			if w.loc != nil {
+				prop("timezone", w.loc)
+			}
+		case *FileLogWriter:
+			if w.formatter != nil {
+				return nil, fmt.Errorf("DumpConfig: filter %q: a Formatter cannot be described in a configuration", tag)
+			}
+			kvfilt.Type = "file"
+			prop("filename", w.filename)
+			prop("format", w.format)
+			prop("maxlines", w.maxlines)
+			prop("maxsize", w.maxsize)
+			prop("maxdays", w.maxdays)
+			prop("daily", w.daily)
+			prop("rotate", w.rotate)
+			prop("maxBackup", w.maxbackup)
+			if w.maxage > 0 {
+				prop("maxage", w.maxage)
+			}
+			if w.rotateOnOpen {
+				prop("rotateonopen", true)
+			}
+			if w.loc != nil {
+				prop("timezone", w.loc)
+			}
+		case *SocketLogWriter:
+			kvfilt.Type = "socket"
+			endpoint, _ := w.GetOption("endpoint")
+			protocol, _ := w.GetOption("protocol")
+			prop("endpoint", endpoint)
+			prop("protocol", protocol)
+		default:
+			return nil, fmt.Errorf("DumpConfig: filter %q: %T cannot be described in a configuration", tag, w)
+		}
+		if filt.direct {
+			prop("async", false)
+		} else if filt.QueueCap() != DefaultBufferLength {
+			prop("buffer", filt.QueueCap())
+		}
+		kvfilt.Properties = props
+		cfg.Filters = append(cfg.Filters, kvfilt)
+	}
+
+	switch format {
+	case "xml":
+		// The root is named here only: a configuration loads whatever its
+		// root is called
+		var out bytes.Buffer
+		enc := xml.NewEncoder(&out)
+		enc.Indent("", "  ")
+		if err := enc.EncodeElement(cfg, xml.StartElement{Name: xml.Name{Local: "logging"}}); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	case "json":
+		return json.MarshalIndent(cfg, "", "  ")
+	}
+	return nil, fmt.Errorf("DumpConfig: unknown config format %q, xml or json are supported", format)
 }
@@ -0,0 +1,186 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A stack trace seen by a StackDedupLogWriter
+type stackSeen struct {
+	first *LogRecord // first occurrence in the current window
+	count int        // repeats dropped since
+}
+
+// This log writer collapses repeated stack traces, e.g. from panic recovery
+// during an outage.  The first record with a given multi-line message is
+// written in full; identical ones within the window are dropped and counted,
+// and a single "same error ×N" record is written when the window is over.
+// Single-line records are passed through.
+type StackDedupLogWriter struct {
+	w      LogWriter
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[uint64]*stackSeen
+}
+
+// NewStackDedupLogWriter wraps w, collapsing identical stacks within window.
+func NewStackDedupLogWriter(w LogWriter, window time.Duration) *StackDedupLogWriter {
+	return &StackDedupLogWriter{
+		w:      w,
+		window: window,
+		seen:   make(map[uint64]*stackSeen),
+	}
+}
+
+// Hash of a message, the key of its stack
+func stackKey(msg string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(msg))
+	return h.Sum64()
+}
+
+// Write the summary of a stack that was repeated
+func (d *StackDedupLogWriter) summarize(s *stackSeen) {
+	if s.count == 0 {
+		return
+	}
+	first := s.first.Message
+	if i := strings.IndexByte(first, '\n'); i >= 0 {
+		first = first[:i]
+	}
+	d.w.LogWrite(&LogRecord{
+		Level:   s.first.Level,
+		Created: timeNow(),
+		Source:  s.first.Source,
+		Message: fmt.Sprintf("same error ×%d: %s", s.count, first),
+	})
+}
+
+func (d *StackDedupLogWriter) LogWrite(rec *LogRecord) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Close the windows which are over
+	for key, s := range d.seen {
+		if rec.Created.Sub(s.first.Created) >= d.window {
+			d.summarize(s)
+			delete(d.seen, key)
+		}
+	}
+
+	if !strings.Contains(strings.TrimRight(rec.Message, "\n"), "\n") {
+		d.w.LogWrite(rec)
+		return
+	}
+	key := stackKey(rec.Message)
+	if s, ok := d.seen[key]; ok {
+		if s.first.Message == rec.Message {
+			s.count++
+			return
+		}
+		// Another stack with the same hash
+		d.summarize(s)
+	}
+	d.seen[key] = &stackSeen{first: rec}
+	d.w.LogWrite(rec)
+}
+
+// Close writes the summaries of the open windows and closes the wrapped
+// writer.
+func (d *StackDedupLogWriter) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, s := range d.seen {
+		d.summarize(s)
+		delete(d.seen, key)
+	}
+	d.w.Close()
+}
+
+// This log writer suppresses consecutive records with the same source and
+// message, e.g. from a noisy loop.  The first record is written; its repeats
+// within the window are counted, and a "last message repeated N times" record
+// is written once another record arrives, the window since the first repeat
+// is over, or the writer is closed.
+type DedupLogWriter struct {
+	w      LogWriter
+	window time.Duration
+
+	mu    sync.Mutex
+	last  *LogRecord  // last record written
+	count int         // repeats of last dropped since
+	timer *time.Timer // writes the summary when the window is over
+	runs  uint64      // runs of repeats so far, tells a stale timer apart
+}
+
+// NewDedupWriter wraps w, suppressing consecutive identical records within
+// window.
+func NewDedupWriter(w LogWriter, window time.Duration) *DedupLogWriter {
+	return &DedupLogWriter{
+		w:      w,
+		window: window,
+	}
+}
+
+func (d *DedupLogWriter) LogWrite(rec *LogRecord) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.last != nil && rec.Source == d.last.Source && rec.Message == d.last.Message &&
+		rec.Created.Sub(d.last.Created) < d.window {
+		d.count++
+		if d.timer == nil {
+			d.runs++
+			run := d.runs
+			d.timer = time.AfterFunc(d.window, func() { d.expire(run) })
+		}
+		return
+	}
+	d.summarize()
+	d.last = rec
+	d.w.LogWrite(rec)
+}
+
+// Write the summary when the window of the repeats is over.  The next
+// record is written even if it repeats the last one.
+func (d *DedupLogWriter) expire(run uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer == nil || d.runs != run {
+		return
+	}
+	d.summarize()
+	d.last = nil
+}
+
+// Write the summary of the repeats of the last record, if any
+func (d *DedupLogWriter) summarize() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	if d.count == 0 {
+		return
+	}
+	d.w.LogWrite(&LogRecord{
+		Level:   d.last.Level,
+		Created: timeNow(),
+		Source:  d.last.Source,
+		Message: fmt.Sprintf("last message repeated %d times", d.count),
+	})
+	d.count = 0
+}
+
+// Close writes the summary of the repeats and closes the wrapped writer.
+func (d *DedupLogWriter) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.summarize()
+	d.w.Close()
+}
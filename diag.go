@@ -0,0 +1,66 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// DiagnosticsPerSecond caps the messages the package writes to stderr about
+// its own errors, e.g. a full disk or an unreachable socket, across all
+// writers.  Messages above the cap are counted and summed up once the second
+// is over, so that an outage cannot flood stderr at the rate of the logging.
+// Zero means no cap.
+var DiagnosticsPerSecond = 20
+
+// A writer passing at most DiagnosticsPerSecond writes per second to w
+type throttledWriter struct {
+	mu         sync.Mutex
+	w          io.Writer
+	window     time.Time // start of the current second
+	written    int
+	suppressed int
+	summary    *time.Timer
+}
+
+func newThrottledWriter(w io.Writer) *throttledWriter {
+	return &throttledWriter{w: w}
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := timeNow()
+	if now.Sub(t.window) >= time.Second {
+		t.writeSummary()
+		t.window, t.written = now, 0
+	}
+	if max := DiagnosticsPerSecond; max > 0 && t.written >= max {
+		t.suppressed++
+		if t.summary == nil {
+			t.summary = time.AfterFunc(t.window.Add(time.Second).Sub(now), t.flush)
+		}
+		return len(p), nil
+	}
+	t.written++
+	return t.w.Write(p)
+}
+
+// Write the summary when the second of the suppressed messages is over
+func (t *throttledWriter) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.summary = nil
+	t.writeSummary()
+}
+
+func (t *throttledWriter) writeSummary() {
+	if t.suppressed > 0 {
+		fmt.Fprintf(t.w, "log4go: %d diagnostics suppressed\n", t.suppressed)
+		t.suppressed = 0
+	}
+}
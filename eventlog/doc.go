@@ -0,0 +1,22 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+// Package eventlog sends log4go records to the Windows Event Log.  The
+// golang.org/x/sys dependency is confined to this package.  Importing it adds
+// the "eventlog" filter type to configurations, with the properties "source",
+// the program name by default, and "format":
+//
+//	import _ "github.com/ccpaging/log4go/eventlog"
+//
+// On other systems an eventlog filter fails to load.
+package eventlog
+
+import (
+	l4g "github.com/ccpaging/log4go"
+)
+
+// Format of the events without a format of their own
+const defaultFormat = "(%S) %M"
+
+func init() {
+	l4g.RegisterWriterType("eventlog", propToEventLogWriter)
+}
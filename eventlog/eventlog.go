@@ -0,0 +1,108 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build windows
+// +build windows
+
+package eventlog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	l4g "github.com/ccpaging/log4go"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// Event id of the events written by an EventLogWriter
+const eventLogID = 1
+
+// This log writer sends output to the Windows Event Log.  CRITICAL and ERROR
+// records become Error events, WARNING records Warning events and the rest
+// Information events.
+type EventLogWriter struct {
+	l *eventlog.Log
+
+	// The logging format
+	compiled *l4g.CompiledFormat
+}
+
+// NewEventLogWriter writes events under source, the program name if empty.
+// The source is registered in the Application log if possible, which needs
+// administrator rights the first time; events of an unregistered source are
+// still written.  Returns nil if the event log cannot be opened.
+func NewEventLogWriter(source string) *EventLogWriter {
+	if len(source) == 0 {
+		source = strings.TrimSuffix(filepath.Base(os.Args[0]), filepath.Ext(os.Args[0]))
+	}
+	// Fails if the source is registered already
+	eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Warning|eventlog.Info)
+
+	l, err := eventlog.Open(source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "EventLogWriter(%s): %s\n", source, err)
+		return nil
+	}
+	return &EventLogWriter{
+		l:        l,
+		compiled: l4g.CompileFormat(defaultFormat),
+	}
+}
+
+// Set the format of the message (chainable).  Must be called before the
+// first log message is written.
+func (e *EventLogWriter) SetFormat(format string) *EventLogWriter {
+	e.compiled = l4g.CompileFormat(format)
+	return e
+}
+
+func (e *EventLogWriter) LogWrite(rec *l4g.LogRecord) {
+	msg := strings.TrimSuffix(e.compiled.Format(rec), "\n")
+
+	var err error
+	switch {
+	case rec.Level >= l4g.ERROR:
+		err = e.l.Error(eventLogID, msg)
+	case rec.Level == l4g.WARNING:
+		err = e.l.Warning(eventLogID, msg)
+	default:
+		err = e.l.Info(eventLogID, msg)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "EventLogWriter: %s\n", err)
+	}
+}
+
+func (e *EventLogWriter) Close() {
+	e.l.Close()
+}
+
+func propToEventLogWriter(filename string, props map[string]string, enabled bool) (l4g.LogWriter, bool) {
+	source := ""
+	format := defaultFormat
+
+	// Parse properties
+	for name, value := range props {
+		switch name {
+		case "source":
+			source = value
+		case "format":
+			format = value
+		default:
+			fmt.Fprintf(os.Stderr, "LoadConfig: Warning: Unknown property \"%s\" for eventlog filter in %s\n", name, filename)
+		}
+	}
+
+	// If it's disabled, we're just checking syntax
+	if !enabled {
+		return nil, true
+	}
+
+	elw := NewEventLogWriter(source)
+	if elw == nil {
+		return nil, false
+	}
+	elw.SetFormat(format)
+	return elw, true
+}
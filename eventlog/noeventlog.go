@@ -0,0 +1,19 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build !windows
+// +build !windows
+
+package eventlog
+
+import (
+	"fmt"
+	"os"
+
+	l4g "github.com/ccpaging/log4go"
+)
+
+// The Event Log exists on Windows only
+func propToEventLogWriter(filename string, props map[string]string, enabled bool) (l4g.LogWriter, bool) {
+	fmt.Fprintf(os.Stderr, "LoadConfig: Error: eventlog filters are not supported on this system in %s\n", filename)
+	return nil, false
+}
@@ -0,0 +1,28 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build !windows
+// +build !windows
+
+package eventlog
+
+import (
+	"strings"
+	"testing"
+
+	l4g "github.com/ccpaging/log4go"
+)
+
+func TestEventLogUnsupported(t *testing.T) {
+	cfg := `<logging>
+  <filter enabled="true">
+    <tag>eventlog</tag>
+    <type>eventlog</type>
+    <level>WARNING</level>
+  </filter>
+</logging>`
+	l := make(l4g.Logger)
+	err := l.LoadConfigReader(strings.NewReader(cfg), "xml")
+	if err == nil || len(l) != 0 {
+		t.Errorf("LoadConfigReader = %v with %d filters, want an error", err, len(l))
+	}
+}
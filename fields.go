@@ -0,0 +1,218 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Field is a key/value pair attached to a LogRecord
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Fields holds key/value pairs in the order they were first added, so that
+// identical log calls render identical output.  Keys are unique.
+type Fields []Field
+
+// Set the value of key, replacing the value in place if the key is already
+// present (last wins) or appending it otherwise.  Returns the updated fields.
+func (fs Fields) Set(key string, value interface{}) Fields {
+	for i := range fs {
+		if fs[i].Key == key {
+			fs[i].Value = value
+			return fs
+		}
+	}
+	return append(fs, Field{Key: key, Value: value})
+}
+
+// Make Fields from the given pairs, dropping repeated keys (last wins)
+func newFields(fields []Field) Fields {
+	if len(fields) == 0 {
+		return nil
+	}
+	fs := make(Fields, 0, len(fields))
+	for _, f := range fields {
+		fs = fs.Set(f.Key, f.Value)
+	}
+	return fs
+}
+
+// Make Fields from a map, sorted by key as maps have no order
+func mapFields(fields map[string]interface{}) []Field {
+	if len(fields) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fs := make([]Field, len(keys))
+	for i, key := range keys {
+		fs[i] = Field{Key: key, Value: fields[key]}
+	}
+	return fs
+}
+
+// ParseKeyValues takes the space separated key=value pairs out of msg, e.g.
+// "login user=42 action=login" gives "login" and the fields user and action.
+// Values are strings and end at the next space.  For Logger.SetMessageParser.
+func ParseKeyValues(msg string) (string, []Field) {
+	var rest []string
+	var fields []Field
+	for _, word := range strings.Fields(msg) {
+		if i := strings.IndexByte(word, '='); i > 0 {
+			fields = append(fields, Field{Key: word[:i], Value: word[i+1:]})
+		} else {
+			rest = append(rest, word)
+		}
+	}
+	if len(fields) == 0 {
+		return msg, nil
+	}
+	return strings.Join(rest, " "), fields
+}
+
+// String renders the fields as space separated key=value pairs
+func (fs Fields) String() string {
+	out := bytes.NewBuffer(make([]byte, 0, 16*len(fs)))
+	for i, f := range fs {
+		if i > 0 {
+			out.WriteByte(' ')
+		}
+		fmt.Fprintf(out, "%s=%v", f.Key, f.Value)
+	}
+	return out.String()
+}
+
+// MarshalJSON encodes the fields as a JSON object keeping their order.  A
+// value JSON cannot encode, e.g. a func or a channel, is replaced by the
+// string "<unserializable: TYPE>", so that the record is not lost.
+func (fs Fields) MarshalJSON() ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, 16*len(fs)+2))
+	out.WriteByte('{')
+	for i, f := range fs {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		key, err := json.Marshal(f.Key)
+		if err != nil {
+			return nil, err
+		}
+		val, err := json.Marshal(f.Value)
+		if err != nil {
+			val, _ = json.Marshal(fmt.Sprintf("<unserializable: %T>", f.Value))
+		}
+		out.Write(key)
+		out.WriteByte(':')
+		out.Write(val)
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+// The keys of a record in JSON, which a field cannot take
+var recordJSONKeys = map[string]bool{
+	"Level":   true,
+	"Created": true,
+	"Source":  true,
+	"Message": true,
+	"Binary":  true,
+}
+
+// The prefix of a field named like a key of the record in JSON
+const jsonFieldPrefix = "fields."
+
+// A LogRecord in JSON without its fields
+type jsonRecord struct {
+	Level   Level
+	Created time.Time
+	Source  string
+	Message string
+	Binary  []byte `json:",omitempty"`
+}
+
+// MarshalJSON encodes the record as a JSON object with the fields as
+// top-level keys after those of the record, in order.  A field named like a
+// key of the record, e.g. "Message", is written as "fields.Message".
+func (rec *LogRecord) MarshalJSON() ([]byte, error) {
+	js, err := json.Marshal(&jsonRecord{
+		Level:   rec.Level,
+		Created: rec.Created,
+		Source:  rec.Source,
+		Message: rec.Message,
+		Binary:  rec.Binary,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return appendJSONFields(js, rec.Fields)
+}
+
+// Add the fields to the top level of the JSON object js
+func appendJSONFields(js []byte, fs Fields) ([]byte, error) {
+	if len(fs) == 0 {
+		return js, nil
+	}
+	top := make(Fields, len(fs))
+	for i, f := range fs {
+		if recordJSONKeys[f.Key] {
+			f.Key = jsonFieldPrefix + f.Key
+		}
+		top[i] = f
+	}
+	obj, err := top.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	js = append(js[:len(js)-1], ',')
+	return append(js, obj[1:]...), nil
+}
+
+// UnmarshalJSON decodes a record encoded by MarshalJSON, taking the keys
+// other than those of the record as its fields, in order.
+func (rec *LogRecord) UnmarshalJSON(data []byte) error {
+	var jr jsonRecord
+	if err := json.Unmarshal(data, &jr); err != nil {
+		return err
+	}
+	*rec = LogRecord{
+		Level:   jr.Level,
+		Created: jr.Created,
+		Source:  jr.Source,
+		Message: jr.Message,
+		Binary:  jr.Binary,
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		var value interface{}
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		if recordJSONKeys[key] {
+			continue
+		}
+		if name := strings.TrimPrefix(key, jsonFieldPrefix); recordJSONKeys[name] {
+			key = name
+		}
+		rec.Fields = rec.Fields.Set(key, value)
+	}
+	return nil
+}
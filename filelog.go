@@ -3,10 +3,17 @@
 package log4go
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,11 +24,19 @@ type FileLogWriter struct {
 	file     *os.File
 
 	// The logging format
-	format string
+	format   string
+	compiled *CompiledFormat
+	loc      *time.Location
+
+	// Replaces the format if set
+	formatter Formatter
 
 	// File header/trailer
 	header, trailer string
 
+	// Bytes starting every new file, before the header
+	magic []byte
+
 	// Rotate at linecount
 	maxlines          int
 	maxlines_curlines int
@@ -33,6 +48,9 @@ type FileLogWriter struct {
 	// Max days for log file storage
 	maxdays int
 
+	// Max age of the rotated log files
+	maxage time.Duration
+
 	// Rotate daily
 	daily          bool
 	daily_opendate time.Time
@@ -40,14 +58,72 @@ type FileLogWriter struct {
 	// Keep old logfiles (.001, .002, etc)
 	rotate bool
 	maxbackup int
+
+	// Rotate the file of a previous run before the first write
+	rotateOnOpen bool
+	written      bool
+
+	// The file was closed to give back its descriptor, see release
+	released bool
+
+	// Write through to stable storage
+	directSync bool
+
+	// Prune rotated logs while the free disk space is below minfree
+	minfree   int64
+	freecheck time.Time
+
+	// The file is a named pipe read by another process
+	fifo bool
+
+	// Sync the file at least every maxflush
+	maxflush     time.Duration
+	lastflush    time.Time
+	flushPending int32    // a sync is scheduled
+	syncing      *os.File // the file of the scheduled sync, guarded by bufMu
+
+	// Rotation requested through RotateTrigger, holds one request
+	trigger chan struct{}
+
+	// Records which cannot be written while the file cannot be opened
+	fallback io.Writer
+	failing  bool      // the last open failed
+	retryAt  time.Time // the next try to open the file
+
+	// Records are buffered and flushed every flushEvery, if set
+	flushEvery time.Duration
+	flushDone  chan struct{}
+	bufMu      sync.Mutex
+	buf        *bufio.Writer
 }
 
+// Least time between tries to reopen a log file which could not be opened
+var fileRetryInterval = time.Second
+
+// How often a FileLogWriter with a minimum free space checks the disk
+var freeSpaceInterval = time.Minute
+
+// Free space query, replaced in tests
+var diskFree = diskFreeSpace
+
+// File sync, replaced in tests
+var syncFile = (*os.File).Sync
+
 func (w *FileLogWriter) Close() {
+	if w.flushDone != nil {
+		close(w.flushDone)
+		w.flushDone = nil
+	}
+	w.flushBuffer()
+	if w.released && len(w.trailer) > 0 {
+		w.reopen()
+	}
 	if w.file == nil {
 		return
 	}
 	fmt.Fprint(w.file, FormatLogRecord(w.trailer, &LogRecord{Created: time.Now()}))
 	w.file.Sync()
+	w.cancelSync()
 	w.file.Close()
 }
 
@@ -58,85 +134,324 @@ func (w *FileLogWriter) Close() {
 // with a .### extension to preserve it.  The various Set* methods can be used
 // to configure log rotation based on lines, size, and daily.
 //
+// A leading "~/" in the file name stands for the home directory of the user.
+//
 // The standard log-line format is:
 //   [%D %T] [%L] (%S) %M
 func NewFileLogWriter(fname string, rotate bool) *FileLogWriter {
+	fname, err := expandHome(fname)
+	if err != nil {
+		fmt.Fprintf(stderr, "FileLogWriter(%s): %s\n", fname, err)
+		return nil
+	}
 	w := &FileLogWriter{
 		filename: fname,
 		format:   "[%D %z %T] [%L] (%S) %M",
 		rotate:   rotate,
 		maxbackup: 999,
+		fallback: os.Stderr,
 	}
 
 	// open the file for the first time
 	if err := w.intRotate(); err != nil {
-		fmt.Fprintf(os.Stderr, "FileLogWriter(%s): %s\n", w.filename, err)
+		fmt.Fprintf(stderr, "FileLogWriter(%s): %s\n", w.filename, err)
 		return nil
 	}
 	return w
 }
 
 func (w *FileLogWriter) LogWrite(rec *LogRecord) {
+	if err := w.LogWriteErr(rec); err != nil && err != errFileNotOpen {
+		fmt.Fprintf(stderr, "FileLogWriter(%q): %s\n", w.filename, err)
+	}
+}
+
+// Replace a leading "~" or "~/" of a file name with the home directory.
+// "~user/" forms are not supported.
+func expandHome(fname string) (string, error) {
+	if !strings.HasPrefix(fname, "~") {
+		return fname, nil
+	}
+	if len(fname) > 1 && fname[1] != '/' && !os.IsPathSeparator(fname[1]) {
+		return fname, errors.New("home directories of other users (~user) are not supported")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fname, err
+	}
+	return filepath.Join(home, fname[1:]), nil
+}
+
+// Returned while the log file could not be (re)opened
+var errFileNotOpen = errors.New("log file is not open")
+
+// LogWriteErr writes the record like LogWrite, but returns any error instead
+// of printing it.
+func (w *FileLogWriter) LogWriteErr(rec *LogRecord) error {
 	now := time.Now()
 
-	if (w.maxlines > 0 && w.maxlines_curlines >= w.maxlines) ||
+	if w.fifo {
+		return w.writeFIFO(rec)
+	}
+
+	if w.minfree > 0 && now.Sub(w.freecheck) >= freeSpaceInterval {
+		w.freecheck = now
+		w.ensureFreeSpace()
+	}
+
+	// The file could not be reopened, wait before the next try
+	if w.file == nil && now.Before(w.retryAt) {
+		return w.writeFallback(rec)
+	}
+
+	if w.released {
+		if err := w.reopen(); err != nil {
+			return w.openFailed(err, now, rec)
+		}
+	}
+
+	if w.file == nil || w.triggered() ||
+		(w.rotateOnOpen && !w.written && w.maxsize_cursize > 0) ||
+		(w.maxlines > 0 && w.maxlines_curlines >= w.maxlines) ||
 		(w.maxsize > 0 && w.maxsize_cursize >= w.maxsize) ||
-		(w.daily && now.Day() != w.daily_opendate.Day()) {
+		(w.daily && !sameDay(now, w.daily_opendate)) {
 		// open the file for the first time
-		if err := w.intRotate(); err != nil {
-			fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.filename, err)
-			return
+		if err := w.intRotate(); err != nil && w.file == nil {
+			return w.openFailed(err, now, rec)
+		} else if err != nil {
+			return err
+		}
+		if w.failing {
+			w.failing = false
+			fmt.Fprintf(stderr, "FileLogWriter(%q): reopened\n", w.filename)
 		}
 	}
 
 	if w.file == nil {
-		return
+		return errFileNotOpen
 	}
+	w.written = true
 
 	// Perform the write
-	n, err := fmt.Fprint(w.file, FormatLogRecord(w.format, rec))
+	out, err := w.render(rec)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.filename, err)
-		return
+		return err
+	}
+	n, err := w.writeBuffered(out)
+	if err != nil {
+		return err
+	}
+	if w.directSync && syncOpenFlag == 0 {
+		w.flushBuffer()
+		w.file.Sync()
+	}
+	if w.maxflush > 0 {
+		w.boundFlush()
 	}
 
 	// Update the counts
 	w.maxlines_curlines++
 	w.maxsize_cursize += n
+	return nil
+}
+
+// Format a record with the formatter or the format
+func (w *FileLogWriter) render(rec *LogRecord) ([]byte, error) {
+	if w.formatter != nil {
+		return w.formatter(rec)
+	}
+	w.compiled = compiledFor(w.compiled, w.format, w.loc)
+	return []byte(w.compiled.Format(rec)), nil
+}
+
+// Note that the log file could not be (re)opened.  The error is reported once
+// until the file is open again, and opening is retried at most every
+// fileRetryInterval; the records meanwhile go to the fallback.
+func (w *FileLogWriter) openFailed(err error, now time.Time, rec *LogRecord) error {
+	w.retryAt = now.Add(fileRetryInterval)
+	if !w.failing {
+		w.failing = true
+		fmt.Fprintf(stderr, "FileLogWriter(%q): %s, writing to the fallback until the file can be opened\n", w.filename, err)
+	}
+	return w.writeFallback(rec)
+}
+
+// Write a record which cannot be written to the file to the fallback
+func (w *FileLogWriter) writeFallback(rec *LogRecord) error {
+	if w.fallback == nil {
+		return errFileNotOpen
+	}
+	out, err := w.render(rec)
+	if err != nil {
+		return err
+	}
+	_, err = w.fallback.Write(out)
+	return err
+}
+
+// Write to the buffer if records are buffered, else to the file.  Direct
+// sync wins over buffering.
+func (w *FileLogWriter) writeBuffered(out []byte) (int, error) {
+	if w.flushEvery <= 0 || w.directSync {
+		return w.file.Write(out)
+	}
+	w.bufMu.Lock()
+	defer w.bufMu.Unlock()
+	if w.buf == nil {
+		w.buf = bufio.NewWriter(w.file)
+	}
+	return w.buf.Write(out)
+}
+
+// Write the buffered records to the file, before the file is written to
+// directly, synced, rotated or closed
+func (w *FileLogWriter) flushBuffer() {
+	w.bufMu.Lock()
+	defer w.bufMu.Unlock()
+	if w.buf == nil {
+		return
+	}
+	if err := w.buf.Flush(); err != nil {
+		fmt.Fprintf(stderr, "FileLogWriter(%q): %s\n", w.filename, err)
+	}
+	w.buf = nil
+}
+
+// Flush writes the records buffered by SetFlushInterval to the file.
+func (w *FileLogWriter) Flush() {
+	w.flushBuffer()
+}
+
+// Sync the file if the last sync is maxflush ago, and make sure a sync
+// follows within maxflush if no more records are written
+func (w *FileLogWriter) boundFlush() {
+	if now := timeNow(); now.Sub(w.lastflush) >= w.maxflush {
+		w.flushBuffer()
+		syncFile(w.file)
+		w.lastflush = now
+	}
+	// The file may have been rotated since the sync was scheduled
+	w.bufMu.Lock()
+	w.syncing = w.file
+	w.bufMu.Unlock()
+	if atomic.CompareAndSwapInt32(&w.flushPending, 0, 1) {
+		time.AfterFunc(w.maxflush, w.scheduledSync)
+	}
+}
+
+// Write out the buffer and sync the file, unless the file was closed since
+// the sync was scheduled
+func (w *FileLogWriter) scheduledSync() {
+	w.flushBuffer()
+	w.bufMu.Lock()
+	defer w.bufMu.Unlock()
+	f := w.syncing
+	w.syncing = nil
+	atomic.StoreInt32(&w.flushPending, 0)
+	if f != nil {
+		syncFile(f)
+	}
+}
+
+// Drop the scheduled sync of the file before it is closed
+func (w *FileLogWriter) cancelSync() {
+	w.bufMu.Lock()
+	w.syncing = nil
+	w.bufMu.Unlock()
+}
+
+// Write a record to a named pipe.  The pipe is reopened when its reader went
+// away; while no reader is connected the records are dropped.
+func (w *FileLogWriter) writeFIFO(rec *LogRecord) error {
+	out, err := w.render(rec)
+	if err != nil {
+		return err
+	}
+	msg := string(out)
+
+	for try := 0; try < 2; try++ {
+		if w.file == nil && w.openFIFO() != nil {
+			return errFileNotOpen
+		}
+		if _, err = fmt.Fprint(w.file, msg); err == nil {
+			return nil
+		}
+		// Broken pipe, a new reader may be waiting
+		w.file.Close()
+		w.file = nil
+	}
+	return err
+}
+
+// Open the named pipe for writing.  It is never created: if it disappeared,
+// no regular file takes its place.
+func (w *FileLogWriter) openFIFO() error {
+	fd, err := os.OpenFile(w.filename, os.O_WRONLY|fifoOpenFlag, 0)
+	if err != nil {
+		return err
+	}
+	w.file = fd
+	fmt.Fprint(w.file, FormatLogRecord(w.header, &LogRecord{Created: time.Now()}))
+	return nil
 }
 
 // If this is called in a threaded context, it MUST be synchronized
 func (w *FileLogWriter) intRotate() error {
+	// Nothing was written since the file was opened, e.g. when rotations for
+	// size and time are requested together: rotating again would only leave
+	// an empty file behind
+	if w.file != nil && !w.fifo && w.maxlines_curlines == 0 && w.maxsize_cursize == 0 {
+		w.daily_opendate = time.Now()
+		return nil
+	}
+
 	// Close any log file that may be open
+	w.flushBuffer()
 	if w.file != nil {
 		fmt.Fprint(w.file, FormatLogRecord(w.trailer, &LogRecord{Created: time.Now()}))
+		w.cancelSync()
 		w.file.Close()
+		w.file = nil
+	}
+
+	// Named pipes are not rotated
+	if fi, err := os.Stat(w.filename); err == nil && fi.Mode()&os.ModeNamedPipe != 0 {
+		w.fifo = true
+	}
+	if w.fifo {
+		if err := w.openFIFO(); err != nil && !isFIFONoReader(err) {
+			return err
+		}
+		return nil
 	}
 
 	// fmt.Fprintf(os.Stderr, "FileLogWriter: %v\n", w)
 	now := time.Now()
-	if w.rotate {
+	if w.rotate || (w.rotateOnOpen && !w.written) {
 		_, err := os.Lstat(w.filename)
 		if err == nil {
-			// We are keeping log files, move it to the next available number
+			// We are keeping log files, move it to the number after the
+			// highest one of the day, so that a gap left by a deleted file
+			// is not filled with a newer file
 			todate := now.Format("2006-01-02")
-			if w.daily && now.Day() != w.daily_opendate.Day() {
+			if w.daily && !sameDay(now, w.daily_opendate) {
 				// rename as opendate
 				todate = w.daily_opendate.Format("2006-01-02")
 			}
 
-			renameto := ""
-			for num := 1; err == nil && num <= w.maxbackup; num++ {
-				renameto = w.filename + fmt.Sprintf(".%s.%03d", todate, num)
-				_, err = os.Lstat(renameto)
+			num := 1
+			for _, name := range w.rotatedLogs() {
+				if n, ok := rotatedNumber(w.filename, name, todate); ok && n >= num {
+					num = n + 1
+				}
 			}
-
-			if err != nil {	// Rename the file to its new
-				os.Rename(w.filename, renameto)
+			if name, ok := w.freeRotatedName(todate, num); w.maxbackup > 0 && ok {
+				os.Rename(w.filename, name)
 				// Continue even failed
 			} // else no free log file name to rotate
 
 		}
+		w.pruneBackups()
 	}
 
 	if w.maxdays > 0 {
@@ -159,17 +474,75 @@ func (w *FileLogWriter) intRotate() error {
 	w.maxlines_curlines = 0
 
 	// Open the log file
-	fd, err := os.OpenFile(w.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+	fd, err := os.OpenFile(w.filename, w.openFlags(), 0660)
 	if err != nil {
 		w.file = nil
 		return err
 	}
 	w.file = fd
 
+	w.writeMagic()
 	fmt.Fprint(w.file, FormatLogRecord(w.header, &LogRecord{Created: now}))
 	return nil
 }
 
+// Close the file to give back its descriptor, e.g. for a
+// ShardedFileLogWriter with too many files open.  The next write reopens the
+// file where it left off, without rotating it or writing the header again.
+func (w *FileLogWriter) release() {
+	w.flushBuffer()
+	if w.file == nil || w.fifo {
+		return
+	}
+	w.cancelSync()
+	w.file.Close()
+	w.file = nil
+	w.released = true
+}
+
+// Reopen the file closed by release
+func (w *FileLogWriter) reopen() error {
+	fd, err := os.OpenFile(w.filename, w.openFlags(), 0660)
+	if err != nil {
+		return err
+	}
+	w.file = fd
+	w.released = false
+	if w.failing {
+		w.failing = false
+		fmt.Fprintf(stderr, "FileLogWriter(%q): reopened\n", w.filename)
+	}
+	return nil
+}
+
+// Reports whether a and b fall on the same date, in the location of a.  The
+// day of the month alone would miss a month without writes.
+func sameDay(a, b time.Time) bool {
+	y1, m1, d1 := a.Date()
+	y2, m2, d2 := b.In(a.Location()).Date()
+	return y1 == y2 && m1 == m2 && d1 == d2
+}
+
+// Write the file magic if the file is still empty
+func (w *FileLogWriter) writeMagic() {
+	if len(w.magic) == 0 || w.file == nil {
+		return
+	}
+	if fi, err := w.file.Stat(); err != nil || fi.Size() > 0 {
+		return
+	}
+	w.file.Write(w.magic)
+}
+
+// Flags to open the log file with
+func (w *FileLogWriter) openFlags() int {
+	flag := os.O_WRONLY | os.O_APPEND | os.O_CREATE
+	if w.directSync {
+		flag |= syncOpenFlag
+	}
+	return flag
+}
+
 // Delete old log files which were expired.
 func (w *FileLogWriter) deleteOldLog() {
 	if w.maxdays <= 0 {
@@ -181,7 +554,7 @@ func (w *FileLogWriter) deleteOldLog() {
 	filepath.Walk(dir, func(path string, info os.FileInfo, err error) (returnErr error) {
 		defer func() {
 			if r := recover(); r != nil {
-				fmt.Fprintf(os.Stderr, "FileLogWriter: Unable to remove old log '%s', error: %+v\n", path, err)
+				fmt.Fprintf(stderr, "FileLogWriter: Unable to remove old log '%s', error: %+v\n", path, err)
 			}
 		}()
 
@@ -194,24 +567,228 @@ func (w *FileLogWriter) deleteOldLog() {
 	})
 }
 
+// List the rotated log files, oldest first
+func (w *FileLogWriter) rotatedLogs() []string {
+	dir := filepath.Dir(w.filename)
+	prefix := filepath.Base(w.filename) + "."
+	fd, err := os.Open(dir)
+	if err != nil {
+		return nil
+	}
+	names, _ := fd.Readdirnames(-1)
+	fd.Close()
+
+	logs := make([]string, 0, len(names))
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			logs = append(logs, filepath.Join(dir, name))
+		}
+	}
+	// Rotated names end in .YYYY-MM-DD.###, so they sort by age
+	sort.Strings(logs)
+	return logs
+}
+
+// Highest number of a rotated log file, the width of its name suffix
+const maxRotatedNumber = 999
+
+// The name of the first rotated log file of date numbered num or higher which
+// does not exist, checked again right before the rename so that a file
+// rotated meanwhile, e.g. by another process writing the same log, is not
+// overwritten.  Returns false if the numbers of the day are used up.
+func (w *FileLogWriter) freeRotatedName(date string, num int) (string, bool) {
+	for ; num <= maxRotatedNumber; num++ {
+		name := w.filename + fmt.Sprintf(".%s.%03d", date, num)
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Parse the number of a rotated log file named filename.YYYY-MM-DD.NNN.  If
+// date is not empty the file must have been rotated on that date.
+func rotatedNumber(filename, name, date string) (int, bool) {
+	suffix := strings.TrimPrefix(filepath.Base(name), filepath.Base(filename)+".")
+	if len(suffix) != len("2006-01-02.000") || suffix[10] != '.' {
+		return 0, false
+	}
+	if _, err := time.Parse("2006-01-02", suffix[:10]); err != nil {
+		return 0, false
+	}
+	if len(date) > 0 && suffix[:10] != date {
+		return 0, false
+	}
+	n, err := strconv.Atoi(suffix[11:])
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+// Delete the rotated log files older than maxage, then the oldest ones above
+// maxbackup.  All of them are counted, so files left behind e.g. by a manual
+// deletion leaving a gap in the numbers are deleted too.
+func (w *FileLogWriter) pruneBackups() {
+	var backups []string
+	cutoff := timeNow().Add(-w.maxage)
+	for _, name := range w.rotatedBackups() {
+		if fi, err := os.Stat(name); err == nil && w.maxage > 0 && fi.ModTime().Before(cutoff) {
+			if err := os.Remove(name); err != nil {
+				fmt.Fprintf(stderr, "FileLogWriter(%q): %s\n", w.filename, err)
+			}
+			continue
+		}
+		backups = append(backups, name)
+	}
+	for w.maxbackup > 0 && len(backups) > w.maxbackup {
+		if err := os.Remove(backups[0]); err != nil {
+			fmt.Fprintf(stderr, "FileLogWriter(%q): %s\n", w.filename, err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// List the rotated log files named filename.YYYY-MM-DD.NNN, oldest first.
+// Other files whose name starts with the name of the log file, e.g.
+// app.log.pid or app.log.bak, are left out.
+func (w *FileLogWriter) rotatedBackups() []string {
+	var backups []string
+	for _, name := range w.rotatedLogs() {
+		if _, ok := rotatedNumber(w.filename, name, ""); ok {
+			backups = append(backups, name)
+		}
+	}
+	return backups
+}
+
+// Delete the oldest rotated log files while the free space on the volume of
+// the log file is below the minimum.  The current log file and files not
+// named like rotated log files are never deleted.
+func (w *FileLogWriter) ensureFreeSpace() {
+	dir := filepath.Dir(w.filename)
+	free, err := diskFree(dir)
+	if err != nil || free >= w.minfree {
+		return
+	}
+	for _, name := range w.rotatedBackups() {
+		if err := os.Remove(name); err != nil {
+			fmt.Fprintf(stderr, "FileLogWriter(%q): %s\n", w.filename, err)
+			continue
+		}
+		if free, err = diskFree(dir); err != nil || free >= w.minfree {
+			return
+		}
+	}
+	fmt.Fprintf(stderr, "FileLogWriter(%q): %d bytes free, below the minimum of %d\n", w.filename, free, w.minfree)
+}
+
 // Set the logging format (chainable).  Must be called before the first log
 // message is written.
 func (w *FileLogWriter) SetFormat(format string) *FileLogWriter {
+	noteFormat(format)
 	w.format = format
 	return w
 }
 
+// SetTimezone renders the times of the records in loc (chainable), e.g.
+// America/New_York for a report read in the US.  nil, the default, renders
+// them in the location they were created in.
+func (w *FileLogWriter) SetTimezone(loc *time.Location) *FileLogWriter {
+	w.loc = loc
+	return w
+}
+
+// SetFormatter renders the records with f instead of the format string
+// (chainable).  Records f fails to render are reported and dropped.  nil
+// returns to the format string.  Must be called before the first log message
+// is written.
+func (w *FileLogWriter) SetFormatter(f Formatter) *FileLogWriter {
+	w.formatter = f
+	return w
+}
+
 // Set the logfile header and footer (chainable).  Must be called before the first log
 // message is written.  These are formatted similar to the FormatLogRecord (e.g.
 // you can use %D and %T in your header/footer for date and time).
 func (w *FileLogWriter) SetHeadFoot(head, foot string) *FileLogWriter {
 	w.header, w.trailer = head, foot
+	w.flushBuffer()
 	if w.maxlines_curlines == 0 {
 		fmt.Fprint(w.file, FormatLogRecord(w.header, &LogRecord{Created: time.Now()}))
 	}
 	return w
 }
 
+// SetFileMagic sets bytes written verbatim at the start of every new file,
+// before the header (chainable), e.g. a UTF-8 byte order mark for some log
+// viewers.  Files which already have content are left alone.  Must be called
+// before SetHeadFoot and the first log message.
+func (w *FileLogWriter) SetFileMagic(magic []byte) *FileLogWriter {
+	w.magic = append([]byte(nil), magic...)
+	w.writeMagic()
+	return w
+}
+
+// RotateTrigger returns a channel to rotate the log on events of the
+// application, e.g. a new deployment.  The file is rotated before the next
+// record is written, and only if something was written to it.  The channel
+// holds one request, also after Close: send with a select and a default
+// case, since a request already pending covers the new one.  Must be called
+// before the first log message is written.
+func (w *FileLogWriter) RotateTrigger() chan<- struct{} {
+	if w.trigger == nil {
+		w.trigger = make(chan struct{}, 1)
+	}
+	return w.trigger
+}
+
+// Take a rotation requested through RotateTrigger
+func (w *FileLogWriter) triggered() bool {
+	select {
+	case <-w.trigger:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetFlushInterval buffers the records in memory and writes them to the file
+// every d (chainable), trading the records of up to d lost in a crash for
+// fewer writes.  Records are also written out when the file is rotated or
+// closed.  0, the default, writes every record right away.  Must be called
+// before the first log message is written.
+func (w *FileLogWriter) SetFlushInterval(d time.Duration) *FileLogWriter {
+	if w.flushDone != nil {
+		close(w.flushDone)
+		w.flushDone = nil
+	}
+	w.flushBuffer()
+	w.flushEvery = d
+	if d <= 0 || w.fifo {
+		return w
+	}
+	w.flushDone = make(chan struct{})
+	go func(ticker *time.Ticker, done chan struct{}) {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.bufMu.Lock()
+				if w.buf != nil {
+					if err := w.buf.Flush(); err != nil {
+						fmt.Fprintf(stderr, "FileLogWriter(%q): %s\n", w.filename, err)
+					}
+				}
+				w.bufMu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}(time.NewTicker(d), w.flushDone)
+	return w
+}
+
 // Set rotate at linecount (chainable). Must be called before the first log
 // message is written.
 func (w *FileLogWriter) SetRotateLines(maxlines int) *FileLogWriter {
@@ -234,6 +811,36 @@ func (w *FileLogWriter) SetRotateDays(maxdays int) *FileLogWriter {
 	return w
 }
 
+// SetMaxAge deletes the rotated log files last modified more than d ago
+// (chainable), checked on every rotation.  The cap of SetRotateBackup applies
+// too, to the files left.  Zero, the default, keeps files of any age.  Must
+// be called before the first log message is written.
+func (w *FileLogWriter) SetMaxAge(d time.Duration) *FileLogWriter {
+	w.maxage = d
+	return w
+}
+
+// SetFallback sets where the records go while the log file cannot be
+// reopened, e.g. after its directory became read-only (chainable).  The
+// failure is reported on stderr once, and reopening is retried at most once
+// a second.  The default is os.Stderr; nil drops the records.  Must be called
+// before the first log message is written.
+func (w *FileLogWriter) SetFallback(fallback io.Writer) *FileLogWriter {
+	w.fallback = fallback
+	return w
+}
+
+// SetRotateOnOpen keeps the log file left by a previous run, if it is not
+// empty, as a backup (.YYYY-MM-DD.NNN) before the first record is written
+// (chainable), so that every run starts a file of its own even if rotation is
+// off.  The backups are capped by SetRotateBackup and SetMaxAge.  A writer
+// created with rotation on does this already when it opens the file.  Must be
+// called before the first log message is written.
+func (w *FileLogWriter) SetRotateOnOpen(rotate bool) *FileLogWriter {
+	w.rotateOnOpen = rotate
+	return w
+}
+
 // Set rotate daily (chainable). Must be called before the first log message is
 // written.
 func (w *FileLogWriter) SetRotateDaily(daily bool) *FileLogWriter {
@@ -252,13 +859,95 @@ func (w *FileLogWriter) SetRotate(rotate bool) *FileLogWriter {
 	return w
 }
 
-// Set max backup files. Must be called before the first log message
-// is written.
+// SetDirectSync opens the log file with O_SYNC (chainable), so that every
+// record is on stable storage before LogWrite returns.  This is meant for
+// audit logs that must survive a power loss, and costs a disk round trip per
+// record: expect throughput to drop by orders of magnitude.  Where O_SYNC is
+// not supported, the file is synced after every write instead.  Records are
+// not buffered by SetFlushInterval while direct sync is on.
+func (w *FileLogWriter) SetDirectSync(sync bool) *FileLogWriter {
+	if sync && syncOpenFlag == 0 && !w.directSync {
+		fmt.Fprintf(stderr, "FileLogWriter(%q): O_SYNC is not supported, syncing after every write\n", w.filename)
+	}
+	w.directSync = sync
+	if w.file == nil || w.fifo {
+		return w
+	}
+
+	// Reopen the current file with the new flags
+	w.flushBuffer()
+	fd, err := os.OpenFile(w.filename, w.openFlags(), 0660)
+	if err != nil {
+		fmt.Fprintf(stderr, "FileLogWriter(%q): %s\n", w.filename, err)
+		return w
+	}
+	w.cancelSync()
+	w.file.Close()
+	w.file = fd
+	return w
+}
+
+// SetMaxFlushInterval bounds the time a record may wait before it is on
+// stable storage (chainable): the file is synced at least every d, whether
+// records keep coming or not, so that at most d of logging is lost in a power
+// failure.  The records are written through to the file, so flushing means
+// syncing it.  0, the default, leaves syncing to the system.
+func (w *FileLogWriter) SetMaxFlushInterval(d time.Duration) *FileLogWriter {
+	w.maxflush = d
+	w.lastflush = timeNow()
+	return w
+}
+
+// SetMinFreeSpace deletes the oldest rotated log files whenever the free
+// space on the volume of the log file drops below bytes (chainable).  The
+// space is checked at most once a minute.  This is a safety valve against
+// runaway logging; it does nothing where the free space cannot be queried.
+func (w *FileLogWriter) SetMinFreeSpace(bytes int64) *FileLogWriter {
+	w.minfree = bytes
+	return w
+}
+
+// Set max backup files.  After each rotation the oldest rotated files above
+// the maximum are deleted.  Must be called before the first log message is
+// written.
 func (w *FileLogWriter) SetRotateBackup(maxbackup int) *FileLogWriter {
 	w.maxbackup = maxbackup
 	return w
 }
 
+// GetOption returns the current value of an option, named like the
+// properties of a file filter in a configuration: "filename" and "format"
+// (string), "maxlines", "maxsize", "maxdays" and "maxBackup" (int), "maxage"
+// (time.Duration), "daily" and "rotate" (bool) and "timezone"
+// (*time.Location, nil for the location of the records).
+func (w *FileLogWriter) GetOption(name string) (interface{}, error) {
+	switch name {
+	case "filename":
+		return w.filename, nil
+	case "format":
+		return w.format, nil
+	case "maxlines":
+		return w.maxlines, nil
+	case "maxsize":
+		return w.maxsize, nil
+	case "maxdays":
+		return w.maxdays, nil
+	case "maxage":
+		return w.maxage, nil
+	case "daily":
+		return w.daily, nil
+	case "rotate":
+		return w.rotate, nil
+	case "maxBackup":
+		return w.maxbackup, nil
+	case "rotateonopen":
+		return w.rotateOnOpen, nil
+	case "timezone":
+		return w.loc, nil
+	}
+	return nil, ErrBadOption
+}
+
 // NewXMLLogWriter is a utility method for creating a FileLogWriter set up to
 // output XML record log messages instead of line-based ones.
 func NewXMLLogWriter(fname string, rotate bool) *FileLogWriter {
@@ -0,0 +1,20 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build !js && !plan9 && !windows
+// +build !js,!plan9,!windows
+
+package log4go
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Open flag for named pipes, which would otherwise block until a reader
+// connects
+const fifoOpenFlag = syscall.O_NONBLOCK
+
+// Reports whether opening a named pipe failed only for lack of a reader
+func isFIFONoReader(err error) bool {
+	return errors.Is(err, syscall.ENXIO)
+}
@@ -0,0 +1,62 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package log4go
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Open the reading end of a named pipe without waiting for a writer
+func openFIFOReader(t *testing.T, name string) (*os.File, *bufio.Reader) {
+	r, err := os.OpenFile(name, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatalf("open fifo for reading: %s", err)
+	}
+	r.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return r, bufio.NewReader(r)
+}
+
+func TestFileLogWriterFIFO(t *testing.T) {
+	fifo := filepath.Join(t.TempDir(), "log.fifo")
+	if err := syscall.Mkfifo(fifo, 0600); err != nil {
+		t.Skipf("mkfifo: %s", err)
+	}
+
+	// Created before any reader connects
+	w := NewFileLogWriter(fifo, true).SetFormat("%M")
+	if w == nil {
+		t.Fatalf("NewFileLogWriter on a fifo without reader returned nil")
+	}
+	defer w.Close()
+	if err := w.LogWriteErr(newLogRecord(INFO, "source", "nobody listens")); err != errFileNotOpen {
+		t.Errorf("write without reader = %v, want %v", err, errFileNotOpen)
+	}
+
+	r, br := openFIFOReader(t, fifo)
+	w.LogWrite(newLogRecord(INFO, "source", "first"))
+	if line, err := br.ReadString('\n'); err != nil || line != "first\n" {
+		t.Errorf("first reader got %q, %v", line, err)
+	}
+
+	// The reader goes away and a new one connects
+	r.Close()
+	w.LogWriteErr(newLogRecord(INFO, "source", "lost"))
+	r, br = openFIFOReader(t, fifo)
+	defer r.Close()
+	w.LogWrite(newLogRecord(INFO, "source", "second"))
+	if line, err := br.ReadString('\n'); err != nil || line != "second\n" {
+		t.Errorf("second reader got %q, %v", line, err)
+	}
+
+	if fi, err := os.Lstat(fifo); err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("fifo replaced: %v, %v", fi.Mode(), err)
+	}
+}
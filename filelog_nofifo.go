@@ -0,0 +1,13 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build js || plan9 || windows
+// +build js plan9 windows
+
+package log4go
+
+// Named pipes are not files here; they are opened like any other file
+const fifoOpenFlag = 0
+
+func isFIFONoReader(err error) bool {
+	return false
+}
@@ -0,0 +1,13 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package log4go
+
+import "errors"
+
+// Free space is not queried on this platform
+func diskFreeSpace(path string) (int64, error) {
+	return 0, errors.New("free disk space is not supported")
+}
@@ -0,0 +1,9 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build js || plan9
+// +build js plan9
+
+package log4go
+
+// O_SYNC is not available; FileLogWriter syncs after every write instead
+const syncOpenFlag = 0
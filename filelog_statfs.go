@@ -0,0 +1,17 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package log4go
+
+import "syscall"
+
+// Bytes available to unprivileged users on the volume holding path
+func diskFreeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -0,0 +1,11 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build !js && !plan9
+// +build !js,!plan9
+
+package log4go
+
+import "os"
+
+// Open flag for synchronous writes
+const syncOpenFlag = os.O_SYNC
@@ -0,0 +1,43 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build linux
+// +build linux
+
+package log4go
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func openFlags(t *testing.T, f *os.File) int {
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_GETFL, 0)
+	if errno != 0 {
+		t.Fatalf("fcntl(F_GETFL): %s", errno)
+	}
+	return int(flags)
+}
+
+func TestFileLogWriterDirectSync(t *testing.T) {
+	w := NewFileLogWriter(testLogFile, false)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	defer os.Remove(testLogFile)
+	defer w.Close()
+
+	if openFlags(t, w.file)&syscall.O_SYNC == syscall.O_SYNC {
+		t.Errorf("log file opened with O_SYNC by default")
+	}
+
+	w.SetDirectSync(true)
+	if openFlags(t, w.file)&syscall.O_SYNC != syscall.O_SYNC {
+		t.Errorf("log file not opened with O_SYNC after SetDirectSync(true)")
+	}
+
+	w.LogWrite(newLogRecord(CRITICAL, "source", "message"))
+	if fi, err := os.Stat(testLogFile); err != nil || fi.Size() == 0 {
+		t.Errorf("record not written with direct sync: %v", err)
+	}
+}
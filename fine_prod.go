@@ -0,0 +1,25 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build l4g_prod
+// +build l4g_prod
+
+// Built with -tags l4g_prod, Finest and Fine do nothing and are inlined away,
+// so that the lowest levels cost nothing in production builds.  Records at
+// these levels logged with Log, Logf or LogWith are still written.
+
+package log4go
+
+// Whether Finest and Fine are no-ops
+const fineCompiledOut = true
+
+// Finest does nothing in l4g_prod builds.
+func (log Logger) Finest(arg0 interface{}, args ...interface{}) {}
+
+// Fine does nothing in l4g_prod builds.
+func (log Logger) Fine(arg0 interface{}, args ...interface{}) {}
+
+// Finest does nothing in l4g_prod builds.
+func Finest(arg0 interface{}, args ...interface{}) {}
+
+// Fine does nothing in l4g_prod builds.
+func Fine(arg0 interface{}, args ...interface{}) {}
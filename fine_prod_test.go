@@ -0,0 +1,28 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build l4g_prod
+// +build l4g_prod
+
+package log4go
+
+import "testing"
+
+func TestFinestCompiledOut(t *testing.T) {
+	rw := new(recordingWriter)
+	l := Logger{"rec": NewSyncFilter(FINEST, rw)}
+	called := false
+	l.Finest(func() string {
+		called = true
+		return "finest"
+	})
+	l.Fine("fine %d", 1)
+	l.Debug("debug")
+	l.Close()
+
+	if called {
+		t.Errorf("Finest called its closure")
+	}
+	if len(rw.recs) != 1 || rw.recs[0].Level != DEBUG {
+		t.Errorf("wrote %d records, want only the DEBUG one", len(rw.recs))
+	}
+}
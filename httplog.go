@@ -0,0 +1,325 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Attempts to post a batch before it is dropped
+const httpPostAttempts = 4
+
+// Batches waiting to be posted, beyond which batches are dropped
+const httpQueueLen = 16
+
+// Wait before the first retry of a failed post, doubled for every further
+// retry; replaced in tests
+var httpRetryBackoff = 250 * time.Millisecond
+
+// This log writer posts the records to an HTTP collector, in batches of
+// newline delimited JSON in the format of the SocketLogWriter.  The batches
+// are posted by a goroutine of the writer, so that a slow or unreachable
+// collector does not hold up the logging: while it falls behind, up to 16
+// batches are queued and any more are dropped.
+type HTTPLogWriter struct {
+	url     string
+	headers map[string]string
+	token   func() (string, error)
+	client  *http.Client
+
+	batchSize int
+	interval  time.Duration
+
+	batch    bytes.Buffer
+	count    int  // records in batch
+	flushing bool // a flush of batch is scheduled
+	closed   bool
+
+	// Guards the options and the batch, which is also queued by a timer
+	mu sync.Mutex
+
+	// Batches to post, read by the sender goroutine, which closes done when
+	// the queue is closed and drained
+	queue chan httpBatch
+	done  chan struct{}
+
+	dropped uint64 // records dropped, for Dropped
+}
+
+// A batch queued for posting
+type httpBatch struct {
+	body    []byte
+	count   int
+	flushed chan struct{} // closed once posted, if set
+}
+
+// NewHTTPLogWriter posts the records to url.  A batch is posted once it holds
+// 100 records or its first record is a second old.
+func NewHTTPLogWriter(url string) *HTTPLogWriter {
+	w := &HTTPLogWriter{
+		url:       url,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		batchSize: 100,
+		interval:  time.Second,
+		queue:     make(chan httpBatch, httpQueueLen),
+		done:      make(chan struct{}),
+	}
+	go w.send()
+	return w
+}
+
+// Set the number of records posted together (chainable).  Must be called
+// before the first log message is written.
+func (w *HTTPLogWriter) SetBatchSize(size int) *HTTPLogWriter {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if size < 1 {
+		size = 1
+	}
+	w.batchSize = size
+	return w
+}
+
+// Set the longest time a record waits for its batch to fill up (chainable).
+// Must be called before the first log message is written.
+func (w *HTTPLogWriter) SetFlushInterval(d time.Duration) *HTTPLogWriter {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.interval = d
+	return w
+}
+
+// SetHeaders sets headers sent with every post, e.g. an Authorization token
+// (chainable).  Must be called before the first log message is written.
+func (w *HTTPLogWriter) SetHeaders(headers map[string]string) *HTTPLogWriter {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.headers = make(map[string]string, len(headers))
+	for k, v := range headers {
+		w.headers[k] = v
+	}
+	return w
+}
+
+// SetTLSConfig sets the TLS configuration of the connections to the
+// collector, e.g. the CAs to trust and a client certificate for mutual
+// authentication (chainable).  Must be called before the first log message
+// is written.
+func (w *HTTPLogWriter) SetTLSConfig(cfg *tls.Config) *HTTPLogWriter {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	w.client = &http.Client{Timeout: w.client.Timeout, Transport: transport}
+	return w
+}
+
+// SetToken sends token as a bearer token in the Authorization header of
+// every post (chainable).  Must be called before the first log message is
+// written.
+func (w *HTTPLogWriter) SetToken(token string) *HTTPLogWriter {
+	return w.SetTokenFunc(func() (string, error) { return token, nil })
+}
+
+// SetTokenFunc sends the token returned by fn as a bearer token in the
+// Authorization header (chainable).  fn is called for every post, so a
+// short-lived token can be refreshed without making a new writer; a post
+// fails if fn does.  Must be called before the first log message is written.
+func (w *HTTPLogWriter) SetTokenFunc(fn func() (string, error)) *HTTPLogWriter {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.token = fn
+	return w
+}
+
+// Make the TLS configuration of PEM files: cacert holds the CAs to trust
+// instead of those of the system, cert and key the client certificate.  Any
+// of them may be empty.
+func loadTLSConfig(cacert, cert, key string) (*tls.Config, error) {
+	cfg := new(tls.Config)
+	if len(cacert) > 0 {
+		pem, err := ioutil.ReadFile(cacert)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", cacert)
+		}
+	}
+	if len(cert) > 0 || len(key) > 0 {
+		if len(cert) == 0 || len(key) == 0 {
+			return nil, errors.New("a client certificate needs both cert and key")
+		}
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	return cfg, nil
+}
+
+func (w *HTTPLogWriter) LogWrite(rec *LogRecord) {
+	js, err := json.Marshal(rec)
+	if err != nil {
+		fmt.Fprintf(stderr, "HTTPLogWriter(%s): %s\n", w.url, err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	w.batch.Write(js)
+	w.batch.WriteByte('\n')
+	w.count++
+	if w.count >= w.batchSize || w.interval <= 0 {
+		w.intFlush()
+	} else if !w.flushing {
+		w.flushing = true
+		time.AfterFunc(w.interval, w.flush)
+	}
+}
+
+// Queue the batch when its first record waited long enough
+func (w *HTTPLogWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flushing = false
+	if !w.closed {
+		w.intFlush()
+	}
+}
+
+// Queue the batch for the sender, or drop it if the queue is full
+func (w *HTTPLogWriter) intFlush() {
+	if w.count == 0 {
+		return
+	}
+	b := w.takeBatch()
+	select {
+	case w.queue <- b:
+	default:
+		atomic.AddUint64(&w.dropped, uint64(b.count))
+		fmt.Fprintf(stderr, "HTTPLogWriter(%s): collector too slow, %d records dropped\n", w.url, b.count)
+	}
+}
+
+// Take the records batched so far
+func (w *HTTPLogWriter) takeBatch() httpBatch {
+	b := httpBatch{body: append([]byte(nil), w.batch.Bytes()...), count: w.count}
+	w.batch.Reset()
+	w.count = 0
+	return b
+}
+
+// Post the queued batches until the queue is closed
+func (w *HTTPLogWriter) send() {
+	defer close(w.done)
+	for b := range w.queue {
+		if b.count > 0 {
+			w.postBatch(b)
+		}
+		if b.flushed != nil {
+			close(b.flushed)
+		}
+	}
+}
+
+// Post a batch, retrying with backoff.  The batch is dropped if every
+// attempt fails.
+func (w *HTTPLogWriter) postBatch(b httpBatch) {
+	var err error
+	for attempt, backoff := 0, httpRetryBackoff; attempt < httpPostAttempts; attempt, backoff = attempt+1, backoff*2 {
+		if attempt > 0 {
+			time.Sleep(backoff)
+		}
+		var retry bool
+		if retry, err = w.post(b.body); err == nil || !retry {
+			break
+		}
+	}
+	if err != nil {
+		atomic.AddUint64(&w.dropped, uint64(b.count))
+		fmt.Fprintf(stderr, "HTTPLogWriter(%s): %s, %d records dropped\n", w.url, err, b.count)
+	}
+}
+
+// Post a batch once.  Returns whether a failure may pass on retrying.
+func (w *HTTPLogWriter) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	if w.token != nil {
+		token, err := w.token()
+		if err != nil {
+			return true, fmt.Errorf("token: %s", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("POST: %s", resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Flush posts the records batched so far and waits until the batches queued
+// before are posted.
+func (w *HTTPLogWriter) Flush() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	b := w.takeBatch()
+	b.flushed = make(chan struct{})
+	// The sender does not take the lock, so it drains the queue meanwhile
+	w.queue <- b
+	w.mu.Unlock()
+	<-b.flushed
+}
+
+// Dropped returns the number of records dropped, because the queue of
+// batches was full or every attempt to post their batch failed.
+func (w *HTTPLogWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Close posts the records still batched and queued, and stops the sender.
+func (w *HTTPLogWriter) Close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	w.queue <- w.takeBatch()
+	close(w.queue)
+	w.mu.Unlock()
+	<-w.done
+}
@@ -0,0 +1,63 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+// Syslog severities (RFC 5424), the values of log/syslog.LOG_EMERG ..
+// LOG_DEBUG without a facility
+const (
+	syslogEmerg = iota
+	syslogAlert
+	syslogCrit
+	syslogErr
+	syslogWarning
+	syslogNotice
+	syslogInfo
+	syslogDebug
+)
+
+// ToSyslogSeverity returns the syslog severity of the level:
+//
+//	CRITICAL                   2 (LOG_CRIT)
+//	ERROR                      3 (LOG_ERR)
+//	WARNING                    4 (LOG_WARNING)
+//	INFO                       6 (LOG_INFO)
+//	TRACE, DEBUG, FINE, FINEST 7 (LOG_DEBUG)
+//
+// Syslog has no severity below debug, so the four lowest levels share it.
+// Levels above CRITICAL are CRITICAL, below FINEST LOG_DEBUG.
+func (l Level) ToSyslogSeverity() int {
+	switch {
+	case l >= CRITICAL:
+		return syslogCrit
+	case l == ERROR:
+		return syslogErr
+	case l == WARNING:
+		return syslogWarning
+	case l == INFO:
+		return syslogInfo
+	}
+	return syslogDebug
+}
+
+// LevelFromSyslogSeverity returns the level of a syslog severity:
+//
+//	0 (LOG_EMERG), 1 (LOG_ALERT), 2 (LOG_CRIT) CRITICAL
+//	3 (LOG_ERR)                                ERROR
+//	4 (LOG_WARNING)                            WARNING
+//	5 (LOG_NOTICE), 6 (LOG_INFO)               INFO
+//	7 (LOG_DEBUG)                              DEBUG
+//
+// Severities below 0 are CRITICAL, above 7 DEBUG.
+func LevelFromSyslogSeverity(severity int) Level {
+	switch {
+	case severity <= syslogCrit:
+		return CRITICAL
+	case severity == syslogErr:
+		return ERROR
+	case severity == syslogWarning:
+		return WARNING
+	case severity <= syslogInfo:
+		return INFO
+	}
+	return DEBUG
+}
@@ -0,0 +1,56 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build go1.21
+// +build go1.21
+
+package log4go
+
+import "log/slog"
+
+// Slog levels of FINEST .. CRITICAL.  The slog scale leaves room between its
+// named levels, so every level gets a value of its own.
+var slogLevels = [...]slog.Level{
+	slog.LevelDebug - 8, // FINEST
+	slog.LevelDebug - 4, // FINE
+	slog.LevelDebug,     // DEBUG
+	slog.LevelDebug + 2, // TRACE, which ranks above DEBUG in log4go
+	slog.LevelInfo,      // INFO
+	slog.LevelWarn,      // WARNING
+	slog.LevelError,     // ERROR
+	slog.LevelError + 4, // CRITICAL
+}
+
+// ToSlogLevel returns the slog level of the level:
+//
+//	FINEST   -12 (DEBUG-8)
+//	FINE      -8 (DEBUG-4)
+//	DEBUG     -4 (DEBUG)
+//	TRACE     -2 (DEBUG+2)
+//	INFO       0 (INFO)
+//	WARNING    4 (WARN)
+//	ERROR      8 (ERROR)
+//	CRITICAL  12 (ERROR+4)
+//
+// LevelFromSlog maps these back to the same levels.  Levels above CRITICAL
+// are CRITICAL, below FINEST FINEST.
+func (l Level) ToSlogLevel() slog.Level {
+	switch {
+	case l < FINEST:
+		l = FINEST
+	case l > CRITICAL:
+		l = CRITICAL
+	}
+	return slogLevels[l]
+}
+
+// LevelFromSlog returns the highest level whose slog level (see ToSlogLevel)
+// is at most lvl, e.g. WARNING for slog.LevelWarn+1, or FINEST for slog
+// levels below -12.
+func LevelFromSlog(lvl slog.Level) Level {
+	for l := CRITICAL; l > FINEST; l-- {
+		if lvl >= slogLevels[l] {
+			return l
+		}
+	}
+	return FINEST
+}
@@ -0,0 +1,47 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build go1.21
+// +build go1.21
+
+package log4go
+
+import (
+	"log/slog"
+	"testing"
+)
+
+func TestSlogLevel(t *testing.T) {
+	for lvl, want := range map[Level]slog.Level{
+		DEBUG:    slog.LevelDebug,
+		INFO:     slog.LevelInfo,
+		WARNING:  slog.LevelWarn,
+		ERROR:    slog.LevelError,
+		CRITICAL: slog.LevelError + 4,
+	} {
+		if got := lvl.ToSlogLevel(); got != want {
+			t.Errorf("%v.ToSlogLevel() = %v, want %v", lvl, got, want)
+		}
+	}
+
+	// Every level round trips and ranks like its slog level
+	for lvl := FINEST; lvl <= CRITICAL; lvl++ {
+		if got := LevelFromSlog(lvl.ToSlogLevel()); got != lvl {
+			t.Errorf("%v round trips to %v", lvl, got)
+		}
+		if lvl > FINEST && lvl.ToSlogLevel() <= (lvl-1).ToSlogLevel() {
+			t.Errorf("%v does not rank above %v in slog", lvl, lvl-1)
+		}
+	}
+
+	// Levels between the mapped ones round down
+	for in, want := range map[slog.Level]Level{
+		slog.LevelWarn + 1:  WARNING,
+		slog.LevelInfo - 1:  TRACE,
+		slog.LevelError + 9: CRITICAL,
+		-100:                FINEST,
+	} {
+		if got := LevelFromSlog(in); got != want {
+			t.Errorf("LevelFromSlog(%v) = %v, want %v", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Logs every line written to it as a record, see Logger.Writer
+type lineWriter struct {
+	log    Logger
+	lvl    Level
+	source string
+
+	mu   sync.Mutex
+	line []byte // start of a line still missing its newline
+}
+
+// Writer returns a writer which logs every line written to it as a record of
+// level lvl and the given source, e.g. for the ErrorLog of an http.Server
+// through log.New(l.Writer(ERROR, "http"), "", 0).  A line without its
+// newline is kept until the rest of it is written or the writer is closed.
+func (log Logger) Writer(lvl Level, source string) io.WriteCloser {
+	return &lineWriter{log: log, lvl: lvl, source: source}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rest := p
+	for {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			break
+		}
+		w.line = append(w.line, rest[:i]...)
+		w.emit()
+		rest = rest[i+1:]
+	}
+	w.line = append(w.line, rest...)
+	return len(p), nil
+}
+
+// Log the line kept so far
+func (w *lineWriter) emit() {
+	w.log.Log(w.lvl, w.source, strings.TrimSuffix(string(w.line), "\r"))
+	w.line = w.line[:0]
+}
+
+// Close logs the last line even though its newline is missing.
+func (w *lineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.line) > 0 {
+		w.emit()
+	}
+	return nil
+}
@@ -46,12 +46,20 @@
 package log4go
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -79,29 +87,190 @@ const (
 	CRITICAL
 )
 
-// Logging level strings
+// The names of a level: the abbreviation rendered by %L and String, and the
+// full name rendered by %l and used in configuration files
+type levelNames struct {
+	short, long string
+}
+
+// The built-in levels, which cannot be registered again
+var builtinLevels = map[Level]levelNames{
+	FINEST:   {"FNST", "FINEST"},
+	FINE:     {"FINE", "FINE"},
+	DEBUG:    {"DEBG", "DEBUG"},
+	TRACE:    {"TRAC", "TRACE"},
+	INFO:     {"INFO", "INFO"},
+	WARNING:  {"WARN", "WARNING"},
+	ERROR:    {"EROR", "ERROR"},
+	CRITICAL: {"CRIT", "CRITICAL"},
+}
+
+// The built-in and registered levels, replaced as a whole on registration
 var (
-	levelStrings = [...]string{"FNST", "FINE", "DEBG", "TRAC", "INFO", "WARN", "EROR", "CRIT"}
+	levelsMu sync.Mutex
+	levels   atomic.Value // map[Level]levelNames
 )
 
+func init() {
+	levels.Store(builtinLevels)
+}
+
+// RegisterLevel defines a level of its own, with the full name used in
+// configuration files and rendered by %l, and the abbreviation rendered by
+// %L.  Levels compare by their value, so e.g. a FATAL of CRITICAL+1 passes
+// every filter a CRITICAL passes.  The built-in levels take the consecutive
+// values FINEST (0) to CRITICAL (7), so a level of its own is either below
+// FINEST or above CRITICAL: none fits between two built-in levels, e.g. a
+// NOTICE between INFO and WARNING.  The values are kept for compatibility, as
+// the JSON of the socket and HTTP writers holds the level as a number.
+// Registering a level again renames it.
+// RegisterLevel panics for the built-in levels and for a name already taken
+// by another level.  Must be called before the levels are used, usually from
+// an init function.
+func RegisterLevel(lvl Level, longName, shortName string) {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+
+	if _, ok := builtinLevels[lvl]; ok {
+		panic(fmt.Sprintf("log4go: RegisterLevel of built-in level %s", builtinLevels[lvl].long))
+	}
+	if len(longName) == 0 || len(shortName) == 0 {
+		panic("log4go: RegisterLevel without a name")
+	}
+	old := levels.Load().(map[Level]levelNames)
+	for other, names := range old {
+		if other != lvl && strings.EqualFold(names.long, longName) {
+			panic("log4go: RegisterLevel of duplicate name " + longName)
+		}
+	}
+
+	l := make(map[Level]levelNames, len(old)+1)
+	for other, names := range old {
+		l[other] = names
+	}
+	l[lvl] = levelNames{short: shortName, long: longName}
+	levels.Store(l)
+}
+
+// The level of a full name, as in configuration files
+func levelByName(name string) (Level, bool) {
+	for lvl, names := range levels.Load().(map[Level]levelNames) {
+		if names.long == name {
+			return lvl, true
+		}
+	}
+	return 0, false
+}
+
+// The full names of all levels, lowest first
+func knownLevelNames() []string {
+	l := levels.Load().(map[Level]levelNames)
+	lvls := make([]int, 0, len(l))
+	for lvl := range l {
+		lvls = append(lvls, int(lvl))
+	}
+	sort.Ints(lvls)
+	names := make([]string, len(lvls))
+	for i, lvl := range lvls {
+		names[i] = l[Level(lvl)].long
+	}
+	return names
+}
+
+// Labels rendered by %L, set by SetLevelLabels
+var levelLabels atomic.Value // map[Level]string
+
+// SetLevelLabels changes the labels rendered by the %L format code, e.g.
+// {ERROR: "ERR", WARNING: "WRN"}.  Levels missing from labels keep the
+// default abbreviation; nil restores all defaults.  The full level names are
+// available as %l.
+func SetLevelLabels(labels map[Level]string) {
+	l := make(map[Level]string, len(labels))
+	for lvl, label := range labels {
+		l[lvl] = label
+	}
+	levelLabels.Store(l)
+}
+
+// The label of a level for %L
+func levelLabel(lvl Level) string {
+	if l, ok := levelLabels.Load().(map[Level]string); ok {
+		if label, ok := l[lvl]; ok {
+			return label
+		}
+	}
+	return lvl.String()
+}
+
+// The full name of a level for %l
+func levelName(lvl Level) string {
+	if names, ok := levels.Load().(map[Level]levelNames)[lvl]; ok {
+		return names.long
+	}
+	return "UNKNOWN"
+}
+
 func (l Level) String() string {
-	if l < 0 || int(l) > len(levelStrings) {
-		return "UNKNOWN"
+	if names, ok := levels.Load().(map[Level]levelNames)[l]; ok {
+		return names.short
 	}
-	return levelStrings[int(l)]
+	return "UNKNOWN"
 }
 
 /****** Variables ******/
 var (
 	// Default skip passed to runtime.Caller to get file name/line
-	// May require tweaking if you want to wrap the logger
+	// May require tweaking if you want to wrap the logger, see
+	// Logger.SetCallerSkip to do so for one logger
 	DefaultCallerSkip = 2
 
 	// LogBufferLength specifies how many log messages a particular log4go
 	// logger can buffer at a time before writing them.
 	DefaultBufferLength = 32
+
+	// How LogBytes renders data in the message
+	BinaryEncoding = BinaryHex
+
+	// How long Exit, Fatal, Crash and Panic wait for the writers to close
+	// before leaving the program anyway
+	ExitCloseTimeout = 5 * time.Second
+
+	// Clock used to time stamp log records; replaced in tests
+	timeNow = time.Now
+
+	// Where LogWriters report their own errors, see DiagnosticsPerSecond
+	stderr io.Writer = newThrottledWriter(os.Stderr)
+
+	// Where records logged after Close go, unthrottled unlike stderr
+	closedFallback io.Writer = os.Stderr
+)
+
+// Errors returned by the SetOption and GetOption methods of LogWriters
+var (
+	ErrBadOption = errors.New("invalid or unsupported option")
+	ErrBadValue  = errors.New("invalid option value")
 )
 
+// Returned by Logger.SetLevel for a tag without filter
+var ErrUnknownTag = errors.New("no filter with this tag")
+
+// Ways to render binary data as a message
+const (
+	BinaryHex     = iota // 0a1b2c
+	BinaryBase64         // ChEs
+	BinaryEscaped        // "\n\x1b,"
+)
+
+func encodeBinary(data []byte) string {
+	switch BinaryEncoding {
+	case BinaryBase64:
+		return base64.StdEncoding.EncodeToString(data)
+	case BinaryEscaped:
+		return strconv.Quote(string(data))
+	}
+	return hex.EncodeToString(data)
+}
+
 /****** LogRecord ******/
 
 // A LogRecord contains all of the pertinent information for each message
@@ -110,13 +279,58 @@ type LogRecord struct {
 	Created time.Time // The time at which the log message was created (nanoseconds)
 	Source  string    // The message source
 	Message string    // The log message
+	Fields  Fields    `json:",omitempty"` // Extra key/value pairs, in order
+	Binary  []byte    `json:",omitempty"` // Raw data logged by LogBytes
+
+	// Caller details, filled in when the source is captured automatically
+	file string
+	line int
+	fn   string
+
+	// Id of the logging goroutine, if a format renders it
+	goid uint64
+
+	// The message without the fields taken out by a message parser, for %O
+	parsedMsg string
+	parsed    bool
+
+	// The logger context merged into Fields, and Fields without it, for %c
+	// and %K
+	context   Fields
+	ownFields Fields
+
+	// Set on the marker queued by Logger.Flush, closed once the records
+	// queued before it are written
+	flushed chan struct{}
+}
+
+// Capture the caller of the logging function as the record source.  skip is
+// counted from the function calling setCaller, as for runtime.Caller.
+func (rec *LogRecord) setCaller(skip int) {
+	pc, file, lineno, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return
+	}
+	fn := runtime.FuncForPC(pc).Name()
+	rec.Source = fmt.Sprintf("%s:%d", filepath.Base(fn), lineno)
+	rec.file, rec.line, rec.fn = file, lineno, fn
+}
+
+// The fields of the record without the logger context
+func (rec *LogRecord) recordFields() Fields {
+	if rec.context == nil {
+		return rec.Fields
+	}
+	return rec.ownFields
 }
 
 /****** LogWriter ******/
 
 // This is an interface for anything that should be able to write logs
 type LogWriter interface {
-	// This will be called to log a LogRecord message.
+	// This will be called to log a LogRecord message.  The record is shared
+	// by all filters, which may write it at the same time (see
+	// Logger.SetConcurrentDispatch), so it must be treated as read-only.
 	LogWrite(rec *LogRecord)
 
 	// This should clean up anything lingering about the LogWriter, as it is called before
@@ -124,25 +338,69 @@ type LogWriter interface {
 	Close()
 }
 
+// An ErrorLogWriter is a LogWriter that can tell whether a record was written
+type ErrorLogWriter interface {
+	LogWriter
+
+	// Write the record like LogWrite, returning any error instead of
+	// reporting it.
+	LogWriteErr(rec *LogRecord) error
+}
+
+// A Flusher is a LogWriter that buffers records and can write them out on
+// demand, see Logger.Flush
+type Flusher interface {
+	// Write out the records buffered so far.
+	Flush()
+}
+
 /****** Logger ******/
 
 // A Filter represents the log level below which no log records are written to
 // the associated LogWriter.
 type Filter struct {
+	// The level the filter was created with; change it with Logger.SetLevel
 	Level Level
+	lvl   int32 // the current level, read atomically
 
 	rec 	chan *LogRecord	// write queue
+	done	chan struct{}	// closed when run returns, nil for synchronous filters
+	pending	int64	// records queued or being written, for WaitIdle
 	closed 	bool	// true if Socket was closed at API level
+	seq 	uint64	// creation order, filters are closed newest first
+
+	// Guards closed and the closing of rec against concurrent sends
+	closeMu	sync.RWMutex
+
+	// Synchronous filters write in the caller's goroutine
+	direct 	bool
+	mu 	sync.Mutex
+
+	// The filter of the original logger for a filter of Logger.Clone, whose
+	// queue and writer are used
+	shared	*Filter
 
 	LogWriter
 }
 
+var filterSeq uint64
+
 func NewFilter(lvl Level, writer LogWriter) *Filter {
+	return NewBufferedFilter(lvl, writer, DefaultBufferLength)
+}
+
+// NewBufferedFilter creates a filter whose writer runs in its own goroutine
+// behind a queue of buflen records.  Logging blocks only when the queue is
+// full.
+func NewBufferedFilter(lvl Level, writer LogWriter, buflen int) *Filter {
 	f := &Filter {
 		Level:		lvl,
+		lvl:		int32(lvl),
 
-		rec: 		make(chan *LogRecord, DefaultBufferLength),
+		rec: 		make(chan *LogRecord, buflen),
+		done:		make(chan struct{}),
 		closed: 	false,
+		seq: 		atomic.AddUint64(&filterSeq, 1),
 		
 		LogWriter:	writer,
 	}
@@ -150,60 +408,332 @@ func NewFilter(lvl Level, writer LogWriter) *Filter {
 	go f.run()
 	return f
 }
+
+// NewSyncFilter creates a filter which writes every record before the log
+// call returns, for destinations that must not lose messages on a crash.
+func NewSyncFilter(lvl Level, writer LogWriter) *Filter {
+	return &Filter{
+		Level:     lvl,
+		lvl:       int32(lvl),
+		rec:       make(chan *LogRecord),
+		seq:       atomic.AddUint64(&filterSeq, 1),
+		direct:    true,
+		LogWriter: writer,
+	}
+}
 	
+// WriteToChan queues the record, or writes it for a synchronous filter.
+// Records sent while or after the filter is closed are dropped with a
+// notice on stderr.
 func (f *Filter) WriteToChan(rec *LogRecord) {
+	if f.shared != nil {
+		if f.isClosed() {
+			fmt.Fprintf(stderr, "LogWriter: channel has been closed. Message is [%s]\n", rec.Message)
+			return
+		}
+		f.shared.WriteToChan(rec)
+		return
+	}
+	if f.direct {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.closed {
+			fmt.Fprintf(stderr, "LogWriter: channel has been closed. Message is [%s]\n", rec.Message)
+			return
+		}
+		f.LogWrite(rec)
+		return
+	}
+	f.closeMu.RLock()
+	defer f.closeMu.RUnlock()
 	if f.closed {
-		fmt.Fprintf(os.Stderr, "LogWriter: channel has been closed. Message is [%s]\n", rec.Message)
+		fmt.Fprintf(stderr, "LogWriter: channel has been closed. Message is [%s]\n", rec.Message)
 		return
 	}
+	atomic.AddInt64(&f.pending, 1)
 	f.rec <- rec
 }
 
+// Reports whether the filter was closed
+func (f *Filter) isClosed() bool {
+	f.closeMu.RLock()
+	defer f.closeMu.RUnlock()
+	return f.closed
+}
+
+// The current level of the filter
+func (f *Filter) level() Level {
+	return Level(atomic.LoadInt32(&f.lvl))
+}
+
+// The filter owning the write queue
+func (f *Filter) queue() *Filter {
+	if f.shared != nil {
+		return f.shared
+	}
+	return f
+}
+
+// QueueLen returns the number of records waiting to be written.  Compared
+// with QueueCap, it shows whether the writer keeps up with the logging.
+func (f *Filter) QueueLen() int {
+	return len(f.queue().rec)
+}
+
+// QueueCap returns the number of records the write queue can hold before
+// logging blocks.
+func (f *Filter) QueueCap() int {
+	return cap(f.queue().rec)
+}
+
+// Take the records queued when called, leaving the filter open
+func (f *Filter) takeQueued() []*LogRecord {
+	if f.isClosed() {
+		return nil
+	}
+	if f.shared != nil {
+		return f.shared.takeQueued()
+	}
+	var recs []*LogRecord
+	for n := len(f.rec); n > 0; n-- {
+		select {
+		case rec := <-f.rec:
+			atomic.AddInt64(&f.pending, -1)
+			if rec.flushed != nil {
+				close(rec.flushed)
+				continue
+			}
+			recs = append(recs, rec)
+		default:
+			return recs
+		}
+	}
+	return recs
+}
+
 func (f *Filter) run() {
+	defer close(f.done)
 	for {
 		select {
 		case rec, ok := <-f.rec:
 			if !ok {
 				return
 			}
-			f.LogWrite(rec)
+			f.write(rec)
+			atomic.AddInt64(&f.pending, -1)
 		}
 	}
 }
 
 func (f *Filter) Close() {
-	if f.closed {
+	if f.isClosed() {
 		return
 	}
-	// sleep at most one second and let go routine running
-	// drain the log channel before closing
+	drainFilters([]*Filter{f})
+	f.shutdown()
+}
+
+// Sleep at most one second and let the run goroutines drain the log channels
+// of the filters before they are closed
+func drainFilters(filts []*Filter) {
 	for i := 10; i > 0; i-- {
 		time.Sleep(100 * time.Millisecond)
-		if len(f.rec) <= 0 {
+		pending := 0
+		for _, f := range filts {
+			pending += len(f.queue().rec)
+		}
+		if pending <= 0 {
 			break
 		}
 	}
+}
 
-	// block write channel
-	f.closed = true
+// Close the log channel and the writer of a drained filter
+func (f *Filter) shutdown() {
+	if f.stop() {
+		f.LogWriter.Close()
+	}
+}
 
-	defer f.LogWriter.Close()
+// Close the log channel and wait until the run goroutine has written the
+// records still queued and returned.  Returns whether the writer must be
+// closed now: false if the filter was closed before, or for a filter of
+// Logger.Clone, which is only marked closed as the original owns the writer.
+func (f *Filter) stop() bool {
+	if f.shared != nil {
+		f.closeMu.Lock()
+		f.closed = true
+		f.closeMu.Unlock()
+		return false
+	}
+	if f.direct {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+	}
 
+	// block write channel, once the sends in progress are done
+	f.closeMu.Lock()
+	if f.closed {
+		f.closeMu.Unlock()
+		return false
+	}
+	f.closed = true
 	close(f.rec)
+	f.closeMu.Unlock()
 
-	if len(f.rec) <= 0 {
-		return
+	if f.done != nil {
+		<-f.done
 	}
-	// drain the log channel and write driect
-	for rec := range f.rec {
+	return true
+}
+
+// Write a queued record, or flush the writer for the marker of Logger.Flush
+func (f *Filter) write(rec *LogRecord) {
+	if rec.flushed == nil {
 		f.LogWrite(rec)
+		return
+	}
+	if fl, ok := f.LogWriter.(Flusher); ok {
+		fl.Flush()
+	}
+	close(rec.flushed)
+}
+
+// Write the records queued so far and flush the writer if it is a Flusher
+func (f *Filter) flush() {
+	if f.shared != nil {
+		if !f.isClosed() {
+			f.shared.flush()
+		}
+		return
+	}
+	if f.direct {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if fl, ok := f.LogWriter.(Flusher); ok && !f.closed {
+			fl.Flush()
+		}
+		return
+	}
+	marker := &LogRecord{flushed: make(chan struct{})}
+	f.closeMu.RLock()
+	if f.closed {
+		f.closeMu.RUnlock()
+		return
 	}
+	atomic.AddInt64(&f.pending, 1)
+	f.rec <- marker
+	f.closeMu.RUnlock()
+	<-marker.flushed
 }
 
 // A Logger represents a collection of Filters through which log messages are
 // written.
 type Logger map[string]*Filter
 
+// Per-Logger state.  A Logger is a map and cannot carry fields of its own, so
+// the state is indexed by the address of the map.  The index does not keep
+// the map in memory where weak pointers are available, see stateOwner.
+type loggerState struct {
+	key   uintptr // the address of the map
+	owner stateOwner
+
+	// Records logged before any filter was added
+	mu       sync.Mutex
+	early    []*LogRecord
+	earlyMax int
+
+	// Set by Close until a filter is added again
+	closed  bool
+	noticed bool // the notice about logging after Close was printed
+
+	fields atomic.Value // fieldsProvider, set by SetFieldsProvider
+
+	context atomic.Value // Fields, set by SetContext
+
+	captures atomic.Value // []*MemoryLogWriter, replaced under mu
+
+	callerSkip atomic.Value // int, set by SetCallerSkip
+
+	parser atomic.Value // messageParser, set by SetMessageParser
+
+	rate atomic.Value // rateLimit, set by SetPerSourceRate
+
+	concurrent int32 // set by SetConcurrentDispatch
+
+	// Records dispatched per level, for Stats, once counting is set
+	counting    int32
+	counts      [CRITICAL + 1]uint64
+	otherCounts sync.Map     // Level -> *uint64, for registered levels
+	statsHook   atomic.Value // statsHook, set by SetStatsHook
+}
+
+// Holds the callback of SetStatsHook, which may be nil
+type statsHook struct {
+	fn func(Level)
+}
+
+// Holds the callback of SetFieldsProvider, which may be nil
+type fieldsProvider struct {
+	fn func() []Field
+}
+
+// Holds the callback of SetMessageParser, which may be nil
+type messageParser struct {
+	fn func(string) (string, []Field)
+}
+
+var (
+	loggerStates sync.Map     // map[uintptr]*loggerState
+	lastState    atomic.Value // *loggerState found last, which spares most lookups
+)
+
+// Get the state of the logger, creating it on first use.  A nil Logger gets a
+// state which is not kept.  Only the methods changing the settings of the
+// logger create the state; logging uses findState, so that a logger which is
+// only used to log has none.
+func (log Logger) state() *loggerState {
+	p := reflect.ValueOf(log).UnsafePointer()
+	if p == nil {
+		return new(loggerState)
+	}
+	for {
+		if st := log.findState(); st != nil {
+			return st
+		}
+		st := &loggerState{key: uintptr(p), owner: newStateOwner(p)}
+		old, loaded := loggerStates.LoadOrStore(st.key, st)
+		if !loaded {
+			dropWithOwner(p, st)
+			return st
+		}
+		if !old.(*loggerState).owner.is(p) {
+			// The state of a collected Logger whose address was reused
+			loggerStates.CompareAndDelete(st.key, old)
+		}
+	}
+}
+
+// Get the state of the logger if it has any, or nil
+func (log Logger) findState() *loggerState {
+	p := reflect.ValueOf(log).UnsafePointer()
+	if p == nil {
+		return nil
+	}
+	if st, _ := lastState.Load().(*loggerState); st != nil && st.key == uintptr(p) && st.owner.is(p) {
+		return st
+	}
+	v, ok := loggerStates.Load(uintptr(p))
+	if !ok {
+		return nil
+	}
+	if st := v.(*loggerState); st.owner.is(p) {
+		lastState.Store(st)
+		return st
+	}
+	return nil
+}
+
 // Create a new logger.
 //
 // DEPRECATED: Use make(Logger) instead.
@@ -235,12 +765,126 @@ func NewDefaultLogger(lvl Level) Logger {
 // reconfiguration of logging.  Calling this is not really imperative, unless
 // you want to guarantee that all log messages are written.  Close removes
 // all filters (and thus all LogWriters) from the logger.
+//
+// Messages logged after Close, e.g. by background goroutines during shutdown,
+// are written to stderr, after a one-time notice, until a filter is added
+// again.
+//
+// The order is deterministic: every filter drains its pending messages before
+// any writer is closed, then the writers are closed in reverse order of
+// creation, so a writer added after the writers it depends on is closed
+// before them.
 func (log Logger) Close() {
-	// Close all open loggers
+	log.closeFilters(func(filt *Filter) bool {
+		return true
+	})
+
+	st := log.state()
+	st.mu.Lock()
+	st.closed, st.noticed = true, false
+	st.mu.Unlock()
+}
+
+// DrainBuffered takes the records still queued in the filters, for example in
+// a crash handler which writes them somewhere safe before the process dies.
+// The records are returned oldest first, and a record queued in several
+// filters is returned once.  They are removed from the queues and will not be
+// written by the filters.  Records a writer is already writing are not
+// included, and whatever a writer buffers internally stays there.  The
+// filters stay open.
+func (log Logger) DrainBuffered() []*LogRecord {
+	var recs []*LogRecord
+	seen := make(map[*LogRecord]bool)
+	for _, filt := range log {
+		for _, rec := range filt.takeQueued() {
+			if !seen[rec] {
+				seen[rec] = true
+				recs = append(recs, rec)
+			}
+		}
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		return recs[i].Created.Before(recs[j].Created)
+	})
+	return recs
+}
+
+// WaitIdle waits until every record logged so far has been handed to the
+// writers, i.e. the queues of the filters are empty and no writer is still
+// writing, for instance before a test reads the log file.  Returns false if
+// the filters are still busy after timeout.  Whatever a writer buffers
+// internally is not flushed.
+func (log Logger) WaitIdle(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		busy := false
+		for _, filt := range log {
+			busy = busy || atomic.LoadInt64(&filt.queue().pending) > 0
+		}
+		if !busy {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Flush blocks until the records logged so far are handed to the writers,
+// and flushes the writers which buffer records (see Flusher), without
+// closing anything.  Unlike WaitIdle it waits for the records queued when
+// called only, so records logged meanwhile by other goroutines cannot keep
+// it waiting.
+func (log Logger) Flush() {
+	var wg sync.WaitGroup
+	for _, filt := range log {
+		wg.Add(1)
+		go func(filt *Filter) {
+			defer wg.Done()
+			filt.flush()
+		}(filt)
+	}
+	wg.Wait()
+}
+
+// Closes and removes only the filters writing to files, for example before
+// the filesystem holding the logs is unmounted.  Pending messages are written
+// out first.  Console, socket and other filters are left running.
+func (log Logger) CloseFileWriters() {
+	log.closeFilters(func(filt *Filter) bool {
+		_, ok := filt.LogWriter.(*FileLogWriter)
+		return ok
+	})
+}
+
+// Close and remove the matching filters in the order described for Close
+func (log Logger) closeFilters(match func(filt *Filter) bool) {
+	filts := make([]*Filter, 0, len(log))
 	for name, filt := range log {
-		filt.Close()
+		if !match(filt) {
+			continue
+		}
+		filts = append(filts, filt)
 		delete(log, name)
 	}
+	if len(filts) == 0 {
+		return
+	}
+	sort.Slice(filts, func(i, j int) bool {
+		return filts[i].seq > filts[j].seq
+	})
+
+	drainFilters(filts)
+	stopped := make([]*Filter, 0, len(filts))
+	for _, filt := range filts {
+		if filt.stop() {
+			stopped = append(stopped, filt)
+		}
+	}
+	for _, filt := range stopped {
+		filt.LogWriter.Close()
+	}
 }
 
 // Add a new LogWriter to the Logger which will only log messages at lvl or
@@ -248,44 +892,415 @@ func (log Logger) Close() {
 // Returns the logger for chaining.
 func (log Logger) AddFilter(name string, lvl Level, writer LogWriter) Logger {
 	log[name] = NewFilter(lvl, writer)
+	log.filtersAdded()
 	return log
 }
 
+// SetLevel changes the level of the filter with the given tag while records
+// are logged, e.g. to turn on DEBUG from a signal handler.  The writer is
+// kept open.  Returns ErrUnknownTag if there is no such filter.
+func (log Logger) SetLevel(tag string, lvl Level) error {
+	filt, ok := log[tag]
+	if !ok {
+		return ErrUnknownTag
+	}
+	atomic.StoreInt32(&filt.lvl, int32(lvl))
+	filt.Level = lvl
+	return nil
+}
+
+// Clone returns a logger with copies of the filters of log, for example to
+// give a component its own levels.  The copies start at the current levels,
+// and SetLevel on either logger leaves the other alone, but they share the
+// queues and the writers of the originals: every record is still written
+// once per writer, in the order logged.  Closing the clone only detaches its
+// filters; the writers are closed with the original logger, after which
+// records logged through the clone are dropped with a notice on stderr.
+// Settings made with the SetXxx methods of log are not copied.
+func (log Logger) Clone() Logger {
+	clone := make(Logger, len(log))
+	for tag, filt := range log {
+		orig := filt.queue()
+		clone[tag] = &Filter{
+			Level:     filt.Level,
+			lvl:       int32(filt.level()),
+			seq:       filt.seq,
+			direct:    orig.direct,
+			shared:    orig,
+			LogWriter: orig.LogWriter,
+		}
+	}
+	return clone
+}
+
+// BufferUntilConfigured keeps up to n records logged while the logger has no
+// filters, and replays them once the first filter is added or a configuration
+// is loaded.  This captures startup messages logged before the configuration
+// was read.  Records beyond n are dropped.  n <= 0 stops buffering and drops
+// anything held.
+func (log Logger) BufferUntilConfigured(n int) {
+	st := log.state()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if n <= 0 {
+		st.early, st.earlyMax = nil, 0
+		return
+	}
+	st.earlyMax = n
+}
+
+// Hold a record logged before any filter was added.  Returns false if the
+// logger is not buffering early records.
+func (log Logger) holdEarly(rec *LogRecord) bool {
+	st := log.findState()
+	if st == nil {
+		return false
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.earlyMax <= 0 {
+		return false
+	}
+	if len(st.early) < st.earlyMax {
+		st.early = append(st.early, rec)
+	}
+	return true
+}
+
+// Called once filters were added: reopen the logger after Close, stop
+// buffering and write the early records to the filters
+func (log Logger) filtersAdded() {
+	st := log.findState()
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	early := st.early
+	st.early, st.earlyMax = nil, 0
+	st.closed = false
+	st.mu.Unlock()
+
+	for _, rec := range early {
+		log.send(rec)
+	}
+}
+
+// SetFieldsProvider sets a callback returning fields which are added to every
+// record, for values that change over time such as a request count or the
+// leader state of a node.  It is called once per record, at log time, and only
+// if some filter accepts the level.  Fields given to the log call win over
+// provided fields with the same key.  nil removes the provider.
+func (log Logger) SetFieldsProvider(provider func() []Field) {
+	log.state().fields.Store(fieldsProvider{fn: provider})
+}
+
+// SetContext sets fields describing the logger, e.g. the component or the
+// tenant, which are added to every record beneath the fields of the record:
+// a field of the record wins over the context field with the same key.  The
+// context is rendered alone by %c, while %K renders the fields of the record
+// alone and %A, %O, JSON and the other structured output render both merged.
+// No fields remove the context.
+func (log Logger) SetContext(fields ...Field) {
+	log.state().context.Store(newFields(fields))
+}
+
+// Merge the context beneath the fields of the record
+func (st *loggerState) addContext(rec *LogRecord) {
+	ctx, _ := st.context.Load().(Fields)
+	if len(ctx) == 0 {
+		return
+	}
+	fs := make(Fields, len(ctx), len(ctx)+len(rec.Fields))
+	copy(fs, ctx)
+	for _, f := range rec.Fields {
+		fs = fs.Set(f.Key, f.Value)
+	}
+	rec.context, rec.ownFields, rec.Fields = ctx, rec.Fields, fs
+}
+
+// SetMessageParser sets a callback which takes fields out of every message,
+// e.g. ParseKeyValues for messages like "user=42 action=login", to structure
+// the records of legacy code without changing the log calls.  The fields are
+// added to the record, for JSON and logfmt output; fields given to the log
+// call win over them.  The record keeps the message as logged, so %M is
+// unchanged, but the logfmt of %O uses the message returned by the parser as
+// msg.  nil removes the parser.
+func (log Logger) SetMessageParser(parser func(msg string) (string, []Field)) {
+	log.state().parser.Store(messageParser{fn: parser})
+}
+
+// Run the message parser, if any, on the record
+func (st *loggerState) parseMessage(rec *LogRecord) {
+	p, _ := st.parser.Load().(messageParser)
+	if p.fn == nil {
+		return
+	}
+	msg, parsed := p.fn(rec.Message)
+	fs := newFields(parsed)
+	for _, f := range rec.Fields {
+		fs = fs.Set(f.Key, f.Value)
+	}
+	rec.Fields = fs
+	rec.parsedMsg, rec.parsed = msg, true
+}
+
+// SetCallerSkip sets the skip passed to runtime.Caller to find the source of
+// the records of this logger, instead of DefaultCallerSkip.  A wrapper
+// function around the logging methods sets DefaultCallerSkip+1, so that %S
+// shows the caller of the wrapper.
+func (log Logger) SetCallerSkip(skip int) {
+	log.state().callerSkip.Store(skip)
+}
+
+// SetConcurrentDispatch hands every record to the filters in parallel
+// goroutines and waits for all of them, so that a filter which blocks, e.g. a
+// synchronous socket filter while it dials or a buffered filter with a full
+// queue, does not delay the others.  It costs a goroutine per filter and
+// record, and pays off only with slow synchronous filters.  The writers share
+// the record and must not change it.  Off by default.
+func (log Logger) SetConcurrentDispatch(concurrent bool) {
+	var on int32
+	if concurrent {
+		on = 1
+	}
+	atomic.StoreInt32(&log.state().concurrent, on)
+}
+
+// Stats returns the number of records logged per level since the first call
+// of Stats, ResetStats or SetStatsHook on the logger, which starts the
+// counting; call ResetStats when making the logger to count from the start.
+// Every built-in level is included; registered levels once they were logged.
+// Records below the levels of all filters are not counted, nor records
+// dropped by SetPerSourceRate.
+func (log Logger) Stats() map[Level]uint64 {
+	stats := make(map[Level]uint64, CRITICAL+1)
+	st := log.countingState()
+	for lvl := FINEST; lvl <= CRITICAL; lvl++ {
+		stats[lvl] = atomic.LoadUint64(&st.counts[lvl])
+	}
+	st.otherCounts.Range(func(lvl, n interface{}) bool {
+		stats[lvl.(Level)] = atomic.LoadUint64(n.(*uint64))
+		return true
+	})
+	return stats
+}
+
+// ResetStats sets the counts of Stats back to zero, and starts the counting.
+func (log Logger) ResetStats() {
+	st := log.countingState()
+	for lvl := range st.counts {
+		atomic.StoreUint64(&st.counts[lvl], 0)
+	}
+	st.otherCounts.Range(func(lvl, n interface{}) bool {
+		atomic.StoreUint64(n.(*uint64), 0)
+		return true
+	})
+}
+
+// SetStatsHook sets a callback called with the level of every record counted
+// by Stats, e.g. to increment a Prometheus counter.  It is called in the
+// logging goroutine and should be quick.  nil removes the hook.
+func (log Logger) SetStatsHook(hook func(Level)) {
+	log.countingState().statsHook.Store(statsHook{fn: hook})
+}
+
+// Get the state of the logger and start counting the records
+func (log Logger) countingState() *loggerState {
+	st := log.state()
+	atomic.StoreInt32(&st.counting, 1)
+	return st
+}
+
+// Count a record for Stats and pass it to the stats hook
+func (st *loggerState) count(lvl Level) {
+	if atomic.LoadInt32(&st.counting) == 0 {
+		return
+	}
+	if lvl >= FINEST && lvl <= CRITICAL {
+		atomic.AddUint64(&st.counts[lvl], 1)
+	} else {
+		n, ok := st.otherCounts.Load(lvl)
+		if !ok {
+			n, _ = st.otherCounts.LoadOrStore(lvl, new(uint64))
+		}
+		atomic.AddUint64(n.(*uint64), 1)
+	}
+	if h, _ := st.statsHook.Load().(statsHook); h.fn != nil {
+		h.fn(lvl)
+	}
+}
+
+// The skip to find the source of the records of the logger
+func (log Logger) callerSkip() int {
+	if st := log.findState(); st != nil {
+		if skip, ok := st.callerSkip.Load().(int); ok {
+			return skip
+		}
+	}
+	return DefaultCallerSkip
+}
+
+// StartCapture tees every record the logger writes into a new memory writer,
+// e.g. to attach the log of a request to a bug report, until StopCapture.
+// The filters are not changed and keep writing as before.  Only records
+// accepted by some filter are captured.
+func (log Logger) StartCapture() *MemoryLogWriter {
+	mw := NewMemoryLogWriter()
+	st := log.state()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	old, _ := st.captures.Load().([]*MemoryLogWriter)
+	st.captures.Store(append(old[:len(old):len(old)], mw))
+	return mw
+}
+
+// StopCapture detaches a writer returned by StartCapture.  Its records can
+// still be read.
+func (log Logger) StopCapture(mw *MemoryLogWriter) {
+	st := log.findState()
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	old, _ := st.captures.Load().([]*MemoryLogWriter)
+	captures := make([]*MemoryLogWriter, 0, len(old))
+	for _, w := range old {
+		if w != mw {
+			captures = append(captures, w)
+		}
+	}
+	st.captures.Store(captures)
+}
+
+// Write the record to the capturing writers
+func (st *loggerState) capture(rec *LogRecord) {
+	captures, _ := st.captures.Load().([]*MemoryLogWriter)
+	for _, mw := range captures {
+		mw.LogWrite(rec)
+	}
+}
+
+// Merge the provided fields beneath those of the record
+func (st *loggerState) provideFields(rec *LogRecord) {
+	p, _ := st.fields.Load().(fieldsProvider)
+	if p.fn == nil {
+		return
+	}
+	fs := newFields(p.fn())
+	for _, f := range rec.Fields {
+		fs = fs.Set(f.Key, f.Value)
+	}
+	rec.Fields = fs
+}
+
 /******* Logging *******/
 
 // Determine if any logging will be done
 func (log Logger) skip(lvl Level) bool {
 	for _, filt := range log {
-		if lvl >= filt.Level {
+		if lvl >= filt.level() {
 			return false
 		}
 	}
+	if len(log) == 0 {
+		// Records may be held until a filter is added
+		if st := log.findState(); st != nil {
+			st.mu.Lock()
+			defer st.mu.Unlock()
+			return st.earlyMax <= 0 && !st.closed
+		}
+	}
 	return true
 }
 
 // Dispatch the logs
 func (log Logger) dispatch(rec *LogRecord) {
+	// Without a state the logger has no settings to apply
+	st := log.findState()
+	send := log.send
+	if st != nil {
+		if atomic.LoadInt32(&st.concurrent) != 0 {
+			send = log.sendConcurrent
+		}
+		ok, summary := st.limitRate(rec)
+		if !ok {
+			return
+		}
+		if summary != nil {
+			st.count(summary.Level)
+			send(summary)
+		}
+		st.count(rec.Level)
+	}
+	if atomic.LoadInt32(&goroutineIDs) != 0 {
+		rec.goid = goroutineID()
+	}
+	if st != nil {
+		st.parseMessage(rec)
+		st.provideFields(rec)
+		st.addContext(rec)
+		st.capture(rec)
+	}
+
+	if len(log) == 0 && (log.holdEarly(rec) || log.writeAfterClose(rec)) {
+		return
+	}
+	send(rec)
+}
+
+// Write a record logged after Close to stderr, with a notice the first time.
+// Returns false if the logger is not closed.
+func (log Logger) writeAfterClose(rec *LogRecord) bool {
+	st := log.findState()
+	if st == nil {
+		return false
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.closed {
+		return false
+	}
+	if !st.noticed {
+		st.noticed = true
+		fmt.Fprintf(stderr, "log4go: logging used after Close, writing to stderr\n")
+	}
+	fmt.Fprint(closedFallback, FormatLogRecord(FORMAT_DEFAULT, rec))
+	return true
+}
+
+// Write the record to every filter accepting its level
+func (log Logger) send(rec *LogRecord) {
 	for _, filt := range log {
-		if rec.Level < filt.Level {
+		if rec.Level < filt.level() {
 			continue
 		}
 		filt.WriteToChan(rec)
 	}
 }
 
+// Send the record to the filters in parallel, see SetConcurrentDispatch
+func (log Logger) sendConcurrent(rec *LogRecord) {
+	var wg sync.WaitGroup
+	for _, filt := range log {
+		if rec.Level < filt.level() {
+			continue
+		}
+		wg.Add(1)
+		go func(filt *Filter) {
+			defer wg.Done()
+			filt.WriteToChan(rec)
+		}(filt)
+	}
+	wg.Wait()
+}
+
 // Send a formatted log message internally
 func (log Logger) intLogf(lvl Level, format string, args ...interface{}) {
 	if log.skip(lvl) {
 		return
 	}
 
-	// Determine caller func
-	pc, _, lineno, ok := runtime.Caller(DefaultCallerSkip)
-	src := ""
-	if ok {
-		src = fmt.Sprintf("%s:%d", filepath.Base(runtime.FuncForPC(pc).Name()), lineno)
-	}
-
 	msg := format
 	if len(args) > 0 {
 		msg = fmt.Sprintf(format, args...)
@@ -294,11 +1309,13 @@ func (log Logger) intLogf(lvl Level, format string, args ...interface{}) {
 	// Make the log record
 	rec := &LogRecord{
 		Level:   lvl,
-		Created: time.Now(),
-		Source:  src,
+		Created: timeNow(),
 		Message: msg,
 	}
 
+	// Determine caller func
+	rec.setCaller(log.callerSkip())
+
 	log.dispatch(rec)
 }
 
@@ -308,21 +1325,36 @@ func (log Logger) intLogc(lvl Level, closure func() string) {
 		return
 	}
 
+	// Make the log record
+	rec := &LogRecord{
+		Level:   lvl,
+		Created: timeNow(),
+		Message: closure(),
+	}
+
 	// Determine caller func
-	pc, _, lineno, ok := runtime.Caller(DefaultCallerSkip)
-	src := ""
-	if ok {
-		src = fmt.Sprintf("%s:%d", filepath.Base(runtime.FuncForPC(pc).Name()), lineno)
+	rec.setCaller(log.callerSkip())
+
+	log.dispatch(rec)
+}
+
+// Send a log message with fields internally
+func (log Logger) intLogw(lvl Level, msg string, fields []Field) {
+	if log.skip(lvl) {
+		return
 	}
 
 	// Make the log record
 	rec := &LogRecord{
 		Level:   lvl,
-		Created: time.Now(),
-		Source:  src,
-		Message: closure(),
+		Created: timeNow(),
+		Message: msg,
+		Fields:  newFields(fields),
 	}
 
+	// Determine caller func
+	rec.setCaller(log.callerSkip())
+
 	log.dispatch(rec)
 }
 
@@ -335,7 +1367,7 @@ func (log Logger) Log(lvl Level, source, message string) {
 	// Make the log record
 	rec := &LogRecord{
 		Level:   lvl,
-		Created: time.Now(),
+		Created: timeNow(),
 		Source:  source,
 		Message: message,
 	}
@@ -343,6 +1375,42 @@ func (log Logger) Log(lvl Level, source, message string) {
 	log.dispatch(rec)
 }
 
+// LogfSource logs a formatted log message at the given log level with a manual
+// source, e.g. the name of the component a bridge logs for.  The caller is not
+// looked up.
+func (log Logger) LogfSource(lvl Level, source, format string, args ...interface{}) {
+	if log.skip(lvl) {
+		return
+	}
+
+	msg := format
+	if len(args) > 0 {
+		msg = fmt.Sprintf(format, args...)
+	}
+	log.Log(lvl, source, msg)
+}
+
+// LogBytes logs raw data at the given level with a manual source.  The message
+// is the data encoded as set by BinaryEncoding, and the raw bytes are kept in
+// LogRecord.Binary (base64 in JSON output).  Nothing is encoded if no filter
+// accepts the level.
+func (log Logger) LogBytes(lvl Level, source string, data []byte) {
+	if log.skip(lvl) {
+		return
+	}
+
+	// Make the log record
+	rec := &LogRecord{
+		Level:   lvl,
+		Created: timeNow(),
+		Source:  source,
+		Message: encodeBinary(data),
+		Binary:  data,
+	}
+
+	log.dispatch(rec)
+}
+
 // Logf logs a formatted log message at the given log level, using the caller as
 // its source.
 func (log Logger) Logf(lvl Level, format string, args ...interface{}) {
@@ -355,42 +1423,20 @@ func (log Logger) Logc(lvl Level, closure func() string) {
 	log.intLogc(lvl, closure)
 }
 
-// Finest logs a message at the finest log level.
-// See Debug for an explanation of the arguments.
-func (log Logger) Finest(arg0 interface{}, args ...interface{}) {
-	const (
-		lvl = FINEST
-	)
-	switch first := arg0.(type) {
-	case string:
-		// Use the string as a format string
-		log.intLogf(lvl, first, args...)
-	case func() string:
-		// Log the closure (no other arguments used)
-		log.intLogc(lvl, first)
-	default:
-		// Build a format string so that it will be similar to Sprint
-		log.intLogf(lvl, fmt.Sprint(arg0)+strings.Repeat(" %v", len(args)), args...)
-	}
+// LogWith logs a message with key/value fields at the given log level, using
+// the caller as its source.  Fields keep the order given; if a key is
+// repeated, the last value wins.
+func (log Logger) LogWith(lvl Level, msg string, fields ...Field) {
+	log.intLogw(lvl, msg, fields)
 }
 
-// Fine logs a message at the fine log level.
-// See Debug for an explanation of the arguments.
-func (log Logger) Fine(arg0 interface{}, args ...interface{}) {
-	const (
-		lvl = FINE
-	)
-	switch first := arg0.(type) {
-	case string:
-		// Use the string as a format string
-		log.intLogf(lvl, first, args...)
-	case func() string:
-		// Log the closure (no other arguments used)
-		log.intLogc(lvl, first)
-	default:
-		// Build a format string so that it will be similar to Sprint
-		log.intLogf(lvl, fmt.Sprint(arg0)+strings.Repeat(" %v", len(args)), args...)
+// LogFields logs a message with the fields of a map at the given log level,
+// using the caller as its source.  The fields are sorted by key.
+func (log Logger) LogFields(lvl Level, msg string, fields map[string]interface{}) {
+	if log.skip(lvl) {
+		return
 	}
+	log.intLogw(lvl, msg, mapFields(fields))
 }
 
 // Debug is a utility method for debug log messages.
@@ -3,13 +3,35 @@
 package log4go
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -76,6 +98,191 @@ func TestFormatLogRecord(t *testing.T) {
 	}
 }
 
+func TestCompileFormat(t *testing.T) {
+	rec := newLogRecord(ERROR, "source", "message")
+	for format, want := range map[string]string{
+		"":             "",
+		"plain":        "plain\n",
+		"[%L] %M":      "[EROR] message\n",
+		"a%%Mb":        "amessageb\n",
+		"trailing %":   "trailing \n",
+		"%Qunknown %S": "unknown source\n",
+		"%M%M":         "messagemessage\n",
+	} {
+		cf := CompileFormat(format)
+		if got := cf.Format(rec); got != want {
+			t.Errorf("CompileFormat(%q).Format = %q, want %q", format, got, want)
+		}
+		if cf.String() != format {
+			t.Errorf("CompileFormat(%q).String() = %q", format, cf.String())
+		}
+	}
+}
+
+func TestMessageOnlyFormat(t *testing.T) {
+	rec := newLogRecord(ERROR, "source", "already formatted")
+	for _, format := range []string{"%M", "%M\n", "%M;", "%M %L", "x%M"} {
+		fast := CompileFormat(format)
+		general := CompileFormat(format)
+		general.msgOnly, general.suffix = false, ""
+		if got, want := fast.Format(rec), general.Format(rec); got != want {
+			t.Errorf("%q: fast path %q, general path %q", format, got, want)
+		}
+	}
+	if !CompileFormat("%M").msgOnly || !CompileFormat("%M\n").msgOnly || CompileFormat("%M %L").msgOnly {
+		t.Errorf("message only formats not detected")
+	}
+}
+
+func TestRegisterLevel(t *testing.T) {
+	defer levels.Store(builtinLevels)
+	const FATAL = CRITICAL + 1
+	RegisterLevel(FATAL, "FATAL", "FATL")
+
+	rec := newLogRecord(FATAL, "source", "message")
+	if got, want := FormatLogRecord("%L %l", rec), "FATL FATAL\n"; got != want {
+		t.Errorf("registered level = %q, want %q", got, want)
+	}
+	if got, want := FormatLogRecord("%L %l", newLogRecord(WARNING, "source", "message")), "WARN WARNING\n"; got != want {
+		t.Errorf("built-in level = %q, want %q", got, want)
+	}
+	if got := Level(42).String(); got != "UNKNOWN" {
+		t.Errorf("unregistered level = %q, want UNKNOWN", got)
+	}
+
+	// Registered levels are parsed in configurations
+	mw := NewMemoryLogWriter()
+	l := make(Logger)
+	if err := l.ApplyConfig(NewConfigBuilder().Add("fatal", "console", FATAL).Build()); err != nil {
+		t.Fatalf("ApplyConfig: %s", err)
+	}
+	if lvl := l["fatal"].level(); lvl != FATAL {
+		t.Errorf("configured level = %v, want FATL", lvl)
+	}
+	l.Close()
+	l["mem"] = NewSyncFilter(FATAL, mw)
+	l.Log(CRITICAL, "source", "below")
+	l.Log(FATAL, "source", "at")
+	l.Close()
+	if got, want := len(mw.Records()), 1; got != want {
+		t.Errorf("records = %d, want %d", got, want)
+	}
+
+	cfg := NewConfigBuilder().AddConsole("bad", INFO).Build()
+	cfg.Filters[0].Level = "NOPE"
+	if err := l.ApplyConfig(cfg); err == nil || len(l) != 0 {
+		t.Errorf("unknown level: ApplyConfig = %v with %d filters, want an error", err, len(l))
+	}
+
+	for _, bad := range []func(){
+		func() { RegisterLevel(WARNING, "WARN", "W") },
+		func() { RegisterLevel(FATAL+1, "fatal", "N") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterLevel did not panic")
+				}
+			}()
+			bad()
+		}()
+	}
+}
+
+func TestLevelLabels(t *testing.T) {
+	defer SetLevelLabels(nil)
+	rec := newLogRecord(ERROR, "source", "message")
+
+	if got, want := FormatLogRecord("%L %l", rec), "EROR ERROR\n"; got != want {
+		t.Errorf("default labels = %q, want %q", got, want)
+	}
+	SetLevelLabels(map[Level]string{ERROR: "E", WARNING: "W"})
+	if got, want := FormatLogRecord("%L %l", rec), "E ERROR\n"; got != want {
+		t.Errorf("custom labels = %q, want %q", got, want)
+	}
+	rec.Level = INFO
+	if got, want := FormatLogRecord("%L %l", rec), "INFO INFO\n"; got != want {
+		t.Errorf("unchanged label = %q, want %q", got, want)
+	}
+	SetLevelLabels(nil)
+	rec.Level = WARNING
+	if got, want := FormatLogRecord("%L %l", rec), "WARN WARNING\n"; got != want {
+		t.Errorf("restored labels = %q, want %q", got, want)
+	}
+}
+
+func TestLogfmtFormat(t *testing.T) {
+	rec := newLogRecord(ERROR, "source", `disk "data" is full`)
+	rec.Fields = rec.Fields.Set("path", "/var/lib/my data").Set("pct", 99).Set(`bad key="x"`, 1)
+
+	const want = `time=2009-02-13T23:31:30.123456789Z level=error source=source msg="disk \"data\" is full" path="/var/lib/my data" pct=99 bad_key__x_=1` + "\n"
+	got := FormatLogRecord(FORMAT_LOGFMT, rec)
+	if got != want {
+		t.Fatalf("logfmt:\n   got %q\n  want %q", got, want)
+	}
+
+	// Parse it back
+	kv := make(map[string]string)
+	line := strings.TrimSuffix(got, "\n")
+	for len(line) > 0 {
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			t.Fatalf("missing key at %q", line)
+		}
+		key, rest := line[:eq], line[eq+1:]
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				t.Fatalf("bad quoted value at %q: %s", rest, err)
+			}
+			val, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else if sp := strings.IndexByte(rest, ' '); sp >= 0 {
+			val, rest = rest[:sp], rest[sp:]
+		} else {
+			val, rest = rest, ""
+		}
+		kv[key] = val
+		line = strings.TrimPrefix(rest, " ")
+	}
+	if kv["msg"] != rec.Message || kv["path"] != "/var/lib/my data" || kv["pct"] != "99" || kv["level"] != "error" || kv["bad_key__x_"] != "1" {
+		t.Errorf("parsed logfmt = %q", kv)
+	}
+}
+
+func TestTrimSourcePrefix(t *testing.T) {
+	defer func(prefix string) {
+		TrimSourcePrefix = prefix
+	}(TrimSourcePrefix)
+	rec := newLogRecord(INFO, "github.com/org/repo/pkg/file.go:12", "message")
+
+	if got, want := FormatLogRecord("%S", rec), "github.com/org/repo/pkg/file.go:12\n"; got != want {
+		t.Errorf("untrimmed source = %q, want %q", got, want)
+	}
+	TrimSourcePrefix = "github.com/org/repo/"
+	if got, want := FormatLogRecord("%S", rec), "pkg/file.go:12\n"; got != want {
+		t.Errorf("trimmed source = %q, want %q", got, want)
+	}
+	rec.Source = "other/pkg/file.go:12"
+	if got, want := FormatLogRecord("%S", rec), "other/pkg/file.go:12\n"; got != want {
+		t.Errorf("source without prefix = %q, want %q", got, want)
+	}
+
+	// The path of a captured source
+	_, file, _, _ := runtime.Caller(0)
+	TrimSourcePrefix = file[:strings.LastIndex(file, "/")+1]
+	mw := NewMemoryLogWriter().SetFormat("%F")
+	l := make(Logger)
+	l["mem"] = NewSyncFilter(INFO, mw)
+	_, _, line, _ := runtime.Caller(0)
+	l.Info("captured")
+	l.Close()
+	if got, want := mw.String(), fmt.Sprintf("log4go_test.go:%d\n", line+1); got != want {
+		t.Errorf("trimmed captured path = %q, want %q", got, want)
+	}
+}
+
 var logRecordWriteTests = []struct {
 	Test    string
 	Record  *LogRecord
@@ -120,6 +327,263 @@ func TestConsoleLogWriter(t *testing.T) {
 	}
 }
 
+func TestConsoleColorEnv(t *testing.T) {
+	defer func(colorful bool, terminal func(io.Writer) bool) {
+		isColorful, isTerminal = colorful, terminal
+	}(isColorful, isTerminal)
+	isTerminal = func(io.Writer) bool { return true }
+	for _, key := range []string{"FORCE_COLOR", "NO_COLOR"} {
+		if v, ok := os.LookupEnv(key); ok {
+			defer os.Setenv(key, v)
+		} else {
+			defer os.Unsetenv(key)
+		}
+	}
+
+	tests := []struct {
+		force, noColor string // "-" for unset
+		terminal       bool
+		color          bool
+		want           bool
+	}{
+		{"-", "-", true, true, true},
+		{"-", "-", true, false, false},
+		{"-", "-", false, true, false},
+		{"-", "", true, true, false},
+		{"-", "1", true, true, false},
+		{"", "-", false, false, true},
+		{"1", "1", false, false, true},
+	}
+	for _, test := range tests {
+		for key, v := range map[string]string{"FORCE_COLOR": test.force, "NO_COLOR": test.noColor} {
+			if v == "-" {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, v)
+			}
+		}
+		isColorful = test.terminal
+
+		buf := new(bytes.Buffer)
+		c := NewConsoleLogWriter().SetColor(test.color).SetFormat("%L %M")
+		c.out = buf
+		c.LogWrite(newLogRecord(ERROR, "source", "message"))
+		if got := bytes.HasPrefix(buf.Bytes(), ColorBytes[ERROR]); got != test.want {
+			t.Errorf("FORCE_COLOR=%q NO_COLOR=%q terminal=%v SetColor(%v): colored = %v, want %v",
+				test.force, test.noColor, test.terminal, test.color, got, test.want)
+		}
+	}
+}
+
+func TestConsoleErrStream(t *testing.T) {
+	out, errOut := new(bytes.Buffer), new(bytes.Buffer)
+	console := NewConsoleLogWriter().SetFormat("[%L] %M").SetErrStream(errOut)
+	console.out = out
+	for lvl := DEBUG; lvl <= ERROR; lvl++ {
+		console.LogWrite(newLogRecord(lvl, "source", "message"))
+	}
+	console.Close()
+
+	if got, want := out.String(), "[DEBG] message\n[TRAC] message\n[INFO] message\n"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	if got, want := errOut.String(), "[WARN] message\n[EROR] message\n"; got != want {
+		t.Errorf("stderr = %q, want %q", got, want)
+	}
+
+	out.Reset()
+	errOut.Reset()
+	console = NewConsoleLogWriter().SetFormat("[%L] %M").SetErrStream(errOut).SetErrLevel(ERROR)
+	console.out = out
+	console.LogWrite(newLogRecord(WARNING, "source", "message"))
+	console.LogWrite(newLogRecord(CRITICAL, "source", "message"))
+	if out.String() != "[WARN] message\n" || errOut.String() != "[CRIT] message\n" {
+		t.Errorf("SetErrLevel(ERROR): stdout = %q, stderr = %q", out, errOut)
+	}
+}
+
+func TestConsoleColorLevel(t *testing.T) {
+	defer func(colorful bool, terminal func(io.Writer) bool) {
+		isColorful, isTerminal = colorful, terminal
+	}(isColorful, isTerminal)
+	for _, key := range []string{"FORCE_COLOR", "NO_COLOR"} {
+		if v, ok := os.LookupEnv(key); ok {
+			defer os.Setenv(key, v)
+			os.Unsetenv(key)
+		}
+	}
+	isColorful = true
+
+	for _, terminal := range []bool{true, false} {
+		isTerminal = func(io.Writer) bool { return terminal }
+		buf := new(bytes.Buffer)
+		c := NewConsoleLogWriter().SetColor(true).SetFormat("[%L] %M")
+		c.out = buf
+		c.LogWrite(newLogRecord(ERROR, "source", "message"))
+		c.LogWrite(newLogRecord(INFO, "source", "message"))
+
+		want := "[EROR] message\n[INFO] message\n"
+		if terminal {
+			want = "[" + string(ColorBytes[ERROR]) + "EROR" + string(ColorReset) + "] message\n[INFO] message\n"
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("terminal %v: output = %q, want %q", terminal, got, want)
+		}
+	}
+
+	// A file is not a terminal
+	f, err := ioutil.TempFile("", "log4go")
+	if err != nil {
+		t.Fatalf("tempfile: %s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if terminalWriter(f) || terminalWriter(new(bytes.Buffer)) {
+		t.Errorf("a file or a buffer is taken for a terminal")
+	}
+}
+
+func TestDiagnosticsThrottled(t *testing.T) {
+	defer func(w io.Writer, clock func() time.Time, max int) {
+		stderr, timeNow, DiagnosticsPerSecond = w, clock, max
+	}(stderr, timeNow, DiagnosticsPerSecond)
+	clock := now
+	timeNow = func() time.Time { return clock }
+	DiagnosticsPerSecond = 5
+
+	errs := new(bytes.Buffer)
+	stderr = newThrottledWriter(errs)
+	console := NewConsoleLogWriter().SetFormatter(func(*LogRecord) ([]byte, error) {
+		return nil, errors.New("cannot render")
+	})
+	for i := 0; i < 100; i++ {
+		console.LogWrite(newLogRecord(INFO, "source", "message"))
+	}
+	clock = clock.Add(time.Second)
+	console.LogWrite(newLogRecord(INFO, "source", "message"))
+
+	want := strings.Repeat("ConsoleLogWriter: cannot render\n", 5) +
+		"log4go: 95 diagnostics suppressed\n" +
+		"ConsoleLogWriter: cannot render\n"
+	stderr.(*throttledWriter).mu.Lock()
+	defer stderr.(*throttledWriter).mu.Unlock()
+	if got := errs.String(); got != want {
+		t.Errorf("stderr = %q, want %q", got, want)
+	}
+}
+
+func TestConsoleHeadFoot(t *testing.T) {
+	buf := new(bytes.Buffer)
+	console := NewConsoleLogWriter().SetFormat("[%L] %M").SetHeadFoot("== start %D ==", "== stop %T ==")
+	console.out = buf
+
+	console.Close()
+	if buf.Len() != 0 {
+		t.Errorf("footer written without records: %q", buf)
+	}
+
+	console.LogWrite(newLogRecord(INFO, "source", "first"))
+	console.LogWrite(newLogRecord(ERROR, "source", "second"))
+	console.Close()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want 4: %q", len(lines), buf)
+	}
+	if !regexp.MustCompile(`^== start \d{4}/\d\d/\d\d ==$`).MatchString(lines[0]) {
+		t.Errorf("header = %q", lines[0])
+	}
+	if lines[1] != "[INFO] first" || lines[2] != "[EROR] second" {
+		t.Errorf("body = %q", lines[1:3])
+	}
+	if !regexp.MustCompile(`^== stop \d\d:\d\d:\d\d ==$`).MatchString(lines[3]) {
+		t.Errorf("footer = %q", lines[3])
+	}
+}
+
+func TestConsoleHeadFootAsync(t *testing.T) {
+	buf := new(bytes.Buffer)
+	console := NewConsoleLogWriter().SetFormat("%M").SetHeadFoot("head", "foot")
+	console.out = buf
+
+	// Records written by the filter goroutine, the footer by Close
+	l := Logger{"console": NewFilter(INFO, console)}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.Info("record %d", i)
+		}(i)
+	}
+	wg.Wait()
+	l.Close()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 6 || lines[0] != "head" || lines[5] != "foot" {
+		t.Errorf("output = %q", buf)
+	}
+}
+
+// A writer whose first write blocks until released
+type stallWriter struct {
+	bytes.Buffer
+	entered, release chan struct{}
+	once             sync.Once
+}
+
+func (w *stallWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.entered)
+		<-w.release
+	})
+	return w.Buffer.Write(p)
+}
+
+func TestConsoleAsync(t *testing.T) {
+	defer func(w io.Writer) {
+		stderr = w
+	}(stderr)
+	errs := new(bytes.Buffer)
+	stderr = errs
+
+	out := &stallWriter{entered: make(chan struct{}), release: make(chan struct{})}
+	console := NewConsoleLogWriter().SetFormat("%M").SetAsync(1)
+	console.out = out
+
+	// The first record stalls the writer, the second is queued and the
+	// others are dropped without blocking
+	console.LogWrite(newLogRecord(INFO, "source", "first"))
+	<-out.entered
+	for _, msg := range []string{"second", "third", "fourth"} {
+		console.LogWrite(newLogRecord(INFO, "source", msg))
+	}
+	if n := console.Dropped(); n != 2 {
+		t.Errorf("dropped %d records, want 2", n)
+	}
+	close(out.release)
+	console.Close()
+
+	if got, want := out.String(), "first\nsecond\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if !strings.Contains(errs.String(), "2 records dropped") {
+		t.Errorf("drops not reported: %q", errs)
+	}
+
+	// Blocking keeps every record
+	buf := new(bytes.Buffer)
+	console = NewConsoleLogWriter().SetFormat("%M").SetAsync(1).SetBlocking(true)
+	console.out = buf
+	for i := 0; i < 100; i++ {
+		console.LogWrite(newLogRecord(INFO, "source", "msg"))
+	}
+	console.Close()
+	if n := strings.Count(buf.String(), "msg\n"); n != 100 || console.Dropped() != 0 {
+		t.Errorf("blocking: wrote %d records and dropped %d, want 100 and 0", n, console.Dropped())
+	}
+}
+
 func TestFileLogWriter(t *testing.T) {
 	defer func(buflen int) {
 		DefaultBufferLength = buflen
@@ -134,7 +598,6 @@ func TestFileLogWriter(t *testing.T) {
 
 	w.LogWrite(newLogRecord(CRITICAL, "source", "message"))
 	w.Close()
-	runtime.Gosched()
 
 	if contents, err := ioutil.ReadFile(testLogFile); err != nil {
 		t.Errorf("read(%q): %s", testLogFile, err)
@@ -143,6 +606,32 @@ func TestFileLogWriter(t *testing.T) {
 	}
 }
 
+func TestWaitIdle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "app.log")
+	l := Logger{"file": NewBufferedFilter(INFO, NewFileLogWriter(fname, false).SetFormat("%M").SetHeadFoot("", ""), 1000)}
+	defer l.Close()
+	for i := 0; i < 100; i++ {
+		l.Info("line %d", i)
+	}
+	if !l.WaitIdle(5 * time.Second) {
+		t.Fatalf("filters still busy")
+	}
+
+	got, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	if lines := strings.Split(strings.TrimSuffix(string(got), "\n"), "\n"); len(lines) != 100 || lines[99] != "line 99" {
+		t.Errorf("file has %d lines, the last %q", len(lines), lines[len(lines)-1])
+	}
+}
+
 func TestXMLLogWriter(t *testing.T) {
 	defer func(buflen int) {
 		DefaultBufferLength = buflen
@@ -157,7 +646,6 @@ func TestXMLLogWriter(t *testing.T) {
 
 	w.LogWrite(newLogRecord(CRITICAL, "source", "message"))
 	w.Close()
-	runtime.Gosched()
 
 	if contents, err := ioutil.ReadFile(testLogFile); err != nil {
 		t.Errorf("read(%q): %s", testLogFile, err)
@@ -166,63 +654,2701 @@ func TestXMLLogWriter(t *testing.T) {
 	}
 }
 
-func TestLogger(t *testing.T) {
-	sl := NewDefaultLogger(WARNING)
-	if sl == nil {
-		t.Fatalf("NewDefaultLogger should never return nil")
+func TestFileMagic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
 	}
-	if lw, exist := sl["stdout"]; lw == nil || exist != true {
-		t.Fatalf("NewDefaultLogger produced invalid logger (DNE or nil)")
+	defer os.RemoveAll(dir)
+
+	bom := []byte{0xEF, 0xBB, 0xBF}
+	fname := filepath.Join(dir, "app.log")
+	w := NewFileLogWriter(fname, true).SetFileMagic(bom).SetHeadFoot("head", "").SetFormat("%M").SetRotateLines(1)
+	w.LogWrite(newLogRecord(INFO, "source", "first"))
+	w.LogWrite(newLogRecord(INFO, "source", "second"))
+	rotated := w.rotatedLogs()
+	w.Close()
+
+	if len(rotated) != 1 {
+		t.Fatalf("rotated files = %v, want one", rotated)
 	}
-	if sl["stdout"].Level != WARNING {
-		t.Fatalf("NewDefaultLogger produced invalid logger (incorrect level)")
+	for name, want := range map[string]string{
+		rotated[0]: "\xEF\xBB\xBFhead\nfirst\n",
+		fname:      "\xEF\xBB\xBFhead\nsecond\n",
+	} {
+		if got, err := ioutil.ReadFile(name); err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v, want %q", filepath.Base(name), got, err, want)
+		}
 	}
-	if len(sl) != 1 {
-		t.Fatalf("NewDefaultLogger produced invalid logger (incorrect map count)")
+
+	// Appending to an existing file adds no magic
+	w = NewFileLogWriter(fname, false).SetFileMagic(bom).SetFormat("%M")
+	w.LogWrite(newLogRecord(INFO, "source", "third"))
+	w.Close()
+	if got, _ := ioutil.ReadFile(fname); bytes.Count(got, bom) != 1 {
+		t.Errorf("appended file has the magic %d times, want once", bytes.Count(got, bom))
 	}
+}
 
-	//func (l *Logger) AddFilter(name string, level int, writer LogWriter) {}
-	l := make(Logger)
-	l.AddFilter("stdout", DEBUG, NewConsoleLogWriter())
-	if lw, exist := l["stdout"]; lw == nil || exist != true {
-		t.Fatalf("AddFilter produced invalid logger (DNE or nil)")
+func TestFileRotateCoalesced(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
 	}
-	if l["stdout"].Level != DEBUG {
-		t.Fatalf("AddFilter produced invalid logger (incorrect level)")
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "app.log")
+	w := NewFileLogWriter(fname, true).SetFormat("%M").SetRotateSize(1).SetRotateDaily(true)
+	w.LogWrite(newLogRecord(INFO, "source", "first"))
+
+	// Rotations for size and for the day requested back to back
+	for i := 0; i < 2; i++ {
+		if err := w.intRotate(); err != nil {
+			t.Fatalf("rotate %d: %s", i, err)
+		}
 	}
-	if len(l) != 1 {
-		t.Fatalf("AddFilter produced invalid logger (incorrect map count)")
+	w.LogWrite(newLogRecord(INFO, "source", "second"))
+	rotated := w.rotatedLogs()
+	w.Close()
+
+	if len(rotated) != 1 {
+		t.Fatalf("rotated files = %v, want one", rotated)
+	}
+	for name, want := range map[string]string{rotated[0]: "first\n", fname: "second\n"} {
+		if got, err := ioutil.ReadFile(name); err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v, want %q", filepath.Base(name), got, err, want)
+		}
 	}
+}
 
-	//func (l *Logger) Warn(format string, args ...interface{}) error {}
-	if err := l.Warn("%s %d %#v", "Warning:", 1, []int{}); err.Error() != "Warning: 1 []int{}" {
-		t.Errorf("Warn returned invalid error: %s", err)
+func TestFileRotateNoCollision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
 	}
+	defer os.RemoveAll(dir)
 
-	//func (l *Logger) Error(format string, args ...interface{}) error {}
-	if err := l.Error("%s %d %#v", "Error:", 10, []string{}); err.Error() != "Error: 10 []string{}" {
-		t.Errorf("Error returned invalid error: %s", err)
+	// Rotations within the same second each get a file of their own
+	fname := filepath.Join(dir, "app.log")
+	w := NewFileLogWriter(fname, true).SetFormat("%M").SetRotateSize(1)
+	for i := 0; i < 5; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", fmt.Sprintf("msg%d", i)))
 	}
+	rotated := w.rotatedLogs()
+	w.Close()
 
-	//func (l *Logger) Critical(format string, args ...interface{}) error {}
-	if err := l.Critical("%s %d %#v", "Critical:", 100, []int64{}); err.Error() != "Critical: 100 []int64{}" {
-		t.Errorf("Critical returned invalid error: %s", err)
+	var all []string
+	for _, name := range append(rotated, fname) {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("read %s: %s", filepath.Base(name), err)
+		}
+		all = append(all, string(data))
+	}
+	if got, want := strings.Join(all, ""), "msg0\nmsg1\nmsg2\nmsg3\nmsg4\n"; got != want {
+		t.Errorf("logged %q, want %q", got, want)
+	}
+
+	// A file rotated after the directory was listed is not overwritten
+	todate := time.Now().Format("2006-01-02")
+	taken := fmt.Sprintf("%s.%s.%03d", fname, todate, len(rotated)+1)
+	if err := ioutil.WriteFile(taken, []byte("other\n"), 0660); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	name, ok := w.freeRotatedName(todate, len(rotated)+1)
+	if want := fmt.Sprintf("%s.%s.%03d", fname, todate, len(rotated)+2); !ok || name != want {
+		t.Errorf("freeRotatedName = %q, %v, want %q", name, ok, want)
+	}
+}
+
+func TestFileRotateOnOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "app.log")
+	backup := fname + "." + time.Now().Format("2006-01-02") + ".001"
+
+	// No file of a previous run: nothing to rotate
+	w := NewFileLogWriter(fname, false).SetFormat("%M").SetRotateOnOpen(true)
+	w.LogWrite(newLogRecord(INFO, "source", "run 1"))
+	w.LogWrite(newLogRecord(INFO, "source", "run 1 again"))
+	w.Close()
+	if _, err := os.Stat(backup); !os.IsNotExist(err) {
+		t.Errorf("backup of a new file: %v", err)
+	}
+
+	// Without the option the next run appends
+	w = NewFileLogWriter(fname, false).SetFormat("%M")
+	w.LogWrite(newLogRecord(INFO, "source", "run 2"))
+	w.Close()
+
+	// With it the file of the previous runs is kept as a backup
+	w = NewFileLogWriter(fname, false).SetFormat("%M").SetRotateOnOpen(true)
+	w.LogWrite(newLogRecord(INFO, "source", "run 3"))
+	w.LogWrite(newLogRecord(INFO, "source", "run 3 again"))
+	if got, _ := w.GetOption("rotateonopen"); got != true {
+		t.Errorf("GetOption(rotateonopen) = %v", got)
+	}
+	w.Close()
+
+	if got, _ := ioutil.ReadFile(backup); string(got) != "run 1\nrun 1 again\nrun 2\n" {
+		t.Errorf("backup = %q", got)
+	}
+	if got, _ := ioutil.ReadFile(fname); string(got) != "run 3\nrun 3 again\n" {
+		t.Errorf("new file = %q", got)
+	}
+}
+
+func TestFileRotateDailySmall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Far below any size limit, the date alone rotates the file, also after
+	// a month without writes
+	for i, opened := range []time.Time{time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, -1, 0)} {
+		fname := filepath.Join(dir, fmt.Sprintf("daily%d.log", i))
+		w := NewFileLogWriter(fname, true).SetFormat("%M").SetRotateSize(1 << 20).SetRotateDaily(true)
+		w.LogWrite(newLogRecord(INFO, "source", "old"))
+		w.daily_opendate = opened
+		w.LogWrite(newLogRecord(INFO, "source", "new"))
+		w.Close()
+
+		rotated := fname + "." + opened.Format("2006-01-02") + ".001"
+		if got, _ := ioutil.ReadFile(rotated); string(got) != "old\n" {
+			t.Errorf("opened %s: rotated file = %q, want %q", opened.Format("2006-01-02"), got, "old\n")
+		}
+		if got, _ := ioutil.ReadFile(fname); string(got) != "new\n" {
+			t.Errorf("opened %s: current file = %q, want %q", opened.Format("2006-01-02"), got, "new\n")
+		}
+	}
+}
+
+func TestFileFallback(t *testing.T) {
+	defer func(w io.Writer, interval time.Duration) {
+		stderr, fileRetryInterval = w, interval
+	}(stderr, fileRetryInterval)
+	errs := new(bytes.Buffer)
+	stderr = errs
+	fileRetryInterval = time.Hour
+
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	logdir := filepath.Join(dir, "logs")
+	os.Mkdir(logdir, 0755)
+	fname := filepath.Join(logdir, "app.log")
+
+	fallback := new(bytes.Buffer)
+	w := NewFileLogWriter(fname, false).SetFormat("%M").SetRotateLines(1).SetFallback(fallback)
+	defer w.Close()
+	w.LogWrite(newLogRecord(INFO, "source", "written"))
+
+	// The directory is gone: the rotation cannot reopen the file
+	os.RemoveAll(logdir)
+	for i := 0; i < 100; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", "lost"))
+	}
+	if n := strings.Count(fallback.String(), "lost\n"); n != 100 {
+		t.Errorf("fallback holds %d records, want 100", n)
+	}
+	if n := strings.Count(errs.String(), "writing to the fallback"); n != 1 {
+		t.Errorf("failure reported %d times, want once: %q", n, errs.String())
+	}
+	if _, err := os.Stat(fname); !os.IsNotExist(err) {
+		t.Errorf("reopened within the retry interval: %v", err)
+	}
+
+	// Once the retry interval is over the file is reopened
+	os.Mkdir(logdir, 0755)
+	w.retryAt = time.Time{}
+	w.LogWrite(newLogRecord(INFO, "source", "back"))
+	if got, _ := ioutil.ReadFile(fname); string(got) != "back\n" {
+		t.Errorf("after reopening = %q", got)
+	}
+	if !strings.Contains(errs.String(), "reopened") {
+		t.Errorf("recovery not reported: %q", errs.String())
+	}
+}
+
+func TestOpenRotatedReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "app.log")
+
+	if _, err := OpenRotatedReader(fname); !os.IsNotExist(err) {
+		t.Errorf("no files: err = %v, want not exist", err)
+	}
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("two\n"))
+	zw.Close()
+	for name, content := range map[string][]byte{
+		"app.log.2001-01-01.001":    []byte("one\n"),
+		"app.log.2001-01-01.002.gz": gz.Bytes(),
+		"app.log.2001-01-02.001":    []byte("three\n"),
+		"app.log":                   []byte("live\n"),
+		"app.log.bak":               []byte("not rotated\n"),
+		"other.log.2001-01-01.001":  []byte("other\n"),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0660); err != nil {
+			t.Fatalf("write: %s", err)
+		}
+	}
+
+	r, err := OpenRotatedReader(fname)
+	if err != nil {
+		t.Fatalf("OpenRotatedReader: %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	if want := "one\ntwo\nthree\nlive\n"; string(got) != want {
+		t.Errorf("content = %q, want %q", got, want)
+	}
+}
+
+func TestFileHomeDir(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("the home directory is not taken from $HOME")
+	}
+	defer func(w io.Writer) {
+		stderr = w
+	}(stderr)
+	errs := new(bytes.Buffer)
+	stderr = errs
+
+	home, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+	defer os.RemoveAll("~")
+
+	w := NewFileLogWriter("~/app.log", false)
+	if w == nil {
+		t.Fatalf("NewFileLogWriter(~/app.log) failed: %s", errs)
+	}
+	w.Close()
+	if _, err := os.Stat(filepath.Join(home, "app.log")); err != nil {
+		t.Errorf("not in the home directory: %s", err)
+	}
+	if _, err := os.Stat("~"); !os.IsNotExist(err) {
+		t.Errorf("a ~ directory was created: %v", err)
+	}
+
+	if got, err := expandHome("~"); err != nil || got != home {
+		t.Errorf("expandHome(~) = %q, %v, want %q", got, err, home)
+	}
+	if w := NewFileLogWriter("~bob/app.log", false); w != nil {
+		t.Errorf("NewFileLogWriter(~bob/app.log) succeeded")
+	}
+	if !strings.Contains(errs.String(), "~user") {
+		t.Errorf("no error for ~bob: %q", errs.String())
+	}
+}
+
+func TestFileRotateRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "app.log")
+	today := time.Now().Format("2006-01-02")
+
+	// A gappy sequence, e.g. after .002 and .004 were deleted by hand, and a
+	// file which is not a rotated log
+	for _, suffix := range []string{"2001-01-01.007", today + ".001", today + ".003", today + ".005", "gz"} {
+		if err := ioutil.WriteFile(fname+"."+suffix, []byte(suffix), 0660); err != nil {
+			t.Fatalf("write: %s", err)
+		}
+	}
+
+	w := NewFileLogWriter(fname, true).SetFormat("%M").SetRotateLines(1).SetRotateBackup(2)
+	w.LogWrite(newLogRecord(INFO, "source", "first"))
+	w.LogWrite(newLogRecord(INFO, "source", "second"))
+	rotated := w.rotatedLogs()
+	w.Close()
+
+	want := []string{fname + "." + today + ".005", fname + "." + today + ".006", fname + ".gz"}
+	if fmt.Sprint(rotated) != fmt.Sprint(want) {
+		t.Fatalf("rotated files = %v, want %v", rotated, want)
+	}
+	if got, _ := ioutil.ReadFile(want[1]); string(got) != "first\n" {
+		t.Errorf("newest rotated file = %q, want %q", got, "first\n")
+	}
+}
+
+func TestSpoolLogWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(rename func(string, string) error) {
+		renameFile = rename
+	}(renameFile)
+	var renames []string
+	renameFile = func(from, to string) error {
+		if from != to+".tmp" {
+			t.Errorf("renamed %s to %s", from, to)
+		}
+		if _, err := os.Stat(to); err == nil {
+			t.Errorf("%s exists before the rename", to)
+		}
+		renames = append(renames, to)
+		return os.Rename(from, to)
+	}
+
+	spool := filepath.Join(dir, "spool")
+	w := NewSpoolLogWriter(spool).SetFormat("%L %M").SetMaxFiles(3)
+	for _, msg := range []string{"one", "two", "three", "four"} {
+		w.LogWrite(newLogRecord(INFO, "source", msg))
+	}
+	w.Close()
+
+	infos, err := ioutil.ReadDir(spool)
+	if err != nil {
+		t.Fatalf("readdir: %s", err)
+	}
+	if len(infos) != 3 || len(renames) != 4 {
+		t.Fatalf("%d files and %d renames, want 3 and 4", len(infos), len(renames))
+	}
+	for i, fi := range infos {
+		name := filepath.Join(spool, fi.Name())
+		if name != renames[i+1] {
+			t.Errorf("file %d = %s, want %s", i, fi.Name(), filepath.Base(renames[i+1]))
+		}
+		want := "INFO " + []string{"two", "three", "four"}[i] + "\n"
+		if got, _ := ioutil.ReadFile(name); string(got) != want {
+			t.Errorf("%s = %q, want %q", fi.Name(), got, want)
+		}
+	}
+}
+
+func TestFileRotateMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "app.log")
+	today := time.Now().Format("2006-01-02")
+	for suffix, age := range map[string]time.Duration{
+		"2001-01-01.001": 50 * time.Hour,
+		"2001-01-01.002": 49 * time.Hour,
+		today + ".001":   time.Hour,
+		today + ".002":   time.Minute,
+		"gz":             100 * time.Hour,
+	} {
+		name := fname + "." + suffix
+		if err := ioutil.WriteFile(name, []byte(suffix), 0660); err != nil {
+			t.Fatalf("write: %s", err)
+		}
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatalf("chtimes: %s", err)
+		}
+	}
+
+	// Age prunes the two old files, then the count the oldest young one
+	w := NewFileLogWriter(fname, true).SetFormat("%M").SetRotateLines(1).SetRotateBackup(2).SetMaxAge(48 * time.Hour)
+	w.LogWrite(newLogRecord(INFO, "source", "first"))
+	w.LogWrite(newLogRecord(INFO, "source", "second"))
+	rotated := w.rotatedLogs()
+	w.Close()
+
+	want := []string{fname + "." + today + ".002", fname + "." + today + ".003", fname + ".gz"}
+	if fmt.Sprint(rotated) != fmt.Sprint(want) {
+		t.Errorf("rotated files = %v, want %v", rotated, want)
+	}
+
+	flw, ok := propToFileLogWriter("test.xml", []kvProperty{{Name: "filename", Value: fname}, {Name: "maxage", Value: "720h"}}, true)
+	if !ok || flw.maxage != 720*time.Hour {
+		t.Errorf("maxage property: %v, %v", ok, flw)
+	}
+	if flw != nil {
+		flw.Close()
+	}
+}
+
+func TestFileGetOption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "app.log")
+	w, ok := propToFileLogWriter("test.xml", []kvProperty{
+		{Name: "filename", Value: fname},
+		{Name: "format", Value: "%L %M"},
+		{Name: "maxlines", Value: "2K"},
+		{Name: "maxsize", Value: "1M"},
+		{Name: "maxdays", Value: "7"},
+		{Name: "maxage", Value: "36h"},
+		{Name: "daily", Value: "true"},
+		{Name: "rotate", Value: "true"},
+		{Name: "maxBackup", Value: "5"},
+		{Name: "timezone", Value: "UTC"},
+	}, true)
+	if !ok {
+		t.Fatalf("propToFileLogWriter failed")
+	}
+	defer w.Close()
+
+	for name, want := range map[string]interface{}{
+		"filename":  fname,
+		"format":    "%L %M",
+		"maxlines":  2000,
+		"maxsize":   1024 * 1024,
+		"maxdays":   7,
+		"maxage":    36 * time.Hour,
+		"daily":     true,
+		"rotate":    true,
+		"maxBackup": 5,
+		"timezone":  time.UTC,
+	} {
+		if got, err := w.GetOption(name); err != nil || got != want {
+			t.Errorf("GetOption(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := w.GetOption("cycle"); err != ErrBadOption {
+		t.Errorf("GetOption of an unknown option: %v, want ErrBadOption", err)
+	}
+}
+
+func TestFileFlushInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Buffered until Close
+	fname := filepath.Join(dir, "hour.log")
+	w := NewFileLogWriter(fname, false).SetFormat("%M").SetFlushInterval(time.Hour)
+	w.LogWrite(newLogRecord(INFO, "source", "buffered"))
+	if got, _ := ioutil.ReadFile(fname); len(got) != 0 {
+		t.Errorf("written before the interval: %q", got)
+	}
+	w.Close()
+	if got, _ := ioutil.ReadFile(fname); string(got) != "buffered\n" {
+		t.Errorf("after Close = %q", got)
+	}
+
+	// Flushed by the ticker
+	fname = filepath.Join(dir, "short.log")
+	w = NewFileLogWriter(fname, false).SetFormat("%M").SetFlushInterval(10 * time.Millisecond)
+	defer w.Close()
+	w.LogWrite(newLogRecord(INFO, "source", "ticked"))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, _ := ioutil.ReadFile(fname)
+		if string(got) == "ticked\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("not flushed by the ticker: %q", got)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLoggerFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "flush.log")
+	mw := NewMemoryLogWriter().SetFormat("%M")
+	l := Logger{
+		"file": NewFilter(INFO, NewFileLogWriter(fname, false).SetFormat("%M").SetFlushInterval(time.Hour)),
+		"mem":  NewFilter(INFO, mw),
+		"sync": NewSyncFilter(INFO, NewMemoryLogWriter()),
+	}
+	defer l.Close()
+	for i := 0; i < 100; i++ {
+		l.Log(INFO, "source", "message")
+	}
+	l.Flush()
+
+	// Every record is written, including those buffered by the file writer
+	if got, _ := ioutil.ReadFile(fname); strings.Count(string(got), "message\n") != 100 {
+		t.Errorf("file holds %d records, want 100", strings.Count(string(got), "message\n"))
+	}
+	if n := len(mw.Records()); n != 100 {
+		t.Errorf("memory writer holds %d records, want 100", n)
+	}
+
+	// The filters stay open
+	l.Log(INFO, "source", "after")
+	l.Flush()
+	if got, _ := ioutil.ReadFile(fname); !strings.HasSuffix(string(got), "after\n") {
+		t.Errorf("record after Flush not written: %q", got)
+	}
+
+	// Closed filters are skipped
+	closed := Logger{"mem": NewFilter(INFO, NewMemoryLogWriter())}
+	closed["mem"].Close()
+	closed.Flush()
+}
+
+func TestFileRotateTrigger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "app.log")
+	w := NewFileLogWriter(fname, true).SetFormat("%M")
+	trigger := w.RotateTrigger()
+	w.LogWrite(newLogRecord(INFO, "source", "before"))
+
+	// A request already pending covers the second one
+	trigger <- struct{}{}
+	select {
+	case trigger <- struct{}{}:
+		t.Errorf("second request queued")
+	default:
+	}
+	w.LogWrite(newLogRecord(INFO, "source", "after"))
+	w.LogWrite(newLogRecord(INFO, "source", "again"))
+	rotated := w.rotatedLogs()
+	w.Close()
+
+	// Sending after Close returns
+	trigger <- struct{}{}
+
+	if len(rotated) != 1 {
+		t.Fatalf("rotated files = %v, want one", rotated)
+	}
+	for name, want := range map[string]string{rotated[0]: "before\n", fname: "after\nagain\n"} {
+		if got, err := ioutil.ReadFile(name); err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v, want %q", filepath.Base(name), got, err, want)
+		}
+	}
+}
+
+func TestFileMaxFlushInterval(t *testing.T) {
+	clock := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var syncs []time.Time
+	defer func(now func() time.Time, sync func(*os.File) error) {
+		timeNow, syncFile = now, sync
+	}(timeNow, syncFile)
+	timeNow = func() time.Time { return clock }
+	syncFile = func(f *os.File) error {
+		syncs = append(syncs, clock)
+		return nil
+	}
+
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A record every 10 seconds, for 5 minutes
+	const interval = time.Minute
+	start := clock
+	w := NewFileLogWriter(filepath.Join(dir, "app.log"), false).SetMaxFlushInterval(interval)
+	for i := 0; i < 30; i++ {
+		clock = clock.Add(10 * time.Second)
+		w.LogWrite(newLogRecord(INFO, "source", "message"))
+	}
+	end := clock
+	w.Close()
+
+	if len(syncs) == 0 {
+		t.Fatalf("file never synced")
+	}
+	last := start
+	for _, sync := range append(syncs, end) {
+		if gap := sync.Sub(last); gap > interval {
+			t.Errorf("%s without sync before %s", gap, sync.Format(time.TimeOnly))
+		}
+		last = sync
+	}
+}
+
+func TestFileDirectSyncBuffered(t *testing.T) {
+	w := NewFileLogWriter(testLogFile, false).SetFlushInterval(time.Hour).SetDirectSync(true)
+	if w == nil {
+		t.Fatalf("Invalid return: w should not be nil")
+	}
+	defer os.Remove(testLogFile)
+	defer w.Close()
+
+	w.LogWrite(newLogRecord(CRITICAL, "source", "message"))
+	if fi, err := os.Stat(testLogFile); err != nil || fi.Size() == 0 {
+		t.Errorf("record buffered despite direct sync: %v", err)
+	}
+}
+
+func TestFileMaxFlushBuffered(t *testing.T) {
+	clock := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	defer func(now func() time.Time, sync func(*os.File) error) {
+		timeNow, syncFile = now, sync
+	}(timeNow, syncFile)
+	timeNow = func() time.Time { return clock }
+	syncFile = func(f *os.File) error { return nil }
+
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The buffer is written out when a sync is due, not on every record
+	fname := filepath.Join(dir, "app.log")
+	w := NewFileLogWriter(fname, false).SetFormat("%M").SetFlushInterval(time.Hour).SetMaxFlushInterval(time.Minute)
+	defer w.Close()
+	w.LogWrite(newLogRecord(INFO, "source", "first"))
+	clock = clock.Add(10 * time.Second)
+	w.LogWrite(newLogRecord(INFO, "source", "second"))
+	if got, _ := ioutil.ReadFile(fname); len(got) != 0 {
+		t.Errorf("written before a sync was due: %q", got)
+	}
+	clock = clock.Add(time.Minute)
+	w.LogWrite(newLogRecord(INFO, "source", "third"))
+	if got, _ := ioutil.ReadFile(fname); string(got) != "first\nsecond\nthird\n" {
+		t.Errorf("written when the sync was due: %q", got)
+	}
+}
+
+func TestFileScheduledSync(t *testing.T) {
+	var synced []*os.File
+	defer func(sync func(*os.File) error) {
+		syncFile = sync
+	}(syncFile)
+	syncFile = func(f *os.File) error {
+		synced = append(synced, f)
+		return nil
+	}
+
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The timer is fired by hand; the sync scheduled for the rotated file
+	// is taken over by the new one
+	w := NewFileLogWriter(filepath.Join(dir, "app.log"), true).SetRotateLines(1).SetMaxFlushInterval(time.Hour)
+	w.LogWrite(newLogRecord(INFO, "source", "first"))
+	w.LogWrite(newLogRecord(INFO, "source", "second"))
+	w.scheduledSync()
+	if len(synced) != 1 || synced[0] != w.file {
+		t.Errorf("synced %v, want the current file %v", synced, w.file)
+	}
+
+	// Nothing is synced once the file is closed
+	synced = nil
+	w.LogWrite(newLogRecord(INFO, "source", "third"))
+	w.Close()
+	w.scheduledSync()
+	if len(synced) != 0 {
+		t.Errorf("synced %v after Close", synced)
+	}
+}
+
+func TestFileMinFreeSpace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "app.log")
+	old := []string{
+		fname + ".2020-01-01.001",
+		fname + ".2020-01-01.002",
+		fname + ".2020-01-02.001",
+	}
+	for _, name := range old {
+		if err := ioutil.WriteFile(name, []byte("old\n"), 0660); err != nil {
+			t.Fatalf("write %s: %s", name, err)
+		}
+	}
+
+	// Files which only share the name of the log are never deleted
+	other := []string{fname + ".pid", fname + ".bak", fname + ".2020-01-03"}
+	for _, name := range other {
+		if err := ioutil.WriteFile(name, []byte("other\n"), 0660); err != nil {
+			t.Fatalf("write %s: %s", name, err)
+		}
+	}
+
+	// Every file takes 300 of the 1800 bytes of the volume
+	defer func(query func(string) (int64, error)) {
+		diskFree = query
+	}(diskFree)
+	diskFree = func(string) (int64, error) {
+		matches, _ := filepath.Glob(fname + ".*")
+		return 1800 - 300*int64(len(matches)), nil
+	}
+
+	w := NewFileLogWriter(fname, false).SetMinFreeSpace(500)
+	w.LogWrite(newLogRecord(INFO, "source", "message"))
+	w.Close()
+
+	for i, name := range old {
+		_, err := os.Stat(name)
+		if exists := err == nil; exists != (i == len(old)-1) {
+			t.Errorf("%s: exists = %v", filepath.Base(name), exists)
+		}
+	}
+	for _, name := range other {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("%s: %s", filepath.Base(name), err)
+		}
+	}
+	if _, err := os.Stat(fname); err != nil {
+		t.Errorf("current log: %s", err)
+	}
+}
+
+func TestTimezone(t *testing.T) {
+	newYork, err1 := time.LoadLocation("America/New_York")
+	tokyo, err2 := time.LoadLocation("Asia/Tokyo")
+	if err1 != nil || err2 != nil {
+		t.Skipf("no timezone database: %v, %v", err1, err2)
+	}
+	rec := newLogRecord(INFO, "source", "message")
+	rec.Created = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for loc, want := range map[*time.Location]string{
+		newYork: "2020/01/01 22:04:05 -0500 message\n",
+		tokyo:   "2020/01/02 12:04:05 +0900 message\n",
+		nil:     "2020/01/02 03:04:05 +0000 message\n",
+	} {
+		w := NewMemoryRotateLogWriter(0, 0).SetFormat("%D %T %Z %M").SetTimezone(loc)
+		w.LogWrite(rec)
+		if got := string(w.Current()); got != want {
+			t.Errorf("in %v: %q, want %q", loc, got, want)
+		}
+	}
+
+	// From a configuration
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	l := make(Logger)
+	l.ConfigToLogWriter("test", &Config{Filters: []kvFilter{{
+		Enabled: "true",
+		Tag:     "file",
+		Level:   "INFO",
+		Type:    "file",
+		Properties: []kvProperty{
+			{Name: "filename", Value: filepath.Join(dir, "app.log")},
+			{Name: "timezone", Value: "Asia/Tokyo"},
+		},
+	}}})
+	defer l.Close()
+	if w, ok := l["file"].LogWriter.(*FileLogWriter); !ok || w.loc.String() != "Asia/Tokyo" {
+		t.Errorf("configured file writer timezone not set")
+	}
+}
+
+func TestFormatter(t *testing.T) {
+	formatter := func(rec *LogRecord) ([]byte, error) {
+		if rec.Level == ERROR {
+			return nil, errors.New("cannot render errors")
+		}
+		return []byte(fmt.Sprintf("%d|%s|%d\n", rec.Level, rec.Message, len(rec.Message))), nil
+	}
+	errs := new(bytes.Buffer)
+	defer func(w io.Writer) {
+		stderr = w
+	}(stderr)
+	stderr = errs
+
+	buf := new(bytes.Buffer)
+	c := NewConsoleLogWriter().SetFormatter(formatter)
+	c.out = buf
+	c.LogWrite(newLogRecord(INFO, "source", "hello"))
+	c.LogWrite(newLogRecord(ERROR, "source", "dropped"))
+	c.SetFormatter(nil).SetFormat("%M")
+	c.LogWrite(newLogRecord(INFO, "source", "plain"))
+	if got, want := buf.String(), "4|hello|5\nplain\n"; got != want {
+		t.Errorf("console output = %q, want %q", got, want)
+	}
+	if got, want := errs.String(), "ConsoleLogWriter: cannot render errors\n"; got != want {
+		t.Errorf("reported %q, want %q", got, want)
+	}
+
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "app.log")
+	w := NewFileLogWriter(fname, false).SetFormatter(formatter)
+	w.LogWrite(newLogRecord(WARNING, "source", "disk"))
+	if err := w.LogWriteErr(newLogRecord(ERROR, "source", "dropped")); err == nil {
+		t.Errorf("LogWriteErr of a record the formatter fails on returned nil")
+	}
+	w.Close()
+	if got, err := ioutil.ReadFile(fname); err != nil || string(got) != "5|disk|4\n" {
+		t.Errorf("file = %q, %v, want %q", got, err, "5|disk|4\n")
+	}
+}
+
+func TestMemoryLogWriter(t *testing.T) {
+	mw := NewMemoryLogWriter().SetFormat("%L %M")
+	l := Logger{"mem": NewSyncFilter(DEBUG, mw)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.Info("goroutine %d", i)
+		}(i)
+	}
+	wg.Wait()
+	if got := len(mw.Records()); got != 4 {
+		t.Errorf("got %d records, want 4", got)
+	}
+
+	mw.Reset()
+	l.Debug("first")
+	l.Warn("second")
+	l.Fine("not accepted")
+	if got, want := mw.String(), "DEBG first\nWARN second\n"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	l.Close()
+	if got := len(mw.Records()); got != 2 {
+		t.Errorf("records after Close = %d, want 2", got)
+	}
+}
+
+func TestMemoryRotateLogWriter(t *testing.T) {
+	// Every line is 10 bytes: "message N\n"
+	w := NewMemoryRotateLogWriter(25, 2).SetFormat("%M")
+	for i := 0; i < 9; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", fmt.Sprintf("message %d", i)))
+	}
+	w.Close()
+
+	// Segments of two lines; the first two were evicted
+	archived := w.Archived()
+	if len(archived) != 2 {
+		t.Fatalf("got %d archived segments, want 2", len(archived))
+	}
+	for i, want := range []string{
+		"message 4\nmessage 5\n",
+		"message 6\nmessage 7\n",
+	} {
+		if got := string(archived[i]); got != want {
+			t.Errorf("archived[%d] = %q, want %q", i, got, want)
+		}
+	}
+	if got, want := string(w.Current()), "message 8\n"; got != want {
+		t.Errorf("current = %q, want %q", got, want)
+	}
+}
+
+func TestSocketStructuredCaller(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer conn.Close()
+
+	l := make(Logger)
+	l.AddFilter("sock", FINEST, NewSocketLogWriter("udp", conn.LocalAddr().String()).SetStructuredCaller(true))
+	l.Info("structured")
+	l.Close()
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+
+	var got struct {
+		Source map[string]interface{}
+	}
+	if err := json.Unmarshal(buf[:n], &got); err != nil {
+		t.Fatalf("unmarshal %q: %s", buf[:n], err)
+	}
+	for _, key := range []string{"file", "line", "func"} {
+		if _, ok := got.Source[key]; !ok {
+			t.Errorf("Source missing %q: %s", key, buf[:n])
+		}
+	}
+	if file, _ := got.Source["file"].(string); filepath.Base(file) != "log4go_test.go" {
+		t.Errorf("Source file = %q, want log4go_test.go", file)
+	}
+	if fn, _ := got.Source["func"].(string); !strings.HasSuffix(fn, "TestSocketStructuredCaller") {
+		t.Errorf("Source func = %q, want TestSocketStructuredCaller", fn)
+	}
+}
+
+func TestSocketCompress(t *testing.T) {
+	defer func(interval time.Duration) {
+		compressFlushInterval = interval
+	}(compressFlushInterval)
+	compressFlushInterval = 10 * time.Millisecond
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer ln.Close()
+
+	w := NewSocketLogWriter("tcp", ln.Addr().String()).SetCompress(true)
+	for i := 0; i < 3; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", fmt.Sprintf("message %d", i)))
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept: %s", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	zr, err := gzip.NewReader(conn)
+	if err != nil {
+		t.Fatalf("gzip reader: %s", err)
+	}
+
+	// The records arrive before the writer is closed
+	dec := json.NewDecoder(zr)
+	for i := 0; i < 3; i++ {
+		var rec LogRecord
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatalf("record %d: %s", i, err)
+		}
+		if want := fmt.Sprintf("message %d", i); rec.Message != want {
+			t.Errorf("record %d = %q, want %q", i, rec.Message, want)
+		}
+	}
+	w.Close()
+	var rec LogRecord
+	if err := dec.Decode(&rec); err != io.EOF {
+		t.Errorf("after Close: %v, want EOF", err)
+	}
+}
+
+func TestHTTPLogWriter(t *testing.T) {
+	defer func(backoff time.Duration) {
+		httpRetryBackoff = backoff
+	}(httpRetryBackoff)
+	httpRetryBackoff = time.Millisecond
+
+	var mu sync.Mutex
+	var posts []string
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if got := req.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		posts = append(posts, string(body))
+	}))
+	defer srv.Close()
+
+	w := NewHTTPLogWriter(srv.URL).SetBatchSize(2).SetFlushInterval(time.Hour).
+		SetHeaders(map[string]string{"Authorization": "Bearer token"})
+	for i := 0; i < 3; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", fmt.Sprintf("message %d", i)))
+	}
+	// The full batch is posted by the sender
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		mu.Lock()
+		n, c := len(posts), calls
+		mu.Unlock()
+		if n == 1 && c == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("before Close: %d posts in %d calls, want the first batch retried once", n, c)
+		}
+	}
+	w.Close()
+	w.LogWrite(newLogRecord(INFO, "source", "after Close"))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(posts) != 2 {
+		t.Fatalf("posts = %q, want 2", posts)
+	}
+	var msgs []string
+	for _, post := range posts {
+		dec := json.NewDecoder(strings.NewReader(post))
+		for {
+			var rec LogRecord
+			if err := dec.Decode(&rec); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("post %q: %s", post, err)
+			}
+			msgs = append(msgs, rec.Message)
+		}
+	}
+	if got, want := strings.Join(msgs, ","), "message 0,message 1,message 2"; got != want {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+
+	l := make(Logger)
+	l.LoadConfigBuf("config.xml", []byte(fmt.Sprintf(`<logging>
+  <filter enabled="true">
+    <tag>collector</tag>
+    <type>http</type>
+    <level>INFO</level>
+    <property name="url">%s</property>
+    <property name="batchsize">7</property>
+  </filter>
+</logging>`, srv.URL)))
+	defer l.Close()
+	if w, ok := l["collector"].LogWriter.(*HTTPLogWriter); !ok || w.batchSize != 7 || w.url != srv.URL {
+		t.Errorf("configured writer = %#v", l["collector"])
+	}
+}
+
+func TestHTTPLogWriterSlowCollector(t *testing.T) {
+	defer func(w io.Writer) {
+		stderr = w
+	}(stderr)
+	stderr = ioutil.Discard
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	posted := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		posted++
+	}))
+	defer srv.Close()
+
+	// The writes must not wait for the stalled collector
+	const records = 4 * httpQueueLen
+	w := NewHTTPLogWriter(srv.URL).SetBatchSize(1)
+	start := time.Now()
+	for i := 0; i < records; i++ {
+		w.LogWrite(newLogRecord(INFO, "source", fmt.Sprintf("message %d", i)))
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("LogWrite took %s with the collector stalled", d)
+	}
+	dropped := w.Dropped()
+	if dropped == 0 {
+		t.Errorf("Dropped = 0 with the queue overflowing")
+	}
+
+	close(release)
+	w.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if uint64(posted)+dropped != records {
+		t.Errorf("%d posted and %d dropped, want %d records", posted, dropped, records)
+	}
+}
+
+// Write a self-signed client certificate and its key as PEM files in dir
+func writeClientCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "log4go client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("certificate: %s", err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatalf("parse certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("marshal key: %s", err)
+	}
+	certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile, cert
+}
+
+func TestHTTPLogWriterTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, clientCert := writeClientCert(t, dir)
+
+	var mu sync.Mutex
+	var msgs, tokens []string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		tokens = append(tokens, req.Header.Get("Authorization"))
+		dec := json.NewDecoder(req.Body)
+		for {
+			var rec LogRecord
+			if err := dec.Decode(&rec); err != nil {
+				break
+			}
+			msgs = append(msgs, rec.Message)
+		}
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	cacert := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(cacert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+
+	l := make(Logger)
+	err = l.LoadConfigReader(strings.NewReader(fmt.Sprintf(`<logging>
+  <filter enabled="true">
+    <tag>collector</tag>
+    <type>http</type>
+    <level>INFO</level>
+    <property name="url">%s</property>
+    <property name="batchsize">1</property>
+    <property name="cacert">%s</property>
+    <property name="cert">%s</property>
+    <property name="key">%s</property>
+    <property name="token">secret</property>
+  </filter>
+</logging>`, srv.URL, cacert, certFile, keyFile)), "xml")
+	if err != nil {
+		t.Fatalf("LoadConfigReader: %s", err)
+	}
+	l.Info("over mTLS")
+	l.Close()
+
+	// Refreshed tokens
+	tlsConfig, err := loadTLSConfig(cacert, certFile, keyFile)
+	if err != nil {
+		t.Fatalf("loadTLSConfig: %s", err)
+	}
+	n := 0
+	w := NewHTTPLogWriter(srv.URL).SetBatchSize(1).SetTLSConfig(tlsConfig).
+		SetTokenFunc(func() (string, error) { n++; return fmt.Sprintf("token%d", n), nil })
+	w.LogWrite(newLogRecord(INFO, "source", "first"))
+	w.LogWrite(newLogRecord(INFO, "source", "second"))
+	w.Close()
+
+	// Without a client certificate the server refuses the post
+	defer func(w io.Writer, backoff time.Duration) {
+		stderr, httpRetryBackoff = w, backoff
+	}(stderr, httpRetryBackoff)
+	stderr, httpRetryBackoff = ioutil.Discard, time.Millisecond
+	tlsConfig, _ = loadTLSConfig(cacert, "", "")
+	w = NewHTTPLogWriter(srv.URL).SetBatchSize(1).SetTLSConfig(tlsConfig)
+	w.LogWrite(newLogRecord(INFO, "source", "anonymous"))
+	w.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got, want := strings.Join(msgs, ","), "over mTLS,first,second"; got != want {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+	if got, want := strings.Join(tokens, ","), "Bearer secret,Bearer token1,Bearer token2"; got != want {
+		t.Errorf("tokens = %q, want %q", got, want)
+	}
+
+	if _, err := loadTLSConfig("", certFile, ""); err == nil {
+		t.Errorf("cert without key accepted")
+	}
+}
+
+func TestSocketOptionsConcurrent(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer conn.Close()
+
+	w := NewSocketLogWriter("udp", conn.LocalAddr().String())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			w.SetOption("structured", i%2 == 0)
+			w.GetOption("endpoint")
+		}
+		w.SetOption("structured", true)
+	}()
+	for i := 0; i < 100; i++ {
+		w.LogWrite(newLogRecord(INFO, "source:1", "message"))
+	}
+	<-done
+
+	// Once set, the option applies to the next record
+	w.LogWrite(newLogRecord(INFO, "source:1", "last"))
+	w.Close()
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("read: %s", err)
+		}
+		var got struct {
+			Message string
+			Source  interface{}
+		}
+		if err := json.Unmarshal(buf[:n], &got); err != nil {
+			t.Fatalf("unmarshal %q: %s", buf[:n], err)
+		}
+		if got.Message != "last" {
+			continue
+		}
+		if _, ok := got.Source.(map[string]interface{}); !ok {
+			t.Errorf("last record not structured: %s", buf[:n])
+		}
+		break
+	}
+}
+
+// Fails on demand and counts the records it was asked to write
+type failingWriter struct {
+	fail  bool
+	calls int
+}
+
+func (w *failingWriter) LogWrite(rec *LogRecord) { w.LogWriteErr(rec) }
+func (w *failingWriter) Close()                  {}
+func (w *failingWriter) LogWriteErr(rec *LogRecord) error {
+	w.calls++
+	if w.fail {
+		return fmt.Errorf("write failed")
+	}
+	return nil
+}
+
+func TestBreakerLogWriter(t *testing.T) {
+	defer func(clock func() time.Time, w io.Writer) {
+		timeNow, stderr = clock, w
+	}(timeNow, stderr)
+	clock := now
+	timeNow = func() time.Time { return clock }
+	errs := new(bytes.Buffer)
+	stderr = errs
+
+	fw := &failingWriter{fail: true}
+	b := NewBreakerLogWriter(fw, 3, time.Minute)
+	rec := newLogRecord(ERROR, "source", "message")
+
+	check := func(step string, state, calls int) {
+		if b.state != state || fw.calls != calls {
+			t.Fatalf("%s: state %d calls %d, want state %d calls %d", step, b.state, fw.calls, state, calls)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		b.LogWrite(rec)
+	}
+	check("after threshold failures", breakerOpen, 3)
+
+	for i := 0; i < 5; i++ {
+		b.LogWrite(rec)
+	}
+	check("during cooldown", breakerOpen, 3)
+
+	clock = clock.Add(time.Minute)
+	b.LogWrite(rec)
+	check("failed probe", breakerOpen, 4)
+
+	clock = clock.Add(30 * time.Second)
+	b.LogWrite(rec)
+	check("cooldown restarted by failed probe", breakerOpen, 4)
+
+	clock = clock.Add(30 * time.Second)
+	fw.fail = false
+	b.LogWrite(rec)
+	check("successful probe", breakerClosed, 5)
+
+	b.LogWrite(rec)
+	check("closed", breakerClosed, 6)
+
+	if !strings.Contains(errs.String(), "3 consecutive failures") || !strings.Contains(errs.String(), "recovered, 6 records were dropped") {
+		t.Errorf("missing state change notices:\n%s", errs)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	sl := NewDefaultLogger(WARNING)
+	if sl == nil {
+		t.Fatalf("NewDefaultLogger should never return nil")
+	}
+	if lw, exist := sl["stdout"]; lw == nil || exist != true {
+		t.Fatalf("NewDefaultLogger produced invalid logger (DNE or nil)")
+	}
+	if sl["stdout"].Level != WARNING {
+		t.Fatalf("NewDefaultLogger produced invalid logger (incorrect level)")
+	}
+	if len(sl) != 1 {
+		t.Fatalf("NewDefaultLogger produced invalid logger (incorrect map count)")
+	}
+
+	//func (l *Logger) AddFilter(name string, level int, writer LogWriter) {}
+	l := make(Logger)
+	l.AddFilter("stdout", DEBUG, NewConsoleLogWriter())
+	if lw, exist := l["stdout"]; lw == nil || exist != true {
+		t.Fatalf("AddFilter produced invalid logger (DNE or nil)")
+	}
+	if l["stdout"].Level != DEBUG {
+		t.Fatalf("AddFilter produced invalid logger (incorrect level)")
+	}
+	if len(l) != 1 {
+		t.Fatalf("AddFilter produced invalid logger (incorrect map count)")
+	}
+
+	//func (l *Logger) Warn(format string, args ...interface{}) error {}
+	if err := l.Warn("%s %d %#v", "Warning:", 1, []int{}); err.Error() != "Warning: 1 []int{}" {
+		t.Errorf("Warn returned invalid error: %s", err)
+	}
+
+	//func (l *Logger) Error(format string, args ...interface{}) error {}
+	if err := l.Error("%s %d %#v", "Error:", 10, []string{}); err.Error() != "Error: 10 []string{}" {
+		t.Errorf("Error returned invalid error: %s", err)
+	}
+
+	//func (l *Logger) Critical(format string, args ...interface{}) error {}
+	if err := l.Critical("%s %d %#v", "Critical:", 100, []int64{}); err.Error() != "Critical: 100 []int64{}" {
+		t.Errorf("Critical returned invalid error: %s", err)
+	}
+	// Already tested or basically untestable
+	//func (l *Logger) Log(level int, source, message string) {}
+	//func (l *Logger) Logf(level int, format string, args ...interface{}) {}
+	//func (l *Logger) intLogf(level int, format string, args ...interface{}) string {}
+	//func (l *Logger) Finest(format string, args ...interface{}) {}
+	//func (l *Logger) Fine(format string, args ...interface{}) {}
+	//func (l *Logger) Debug(format string, args ...interface{}) {}
+	//func (l *Logger) Trace(format string, args ...interface{}) {}
+	//func (l *Logger) Info(format string, args ...interface{}) {}
+}
+
+func TestCloseFileWriters(t *testing.T) {
+	l := make(Logger)
+	l.AddFilter("stdout", CRITICAL, NewConsoleLogWriter())
+	l.AddFilter("file", FINEST, NewFileLogWriter(testLogFile, false).SetFormat("[%L] %M"))
+	l.AddFilter("xml", FINEST, NewXMLLogWriter("_logtest.xml", false))
+	defer os.Remove(testLogFile)
+	defer os.Remove("_logtest.xml")
+	defer l.Close()
+
+	l.Info("written before close")
+	l.CloseFileWriters()
+
+	if len(l) != 1 {
+		t.Fatalf("CloseFileWriters left %d filters, want 1", len(l))
+	}
+	if _, ok := l["stdout"]; !ok {
+		t.Errorf("CloseFileWriters removed the console filter")
+	}
+
+	contents, err := ioutil.ReadFile(testLogFile)
+	if err != nil {
+		t.Fatalf("read(%q): %s", testLogFile, err)
+	}
+	if got, want := string(contents), "[INFO] written before close\n"; got != want {
+		t.Errorf("file contents = %q, want %q", got, want)
+	}
+}
+
+// Keeps every record written
+type recordingWriter struct {
+	mu   sync.Mutex
+	recs []*LogRecord
+}
+
+func (w *recordingWriter) LogWrite(rec *LogRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.recs = append(w.recs, rec)
+}
+
+func (w *recordingWriter) Close() {}
+
+// The records written so far
+func (w *recordingWriter) records() []*LogRecord {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]*LogRecord(nil), w.recs...)
+}
+
+// Blocks every write until released
+type gatedWriter struct {
+	gate chan struct{}
+}
+
+func (w *gatedWriter) LogWrite(rec *LogRecord) { <-w.gate }
+func (w *gatedWriter) Close()                  {}
+
+func TestFilterQueueLen(t *testing.T) {
+	w := &gatedWriter{gate: make(chan struct{})}
+	f := NewFilter(FINEST, w)
+	if f.QueueCap() != DefaultBufferLength {
+		t.Errorf("QueueCap = %d, want %d", f.QueueCap(), DefaultBufferLength)
+	}
+
+	const n = 10
+	for i := 0; i < n; i++ {
+		f.WriteToChan(newLogRecord(INFO, "source", "message"))
+	}
+	// The first record may be held by the writer already
+	if got := f.QueueLen(); got < n-1 {
+		t.Errorf("QueueLen = %d while blocked, want at least %d", got, n-1)
+	}
+
+	close(w.gate)
+	deadline := time.Now().Add(5 * time.Second)
+	for f.QueueLen() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := f.QueueLen(); got != 0 {
+		t.Errorf("QueueLen = %d after release, want 0", got)
+	}
+	f.Close()
+}
+
+// Appends its writes and its close to a shared event log
+type eventWriter struct {
+	name   string
+	mu     *sync.Mutex
+	events *[]string
+}
+
+func (w *eventWriter) event(what string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	*w.events = append(*w.events, what+" "+w.name)
+}
+
+func (w *eventWriter) LogWrite(rec *LogRecord) {
+	time.Sleep(time.Millisecond)
+	w.event("write")
+}
+
+func (w *eventWriter) Close() { w.event("close") }
+
+func TestWriteWhileClosing(t *testing.T) {
+	defer func(w io.Writer) {
+		stderr = w
+	}(stderr)
+	stderr = ioutil.Discard
+
+	for _, newFilter := range []func(LogWriter) *Filter{
+		func(w LogWriter) *Filter { return NewBufferedFilter(INFO, w, 10) },
+		func(w LogWriter) *Filter { return NewSyncFilter(INFO, w) },
+	} {
+		f := newFilter(discardWriter{})
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					f.WriteToChan(newLogRecord(INFO, "source", "message"))
+				}
+			}()
+		}
+		f.Close()
+		wg.Wait()
+		if !f.isClosed() {
+			t.Errorf("filter not closed")
+		}
+	}
+}
+
+func TestCloseOrder(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	l := make(Logger)
+	for _, name := range []string{"first", "second", "third"} {
+		l.AddFilter(name, FINEST, &eventWriter{name: name, mu: &mu, events: &events})
+	}
+	for i := 0; i < 5; i++ {
+		l.Info("message %d", i)
+	}
+	l.Close()
+
+	if len(l) != 0 {
+		t.Errorf("%d filters left after Close", len(l))
+	}
+	if len(events) != 18 {
+		t.Fatalf("got %d events, want 18: %q", len(events), events)
+	}
+	for i, ev := range events[:15] {
+		if !strings.HasPrefix(ev, "write ") {
+			t.Errorf("event %d = %q, want all writes before any close", i, ev)
+		}
+	}
+	want := []string{"close third", "close second", "close first"}
+	for i, ev := range events[15:] {
+		if ev != want[i] {
+			t.Errorf("close %d = %q, want %q", i, ev, want[i])
+		}
+	}
+}
+
+func TestStackDedupLogWriter(t *testing.T) {
+	const stack = "panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\t/app/main.go:12 +0x25"
+	rw := new(recordingWriter)
+	w := NewStackDedupLogWriter(rw, time.Minute)
+
+	for i := 0; i < 10; i++ {
+		w.LogWrite(newLogRecord(ERROR, "recover", stack))
+		w.LogWrite(newLogRecord(INFO, "source", "single line"))
+	}
+	w.Close()
+
+	var full, single int
+	for _, rec := range rw.records() {
+		switch rec.Message {
+		case stack:
+			full++
+		case "single line":
+			single++
+		}
+	}
+	if full != 1 {
+		t.Errorf("stack written %d times, want once", full)
+	}
+	if single != 10 {
+		t.Errorf("single line records written %d times, want 10", single)
+	}
+	recs := rw.records()
+	last := recs[len(recs)-1]
+	if want := "same error ×9: panic: boom"; last.Message != want || last.Level != ERROR {
+		t.Errorf("summary = %v %q, want ERROR %q", last.Level, last.Message, want)
+	}
+
+	// A later window starts over
+	rw = new(recordingWriter)
+	w = NewStackDedupLogWriter(rw, time.Minute)
+	rec := newLogRecord(ERROR, "recover", stack)
+	w.LogWrite(rec)
+	w.LogWrite(rec)
+	later := newLogRecord(ERROR, "recover", stack)
+	later.Created = rec.Created.Add(2 * time.Minute)
+	w.LogWrite(later)
+	w.Close()
+	recs = rw.records()
+	if len(recs) != 3 || recs[1].Message != "same error ×1: panic: boom" || recs[2] != later {
+		t.Errorf("records across windows = %d, want first, summary, next full stack", len(recs))
+	}
+}
+
+func TestDedupWriter(t *testing.T) {
+	mw := NewMemoryLogWriter().SetFormat("%S %M")
+	w := NewDedupWriter(mw, time.Hour)
+	for _, msg := range []string{"a", "a", "a", "b", "b", "a"} {
+		w.LogWrite(newLogRecord(INFO, "source", msg))
+	}
+	// Same message from another source
+	w.LogWrite(newLogRecord(INFO, "other", "a"))
+	w.LogWrite(newLogRecord(INFO, "other", "a"))
+	w.Close()
+
+	want := "source a\nsource last message repeated 2 times\nsource b\nsource last message repeated 1 times\n" +
+		"source a\nother a\nother last message repeated 1 times\n"
+	if got := mw.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	// The summary is written when the window is over, without another record
+	mw = NewMemoryLogWriter().SetFormat("%M")
+	w = NewDedupWriter(mw, 10*time.Millisecond)
+	defer w.Close()
+	w.LogWrite(newLogRecord(INFO, "source", "loop"))
+	w.LogWrite(newLogRecord(INFO, "source", "loop"))
+	deadline := time.Now().Add(5 * time.Second)
+	for mw.String() != "loop\nlast message repeated 1 times\n" {
+		if time.Now().After(deadline) {
+			t.Fatalf("no summary after the window: %q", mw.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	w.LogWrite(newLogRecord(INFO, "source", "loop"))
+	if got, want := mw.String(), "loop\nlast message repeated 1 times\nloop\n"; got != want {
+		t.Errorf("after the window = %q, want %q", got, want)
+	}
+}
+
+func TestSampleWriter(t *testing.T) {
+	mw := NewMemoryLogWriter()
+	w := NewSampleWriter(mw, 10, WARNING)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				w.LogWrite(newLogRecord(DEBUG, "source", "debug"))
+				w.LogWrite(newLogRecord(INFO, "source", "info"))
+				w.LogWrite(newLogRecord(WARNING, "source", "warning"))
+			}
+		}()
+	}
+	wg.Wait()
+	w.Close()
+
+	count := map[Level]int{}
+	for _, rec := range mw.Records() {
+		count[rec.Level]++
+	}
+	// 100 records per level: one of every ten below WARNING, counted per level
+	if count[DEBUG] != 10 || count[INFO] != 10 || count[WARNING] != 100 {
+		t.Errorf("passed records = %v, want 10 DEBUG, 10 INFO, 100 WARNING", count)
+	}
+}
+
+func TestOnceLogWriter(t *testing.T) {
+	rw := new(recordingWriter)
+	w := NewOnceLogWriter(rw)
+	for i := 0; i < 100; i++ {
+		w.LogWrite(newLogRecord(WARNING, "source", "deprecated option"))
+		w.LogWrite(newLogRecord(WARNING, "source", fmt.Sprintf("request %d", i%3)))
+	}
+	w.Close()
+	recs := rw.records()
+	if got := len(recs); got != 4 {
+		t.Fatalf("wrote %d records, want 4", got)
+	}
+	if recs[0].Message != "deprecated option" {
+		t.Errorf("first record = %q, want %q", recs[0].Message, "deprecated option")
+	}
+
+	// A capped writer forgets the least recently seen message
+	rw = new(recordingWriter)
+	w = NewOnceLogWriter(rw).SetMaxEntries(2)
+	for _, msg := range []string{"a", "b", "a", "c", "a", "b"} {
+		w.LogWrite(newLogRecord(INFO, "source", msg))
+	}
+	var got []string
+	for _, rec := range rw.records() {
+		got = append(got, rec.Message)
+	}
+	if want := "a b c b"; strings.Join(got, " ") != want {
+		t.Errorf("capped writer wrote %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
+func TestDrainBuffered(t *testing.T) {
+	gates := []*gatedWriter{
+		{gate: make(chan struct{})},
+		{gate: make(chan struct{})},
+	}
+	l := make(Logger)
+	l.AddFilter("first", FINEST, gates[0])
+	l.AddFilter("second", FINEST, gates[1])
+	for i := 0; i < 5; i++ {
+		l.Info("message %d", i)
+	}
+
+	// Wait for both writers to block on the first record
+	deadline := time.Now().Add(5 * time.Second)
+	for (l["first"].QueueLen() != 4 || l["second"].QueueLen() != 4) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	recs := l.DrainBuffered()
+	if len(recs) != 4 {
+		t.Fatalf("drained %d records, want 4", len(recs))
+	}
+	for i, rec := range recs {
+		if want := fmt.Sprintf("message %d", i+1); rec.Message != want {
+			t.Errorf("record %d = %q, want %q", i, rec.Message, want)
+		}
+	}
+	if n := l["first"].QueueLen() + l["second"].QueueLen(); n != 0 {
+		t.Errorf("%d records left queued", n)
+	}
+
+	for _, w := range gates {
+		close(w.gate)
+	}
+	l.Close()
+}
+
+func TestLogAfterClose(t *testing.T) {
+	defer func(w, fallback io.Writer) {
+		stderr, closedFallback = w, fallback
+	}(stderr, closedFallback)
+	errs, fallback := new(bytes.Buffer), new(bytes.Buffer)
+	stderr, closedFallback = newThrottledWriter(errs), fallback
+
+	l := make(Logger)
+	l.AddFilter("records", FINEST, new(recordingWriter))
+	l.Close()
+
+	l.Info("late")
+	l.Warn("later")
+	l.Log(ERROR, "source", "manual")
+
+	// A burst is not throttled like diagnostics
+	for i := 0; i < 2*DiagnosticsPerSecond; i++ {
+		l.Info("burst %d", i)
+	}
+
+	if n := strings.Count(errs.String(), "logging used after Close"); n != 1 {
+		t.Errorf("notice printed %d times, want once: %q", n, errs)
+	}
+	out := fallback.String()
+	for _, msg := range []string{"late", "later", "manual", fmt.Sprintf("burst %d", 2*DiagnosticsPerSecond-1)} {
+		if !strings.Contains(out, ") "+msg+"\n") {
+			t.Errorf("%q not written to the fallback: %q", msg, out)
+		}
+	}
+	if strings.Contains(errs.String(), "suppressed") {
+		t.Errorf("records counted as diagnostics: %q", errs)
+	}
+
+	// Adding a filter ends the fallback
+	errs.Reset()
+	fallback.Reset()
+	rw := new(recordingWriter)
+	l.AddFilter("records", FINEST, rw)
+	l.Info("reopened")
+	l.Close()
+	if errs.Len() != 0 || fallback.Len() != 0 {
+		t.Errorf("stderr after reopening = %q, %q, want nothing", errs, fallback)
+	}
+	recs := rw.records()
+	if len(recs) != 1 || recs[0].Message != "reopened" {
+		t.Errorf("reopened logger wrote %d records", len(recs))
+	}
+}
+
+func TestFieldsOrder(t *testing.T) {
+	const (
+		wantLine = "fields zeta=3 alpha=a mid=2.5\n"
+		wantJSON = `{"zeta":3,"alpha":"a","mid":2.5}`
+	)
+
+	for i := 0; i < 2; i++ {
+		buf := new(bytes.Buffer)
+		console := NewConsoleLogWriter().SetFormat("%M %K")
+		console.out = buf
+		rw := new(recordingWriter)
+
+		l := make(Logger)
+		l.AddFilter("console", FINEST, console)
+		l.AddFilter("records", FINEST, rw)
+		l.LogWith(INFO, "fields", Field{"zeta", 1}, Field{"alpha", "a"}, Field{"mid", 2.5}, Field{"zeta", 3})
+		l.Close()
+
+		if got := buf.String(); got != wantLine {
+			t.Errorf("run %d: line = %q, want %q", i, got, wantLine)
+		}
+		recs := rw.records()
+		if len(recs) != 1 {
+			t.Fatalf("run %d: got %d records, want 1", i, len(recs))
+		}
+		js, err := json.Marshal(recs[0].Fields)
+		if err != nil {
+			t.Fatalf("run %d: marshal: %s", i, err)
+		}
+		if string(js) != wantJSON {
+			t.Errorf("run %d: json = %s, want %s", i, js, wantJSON)
+		}
+	}
+}
+
+func TestLogFields(t *testing.T) {
+	mw := NewMemoryLogWriter().SetFormat("%M %K")
+	l := Logger{"mem": NewSyncFilter(INFO, mw)}
+	l.LogFields(INFO, "fields", map[string]interface{}{"zeta": 1, "alpha": "a", "mid": 2.5})
+	l.LogFields(INFO, "none", nil)
+	l.LogFields(DEBUG, "skipped", map[string]interface{}{"a": 1})
+	l.Close()
+
+	if got, want := mw.String(), "fields alpha=a mid=2.5 zeta=1\nnone \n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	recs := mw.Records()
+	if js, _ := json.Marshal(recs[1]); bytes.Contains(js, []byte(`"Fields"`)) {
+		t.Errorf("record without fields marshals them: %s", js)
+	}
+	if !strings.HasPrefix(recs[0].Source, "log4go.TestLogFields") {
+		t.Errorf("source = %q, want the caller", recs[0].Source)
+	}
+}
+
+func TestRecordJSON(t *testing.T) {
+	rec := newLogRecord(INFO, "source", "message")
+	rec.Fields = newFields([]Field{{"user", "42"}, {"Message", "shadowed"}, {"n", 1}})
+	js, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatalf("marshal: %s", err)
+	}
+	const want = `{"Level":4,"Created":"2009-02-13T23:31:30.123456789Z","Source":"source","Message":"message","user":"42","fields.Message":"shadowed","n":1}`
+	if string(js) != want {
+		t.Errorf("json = %s, want %s", js, want)
+	}
+
+	var back LogRecord
+	if err := json.Unmarshal(js, &back); err != nil {
+		t.Fatalf("unmarshal: %s", err)
+	}
+	if back.Message != "message" || !back.Created.Equal(rec.Created) || back.Fields.String() != "user=42 Message=shadowed n=1" {
+		t.Errorf("round trip = %+v", back)
+	}
+
+	if js, _ := json.Marshal(newLogRecord(INFO, "source", "plain")); bytes.Contains(js, []byte(`,}`)) || !bytes.HasSuffix(js, []byte(`"Message":"plain"}`)) {
+		t.Errorf("record without fields = %s", js)
+	}
+}
+
+func TestFieldsUnserializable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer ln.Close()
+
+	w := NewSocketLogWriter("tcp", ln.Addr().String())
+	rec := newLogRecord(INFO, "source", "message")
+	rec.Fields = Fields{{Key: "ok", Value: 1}, {Key: "fn", Value: func() {}}, {Key: "ch", Value: make(chan int)}}
+	w.LogWrite(rec)
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept: %s", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var got map[string]interface{}
+	if err := json.NewDecoder(conn).Decode(&got); err != nil {
+		t.Fatalf("record lost: %s", err)
+	}
+	w.Close()
+
+	if got["Message"] != "message" {
+		t.Errorf("message = %q, want %q", got["Message"], "message")
+	}
+	for key, want := range map[string]interface{}{
+		"ok": 1.0,
+		"fn": "<unserializable: func()>",
+		"ch": "<unserializable: chan int>",
+	} {
+		if got[key] != want {
+			t.Errorf("field %s = %v, want %v", key, got[key], want)
+		}
+	}
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	for lvl, want := range map[Level]int{
+		FINEST:   7,
+		FINE:     7,
+		DEBUG:    7,
+		TRACE:    7,
+		INFO:     6,
+		WARNING:  4,
+		ERROR:    3,
+		CRITICAL: 2,
+	} {
+		if got := lvl.ToSyslogSeverity(); got != want {
+			t.Errorf("%v.ToSyslogSeverity() = %d, want %d", lvl, got, want)
+		}
+	}
+
+	// Round trips keep INFO and above; the debug levels all become DEBUG
+	for lvl := FINEST; lvl <= CRITICAL; lvl++ {
+		want := lvl
+		if lvl < INFO {
+			want = DEBUG
+		}
+		if got := LevelFromSyslogSeverity(lvl.ToSyslogSeverity()); got != want {
+			t.Errorf("%v round trips to %v, want %v", lvl, got, want)
+		}
+	}
+	for severity, want := range map[int]Level{-1: CRITICAL, 0: CRITICAL, 1: CRITICAL, 5: INFO, 8: DEBUG} {
+		if got := LevelFromSyslogSeverity(severity); got != want {
+			t.Errorf("LevelFromSyslogSeverity(%d) = %v, want %v", severity, got, want)
+		}
+	}
+}
+
+// A wrapper around Info, as an application would write it
+func wrappedInfo(l Logger, msg string) {
+	l.Info(msg)
+}
+
+func TestConcurrentDispatch(t *testing.T) {
+	gw := &gatedWriter{gate: make(chan struct{})}
+	mw := NewMemoryLogWriter()
+	l := Logger{
+		"gated": NewSyncFilter(INFO, gw),
+		"mem":   NewSyncFilter(INFO, mw),
+	}
+	l.SetConcurrentDispatch(true)
+
+	done := make(chan struct{})
+	go func() {
+		l.Log(INFO, "source", "message")
+		close(done)
+	}()
+
+	// The memory filter is written while the gated one blocks
+	deadline := time.Now().Add(5 * time.Second)
+	for len(mw.Records()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("memory filter waits for the blocked filter")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Errorf("Log returned before every filter was written")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(gw.gate)
+	<-done
+
+	// Levels are still checked per filter
+	l.SetLevel("mem", ERROR)
+	l.Log(WARNING, "source", "dropped")
+	l.Close()
+	if n := len(mw.Records()); n != 1 {
+		t.Errorf("memory filter holds %d records, want 1", n)
+	}
+}
+
+func TestLoggerStats(t *testing.T) {
+	l := Logger{"mem": NewSyncFilter(DEBUG, NewMemoryLogWriter())}
+	defer l.Close()
+	var hooked []Level
+	l.SetStatsHook(func(lvl Level) { hooked = append(hooked, lvl) })
+
+	l.Info("one")
+	l.Info("two")
+	l.Error("three")
+	l.Fine("skipped")
+	l.Log(CRITICAL+3, "source", "registered")
+
+	stats := l.Stats()
+	for lvl, want := range map[Level]uint64{INFO: 2, ERROR: 1, FINE: 0, CRITICAL + 3: 1} {
+		if got, ok := stats[lvl]; !ok || got != want {
+			t.Errorf("Stats()[%d] = %d, %v, want %d", lvl, got, ok, want)
+		}
+	}
+	if len(hooked) != 4 || hooked[2] != ERROR {
+		t.Errorf("hook called with %v", hooked)
+	}
+
+	l.ResetStats()
+	if n := l.Stats()[INFO]; n != 0 {
+		t.Errorf("after ResetStats: INFO = %d", n)
+	}
+	if n := (Logger{}).Stats()[INFO]; n != 0 {
+		t.Errorf("new logger: INFO = %d", n)
+	}
+
+	l.SetStatsHook(nil)
+	st := l.state()
+	if allocs := testing.AllocsPerRun(100, func() { st.count(INFO) }); allocs != 0 {
+		t.Errorf("counting allocates %v times", allocs)
+	}
+}
+
+func TestLoggerWithoutState(t *testing.T) {
+	mw := NewMemoryLogWriter()
+	l := Logger{"mem": NewSyncFilter(INFO, mw)}
+	l.Info("logged")
+	l.Clone().Info("cloned")
+	if l.findState() != nil {
+		t.Errorf("logging made a state for the logger")
+	}
+	if n := len(mw.Records()); n != 2 {
+		t.Errorf("memory filter holds %d records, want 2", n)
+	}
+
+	// Counting starts with Stats
+	if n := l.Stats()[INFO]; n != 0 {
+		t.Errorf("Stats before counting: INFO = %d", n)
+	}
+	l.Info("counted")
+	if n := l.Stats()[INFO]; n != 1 {
+		t.Errorf("Stats: INFO = %d, want 1", n)
+	}
+}
+
+func TestLoggerClone(t *testing.T) {
+	mem := NewMemoryLogWriter()
+	l := Logger{"mem": NewFilter(INFO, mem)}
+	clone := l.Clone()
+
+	if err := clone.SetLevel("mem", ERROR); err != nil {
+		t.Fatalf("SetLevel: %s", err)
+	}
+	if lvl := l["mem"].level(); lvl != INFO {
+		t.Errorf("original level = %v, want INFO", lvl)
+	}
+
+	l.Info("original")
+	clone.Info("dropped")
+	clone.Error("clone")
+	l.Flush()
+	clone.Flush()
+	if s := mem.String(); !strings.Contains(s, "original") || !strings.Contains(s, "clone") || strings.Contains(s, "dropped") {
+		t.Errorf("shared writer got %q", s)
+	}
+	if clone["mem"].QueueCap() != DefaultBufferLength {
+		t.Errorf("clone QueueCap = %d", clone["mem"].QueueCap())
+	}
+
+	// Closing the clone leaves the writer to the original
+	clone.Close()
+	l.Warn("still open")
+	l.Close()
+	if n := len(mem.Records()); n != 3 {
+		t.Errorf("got %d records, want 3", n)
+	}
+}
+
+func TestSetCallerSkip(t *testing.T) {
+	direct, wrapped := NewMemoryLogWriter(), NewMemoryLogWriter()
+	ld := Logger{"mem": NewSyncFilter(INFO, direct)}
+	lw := Logger{"mem": NewSyncFilter(INFO, wrapped)}
+	lw.SetCallerSkip(DefaultCallerSkip + 1)
+
+	ld.Info("direct")
+	wrappedInfo(lw, "wrapped")
+	wrappedInfo(ld, "unskipped")
+	ld.Close()
+	lw.Close()
+
+	recs := append(direct.Records(), wrapped.Records()...)
+	for _, want := range []struct{ msg, fn string }{
+		{"direct", "log4go.TestSetCallerSkip"},
+		{"unskipped", "log4go.wrappedInfo"},
+		{"wrapped", "log4go.TestSetCallerSkip"},
+	} {
+		found := false
+		for _, rec := range recs {
+			if rec.Message == want.msg {
+				found = true
+				if !strings.HasPrefix(rec.Source, want.fn+":") {
+					t.Errorf("%s: source = %q, want %s", want.msg, rec.Source, want.fn)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s: not logged", want.msg)
+		}
+	}
+}
+
+func TestMessageParser(t *testing.T) {
+	mw := NewMemoryLogWriter().SetFormat("%M|%K")
+	l := Logger{"mem": NewSyncFilter(INFO, mw)}
+	l.SetMessageParser(ParseKeyValues)
+	l.Info("login user=%d action=login", 42)
+	l.LogWith(INFO, "retry user=7", Field{Key: "user", Value: 8})
+	l.Info("no pairs = here")
+	l.SetMessageParser(nil)
+	l.Info("unparsed user=1")
+	l.Close()
+
+	want := "login user=42 action=login|user=42 action=login\n" +
+		"retry user=7|user=8\n" +
+		"no pairs = here|\n" +
+		"unparsed user=1|\n"
+	if got := mw.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	recs := mw.Records()
+	js, err := json.Marshal(recs[0])
+	if err != nil {
+		t.Fatalf("marshal: %s", err)
+	}
+	if !bytes.Contains(js, []byte(`,"user":"42","action":"login"}`)) ||
+		!bytes.Contains(js, []byte(`"Message":"login user=42 action=login"`)) {
+		t.Errorf("json = %s", js)
+	}
+	if got := FormatLogRecord("%O", recs[0]); !strings.Contains(got, ` msg="login" user=42 action=login`) {
+		t.Errorf("logfmt = %q", got)
+	}
+}
+
+func TestPerSourceRate(t *testing.T) {
+	defer func(clock func() time.Time) {
+		timeNow = clock
+	}(timeNow)
+	clock := now
+	timeNow = func() time.Time { return clock }
+
+	mw := NewMemoryLogWriter().SetFormat("%L %S %M")
+	l := Logger{"mem": NewSyncFilter(INFO, mw)}
+	l.SetPerSourceRate(2)
+	for i := 0; i < 5; i++ {
+		l.Log(INFO, "noisy", fmt.Sprintf("noise %d", i))
+		if i%4 == 0 {
+			l.Log(INFO, "quiet", fmt.Sprintf("note %d", i))
+		}
+	}
+	clock = clock.Add(time.Second)
+	l.Log(INFO, "noisy", "noise again")
+	l.Close()
+
+	want := "INFO noisy noise 0\n" +
+		"INFO quiet note 0\n" +
+		"INFO noisy noise 1\n" +
+		"INFO quiet note 4\n" +
+		"WARN noisy source noisy dropped 3 records\n" +
+		"INFO noisy noise again\n"
+	if got := mw.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestSourceLimiterLRU(t *testing.T) {
+	l := newSourceLimiter(1)
+	for i := 0; i < maxRateSources+10; i++ {
+		l.allow(strconv.Itoa(i), now)
+	}
+	if len(l.buckets) != maxRateSources || l.lru.Len() != maxRateSources {
+		t.Errorf("tracking %d/%d sources, want %d", len(l.buckets), l.lru.Len(), maxRateSources)
+	}
+	// The oldest source was forgotten and starts with a full bucket
+	if ok, _ := l.allow("0", now); !ok {
+		t.Errorf("forgotten source is limited")
+	}
+	if ok, _ := l.allow(strconv.Itoa(maxRateSources+9), now); ok {
+		t.Errorf("recent source is not limited")
+	}
+}
+
+func TestCEFFormatter(t *testing.T) {
+	rec := newLogRecord(WARNING, "auth|login", "failed login for a|b")
+	rec.Fields = Fields{
+		{Key: "suser", Value: "kyle"},
+		{Key: "msg", Value: `a=b\c` + "\nnext"},
+		{Key: "src-ip", Value: "10.0.0.1"},
+	}
+	out, err := NewCEFFormatter("Acme", "Shop|Web", "1.0")(rec)
+	if err != nil {
+		t.Fatalf("format: %s", err)
+	}
+	want := `CEF:0|Acme|Shop\|Web|1.0|auth\|login|failed login for a\|b|6|rt=` +
+		strconv.FormatInt(now.UnixNano()/1e6, 10) +
+		` suser=kyle msg=a\=b\\c\nnext srcip=10.0.0.1` + "\n"
+	if string(out) != want {
+		t.Errorf("CEF line\n got %q\nwant %q", out, want)
+	}
+
+	if out, _ := NewCEFFormatter("v", "p", "1")(newLogRecord(CRITICAL, "s", "m")); !bytes.Contains(out, []byte("|m|10|")) {
+		t.Errorf("CRITICAL line = %q, want severity 10", out)
+	}
+}
+
+func TestLoggerWriter(t *testing.T) {
+	mw := NewMemoryLogWriter().SetFormat("%L %S %M")
+	l := Logger{"mem": NewSyncFilter(INFO, mw)}
+	w := l.Writer(ERROR, "http")
+
+	io.WriteString(w, "first line\nsecond ")
+	io.WriteString(w, "half\r\n\nthird")
+	if got, want := mw.String(), "EROR http first line\nEROR http second half\nEROR http \n"; got != want {
+		t.Errorf("before Close = %q, want %q", got, want)
+	}
+	w.Close()
+	if got := mw.Records(); len(got) != 4 || got[3].Message != "third" {
+		t.Errorf("partial line not logged on Close: %d records", len(got))
+	}
+
+	// Through the standard library logger
+	std := log.New(l.Writer(WARNING, "std"), "", 0)
+	std.Printf("from %s", "log")
+	if got := mw.Records(); got[len(got)-1].Message != "from log" || got[len(got)-1].Level != WARNING {
+		t.Errorf("log.Logger record = %+v", got[len(got)-1])
+	}
+	l.Close()
+}
+
+func TestLogfSource(t *testing.T) {
+	mw := NewMemoryLogWriter().SetFormat("%S %M")
+	l := Logger{"mem": NewSyncFilter(INFO, mw)}
+	l.LogfSource(INFO, "lua", "script %s: %d", "init", 3)
+	l.LogfSource(DEBUG, "lua", "skipped")
+	l.Close()
+
+	if got, want := mw.String(), "lua script init: 3\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestRegisterFormatFunc(t *testing.T) {
+	defer formatFuncs.Store(map[byte]func(*LogRecord) string(nil))
+
+	if err := RegisterFormatFunc('h', func(*LogRecord) string { return "host1" }); err != nil {
+		t.Fatalf("RegisterFormatFunc(h): %s", err)
+	}
+	if err := RegisterFormatFunc('V', func(rec *LogRecord) string { return "v1.2/" + rec.Source }); err != nil {
+		t.Fatalf("RegisterFormatFunc(V): %s", err)
+	}
+	rec := newLogRecord(INFO, "source", "message")
+	if got, want := FormatLogRecord("%h %V [%L] %M %Q", rec), "host1 v1.2/source [INFO] message \n"; got != want {
+		t.Errorf("custom verbs = %q, want %q", got, want)
+	}
+	if got, want := strings.Join(unknownFormatVerbs("%h %V %Q"), " "), "%Q"; got != want {
+		t.Errorf("unknown verbs = %q, want %q", got, want)
+	}
+
+	for _, verb := range []rune{'M', 'L', 'g', '%', 'é', 0} {
+		if err := RegisterFormatFunc(verb, func(*LogRecord) string { return "x" }); err == nil {
+			t.Errorf("RegisterFormatFunc(%q) succeeded", verb)
+		}
+	}
+	if got, want := FormatLogRecord("%M", rec), "message\n"; got != want {
+		t.Errorf("built-in verb = %q, want %q", got, want)
+	}
+}
+
+func TestFullPathSource(t *testing.T) {
+	mw := NewMemoryLogWriter().SetFormat("%F|%s")
+	l := Logger{"mem": NewSyncFilter(INFO, mw)}
+	l.Info("captured")
+	_, file, line, _ := runtime.Caller(0)
+	l.Log(INFO, "manual:7", "given")
+	l.Close()
+
+	lines := strings.Split(mw.String(), "\n")
+	if want := fmt.Sprintf("%s:%d|log4go.TestFullPathSource:%d", file, line-1, line-1); lines[0] != want {
+		t.Errorf("captured source = %q, want %q", lines[0], want)
+	}
+	if want := "manual:7|manual:7"; lines[1] != want {
+		t.Errorf("manual source = %q, want %q", lines[1], want)
+	}
+}
+
+func TestProcessHostFormat(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no host name: %s", err)
+	}
+	rec := &LogRecord{Level: INFO, Created: now, Message: "msg"}
+	want := fmt.Sprintf("%d %s msg\n", os.Getpid(), host)
+	if got := FormatLogRecord("%P %H %M", rec); got != want {
+		t.Errorf("FormatLogRecord = %q, want %q", got, want)
+	}
+	if err := RegisterFormatFunc('P', func(*LogRecord) string { return "" }); err == nil {
+		t.Errorf("RegisterFormatFunc('P') succeeded")
+	}
+}
+
+func TestGoroutineToken(t *testing.T) {
+	mw := NewMemoryLogWriter().SetFormat("%g %M")
+	l := Logger{"mem": NewSyncFilter(INFO, mw)}
+
+	ids := make(chan uint64, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids <- goroutineID()
+			l.Info("from %d", i)
+		}(i)
+	}
+	wg.Wait()
+	l.Close()
+	close(ids)
+
+	var want []string
+	for id := range ids {
+		if id == 0 {
+			t.Fatalf("goroutine id not found")
+		}
+		want = append(want, strconv.FormatUint(id, 10))
+	}
+	for _, rec := range mw.Records() {
+		got := strings.Fields(FormatLogRecord("%g", rec))[0]
+		if got != want[0] && got != want[1] || got == "?" {
+			t.Errorf("%s: goroutine %s, want one of %v", rec.Message, got, want)
+		}
+	}
+	if got := FormatLogRecord("%g", newLogRecord(INFO, "source", "message")); got != "?\n" {
+		t.Errorf("record without id = %q", got)
+	}
+}
+
+func TestElapsedToken(t *testing.T) {
+	defer func(clock func() time.Time) {
+		timeNow = clock
+	}(timeNow)
+	clock := now
+	timeNow = func() time.Time { return clock }
+
+	buf := new(bytes.Buffer)
+	console := NewConsoleLogWriter().SetFormat("%+ %M")
+	console.out = buf
+	// The time is since the previous record of the writer, which skips the
+	// records below its level
+	warnings := NewMemoryLogWriter().SetFormat("%+ %M")
+
+	l := make(Logger)
+	l.AddFilter("console", FINEST, console)
+	l.AddFilter("warnings", WARNING, warnings)
+	l.Log(WARNING, "source", "first")
+	clock = clock.Add(12300 * time.Microsecond)
+	l.Info("second")
+	clock = clock.Add(2 * time.Second)
+	l.Log(WARNING, "source", "third")
+	l.Close()
+
+	want := "+0s first\n+12.3ms second\n+2s third\n"
+	if got := buf.String(); got != want {
+		t.Errorf("elapsed output = %q, want %q", got, want)
+	}
+	if got, want := warnings.String(), "+0s first\n+2.0123s third\n"; got != want {
+		t.Errorf("elapsed output of the WARNING writer = %q, want %q", got, want)
+	}
+	if got := FormatLogRecord("%+ %M", newLogRecord(INFO, "source", "alone")); got != "+0s alone\n" {
+		t.Errorf("FormatLogRecord = %q", got)
+	}
+}
+
+func TestContextFields(t *testing.T) {
+	mw := NewMemoryLogWriter().SetFormat("%c|%K|%A")
+	l := make(Logger)
+	l.AddFilter("mem", INFO, mw)
+
+	l.SetContext(Field{"component", "db"}, Field{"tenant", "a"})
+	l.LogWith(INFO, "merged", Field{"tenant", "b"}, Field{"req", 1})
+	l.Info("context only")
+	l.SetContext()
+	l.LogWith(INFO, "record only", Field{"req", 2})
+	l.Close()
+
+	want := "component=db tenant=a|tenant=b req=1|component=db tenant=b req=1\n" +
+		"component=db tenant=a||component=db tenant=a\n" +
+		"|req=2|req=2\n"
+	if got := mw.String(); got != want {
+		t.Errorf("rendered %q, want %q", got, want)
+	}
+
+	// JSON merges like %A
+	js, err := json.Marshal(mw.Records()[0])
+	if err != nil || !strings.Contains(string(js), `,"component":"db","tenant":"b","req":1}`) {
+		t.Errorf("JSON = %s, %v, want the merged fields", js, err)
+	}
+
+	rec := newLogRecord(INFO, "source", "message")
+	rec.Fields = newFields([]Field{{"k", "v"}})
+	if got, want := FormatLogRecord("%c|%K|%A", rec), "|k=v|k=v\n"; got != want {
+		t.Errorf("record without a logger = %q, want %q", got, want)
+	}
+}
+
+func TestFieldsProvider(t *testing.T) {
+	rw := new(recordingWriter)
+	l := make(Logger)
+	l.AddFilter("rec", INFO, rw)
+
+	calls := 0
+	l.SetFieldsProvider(func() []Field {
+		calls++
+		return []Field{{"requests", calls}, {"role", "leader"}}
+	})
+	for i := 0; i < 3; i++ {
+		l.Info("served")
+	}
+	l.Debug("not written")
+	l.LogWith(INFO, "override", Field{"role", "follower"}, Field{"id", 7})
+	l.SetFieldsProvider(nil)
+	l.Info("plain")
+	l.Close()
+
+	want := []string{
+		"requests=1 role=leader",
+		"requests=2 role=leader",
+		"requests=3 role=leader",
+		"requests=4 role=follower id=7",
+		"",
+	}
+	recs := rw.records()
+	if len(recs) != len(want) {
+		t.Fatalf("wrote %d records, want %d", len(recs), len(want))
+	}
+	for i, rec := range recs {
+		if got := rec.Fields.String(); got != want[i] {
+			t.Errorf("record %d fields = %q, want %q", i, got, want[i])
+		}
+	}
+	if calls != 4 {
+		t.Errorf("provider called %d times, want 4", calls)
+	}
+}
+
+func TestFatalClosesWriters(t *testing.T) {
+	if fname := os.Getenv("LOG4GO_TEST_FATAL"); fname != "" {
+		// In the subprocess: a file filter and a filter stuck forever
+		ExitCloseTimeout = 500 * time.Millisecond
+		Global = Logger{
+			"file":  NewFilter(INFO, NewFileLogWriter(fname, false).SetFormat("%L %M")),
+			"stuck": NewFilter(INFO, &gatedWriter{gate: make(chan struct{})}),
+		}
+		Info("starting")
+		Fatal("fatal line")
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "fatal.log")
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFatalClosesWriters$")
+	cmd.Env = append(os.Environ(), "LOG4GO_TEST_FATAL="+fname)
+	start := time.Now()
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("subprocess: %s\n%s", err, out)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("subprocess took %s to exit", elapsed)
+	}
+	got, err := ioutil.ReadFile(fname)
+	if err != nil || !strings.Contains(string(got), "EROR fatal line\n") {
+		t.Errorf("log file = %q, %v, want the fatal line", got, err)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	rw := new(recordingWriter)
+	l := make(Logger)
+	l.AddFilter("rec", INFO, rw)
+
+	l.Info("before")
+	mw := l.StartCapture()
+	l.Info("during %d", 1)
+	l.Debug("not accepted")
+	l.Warn("during %d", 2)
+	l.StopCapture(mw)
+	l.Info("after")
+	l.Close()
+
+	var got []string
+	for _, rec := range mw.Records() {
+		got = append(got, rec.Message)
+	}
+	if want := "during 1,during 2"; strings.Join(got, ",") != want {
+		t.Errorf("captured %q, want %q", strings.Join(got, ","), want)
+	}
+	recs := rw.records()
+	if len(recs) != 4 {
+		t.Errorf("filter wrote %d records, want 4", len(recs))
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	rw := &recordingWriter{}
+	filt := NewSyncFilter(INFO, rw)
+	l := Logger{"rec": filt}
+
+	if err := l.SetLevel("missing", DEBUG); err != ErrUnknownTag {
+		t.Errorf("SetLevel of an unknown tag = %v, want %v", err, ErrUnknownTag)
+	}
+
+	// Levels change while other goroutines log
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				l.Trace("busy")
+			}
+		}()
+	}
+	for _, lvl := range []Level{DEBUG, ERROR, WARNING} {
+		if err := l.SetLevel("rec", lvl); err != nil {
+			t.Errorf("SetLevel(%v): %s", lvl, err)
+		}
+	}
+	wg.Wait()
+
+	l.Info("dropped")
+	l.Warn("kept")
+	l.Close()
+	recs := rw.records()
+	if l := len(recs); l == 0 || recs[l-1].Message != "kept" || filt.Level != WARNING {
+		t.Errorf("records after SetLevel(WARNING) not filtered")
+	}
+	for _, rec := range recs {
+		if rec.Message == "dropped" {
+			t.Errorf("INFO record written at WARNING")
+		}
+	}
+}
+
+func TestBufferUntilConfigured(t *testing.T) {
+	l := make(Logger)
+	l.BufferUntilConfigured(2)
+	l.Info("early info")
+	l.Debug("early debug")
+	l.Info("over the limit")
+
+	rw := new(recordingWriter)
+	l.AddFilter("records", INFO, rw)
+	l.Info("after")
+	l.Close()
+
+	var got []string
+	for _, rec := range rw.records() {
+		got = append(got, rec.Message)
+	}
+	if want := []string{"early info", "after"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+}
+
+func TestLogBytes(t *testing.T) {
+	defer func(enc int) {
+		BinaryEncoding = enc
+	}(BinaryEncoding)
+	data := []byte{0xff, 0x00, 'a', '\n'}
+
+	for enc, want := range map[int]string{
+		BinaryHex:     "ff00610a",
+		BinaryBase64:  "/wBhCg==",
+		BinaryEscaped: `"\xff\x00a\n"`,
+	} {
+		BinaryEncoding = enc
+
+		buf := new(bytes.Buffer)
+		console := NewConsoleLogWriter().SetFormat("%M")
+		console.out = buf
+		rw := new(recordingWriter)
+
+		l := make(Logger)
+		l.AddFilter("console", FINEST, console)
+		l.AddFilter("records", FINEST, rw)
+		l.LogBytes(INFO, "packet", data)
+		l.Close()
+
+		if got := buf.String(); got != want+"\n" {
+			t.Errorf("encoding %d: message = %q, want %q", enc, got, want)
+		}
+		js, err := json.Marshal(rw.records()[0])
+		if err != nil {
+			t.Fatalf("marshal: %s", err)
+		}
+		if !bytes.Contains(js, []byte(`"Binary":"/wBhCg=="`)) {
+			t.Errorf("encoding %d: json missing base64 data: %s", enc, js)
+		}
+	}
+}
+
+func TestOutLogWriterMatchesStdlib(t *testing.T) {
+	digits := regexp.MustCompile("[0-9]")
+	for _, flag := range []int{
+		0,
+		log.LstdFlags,
+		log.Ldate | log.Lmicroseconds | log.LUTC,
+		log.Ltime | log.Lshortfile,
+		log.LstdFlags | log.Llongfile,
+		log.Lshortfile | log.Lmsgprefix,
+	} {
+		want := new(bytes.Buffer)
+		std := log.New(want, "pfx: ", flag)
+		std.Print("This is a log message")
+
+		got := new(bytes.Buffer)
+		l := New(got, "pfx: ", flag)
+		l.Info("This is a log message")
+		l.Close()
+
+		if g, w := digits.ReplaceAllString(got.String(), "0"), digits.ReplaceAllString(want.String(), "0"); g != w {
+			t.Errorf("flag %#x: got %q, want %q", flag, got, want)
+		}
+	}
+
+	got := new(bytes.Buffer)
+	l := New(got, "", 0)
+	l["out"].LogWriter.(*OutLogWriter).SetPrefix("[app] ").SetFlags(log.Lmsgprefix)
+	l.Info("changed")
+	l.Close()
+	if want := "[app] changed\n"; got.String() != want {
+		t.Errorf("after SetPrefix/SetFlags: got %q, want %q", got, want)
+	}
+}
+
+func TestOutLogWriterLevelPrefix(t *testing.T) {
+	got := new(bytes.Buffer)
+	l := New(got, "app: ", 0)
+	l["out"].LogWriter.(*OutLogWriter).SetLevelPrefix(ERROR, "app ERROR: ")
+	l.Info("started")
+	l.Error("failed")
+	l.Warn("slow")
+	l.Close()
+
+	if want := "app: started\napp ERROR: failed\napp: slow\n"; got.String() != want {
+		t.Errorf("got %q, want %q", got, want)
 	}
-	// Already tested or basically untestable
-	//func (l *Logger) Log(level int, source, message string) {}
-	//func (l *Logger) Logf(level int, format string, args ...interface{}) {}
-	//func (l *Logger) intLogf(level int, format string, args ...interface{}) string {}
-	//func (l *Logger) Finest(format string, args ...interface{}) {}
-	//func (l *Logger) Fine(format string, args ...interface{}) {}
-	//func (l *Logger) Debug(format string, args ...interface{}) {}
-	//func (l *Logger) Trace(format string, args ...interface{}) {}
-	//func (l *Logger) Info(format string, args ...interface{}) {}
 }
 
 func TestLogOutput(t *testing.T) {
 	const (
 		expected = "fdf3e51e444da56b4cb400f30bc47424"
 	)
+	if fineCompiledOut {
+		t.Skip("Finest and Fine are compiled out")
+	}
 
 	// Unbuffered output
 	defer func(buflen int) {
@@ -424,6 +3550,681 @@ func TestXMLConfig(t *testing.T) {
 	os.Rename(configfile, "examples/"+configfile) // Keep this so that an example with the documentation is available
 }
 
+func TestSocketGivesUpOnUnknownHost(t *testing.T) {
+	defer func(dial func(string, string) (net.Conn, error), w io.Writer) {
+		netDial, stderr = dial, w
+	}(netDial, stderr)
+	errs := new(bytes.Buffer)
+	stderr = errs
+
+	dials := 0
+	netDial = func(proto, hostport string) (net.Conn, error) {
+		dials++
+		return nil, &net.OpError{Op: "dial", Net: proto, Err: &net.DNSError{Err: "no such host", Name: hostport, IsNotFound: true}}
+	}
+
+	w := NewSocketLogWriter("udp", "log4go-test.invalid:12124")
+	for i := 0; i < 100; i++ {
+		w.LogWrite(newLogRecord(ERROR, "source", "message"))
+	}
+	if dials != maxDialFailures {
+		t.Errorf("dialed %d times, want %d", dials, maxDialFailures)
+	}
+	if lines := strings.Count(errs.String(), "\n"); lines != maxDialFailures {
+		t.Errorf("reported %d errors, want %d:\n%s", lines, maxDialFailures, errs)
+	}
+	if !strings.Contains(errs.String(), "giving up") {
+		t.Errorf("missing giving up notice:\n%s", errs)
+	}
+
+	// A new endpoint starts over
+	if err := w.SetOption("endpoint", "log4go-test2.invalid:12124"); err != nil {
+		t.Fatalf("SetOption: %s", err)
+	}
+	w.LogWrite(newLogRecord(ERROR, "source", "message"))
+	if dials != maxDialFailures+1 {
+		t.Errorf("dialed %d times after SetOption, want %d", dials, maxDialFailures+1)
+	}
+	if err := w.SetOption("bogus", 1); err != ErrBadOption {
+		t.Errorf("SetOption(bogus) = %v, want ErrBadOption", err)
+	}
+}
+
+func TestSocketStrictConfig(t *testing.T) {
+	// Find a port nobody listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	endpoint := ln.Addr().String()
+	ln.Close()
+
+	load := func(strictAttr, strictProp string) error {
+		cfg := `<logging` + strictAttr + `>
+  <filter enabled="true">
+    <tag>sock</tag>
+    <type>socket</type>
+    <level>INFO</level>
+    <property name="endpoint">` + endpoint + `</property>
+    <property name="protocol">tcp</property>` + strictProp + `
+  </filter>
+</logging>`
+		l := make(Logger)
+		defer l.Close()
+		return l.LoadConfigReader(strings.NewReader(cfg), "xml")
+	}
+
+	if err := load("", ""); err != nil {
+		t.Errorf("lazy socket filter should load without a listener: %s", err)
+	}
+	if err := load(` strict="true"`, ""); err == nil {
+		t.Errorf("strict load should fail for dead endpoint %s", endpoint)
+	}
+	if err := load("", `
+    <property name="strict">true</property>`); err == nil {
+		t.Errorf("strict socket filter should fail to load for dead endpoint %s", endpoint)
+	}
+
+	// Strict for one load only
+	if err := load("", ""); err != nil {
+		t.Errorf("strictness leaked into a later load: %s", err)
+	}
+}
+
+// Takes a while for every write
+type slowWriter struct {
+	delay time.Duration
+	mu    sync.Mutex
+	n     int
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.n++
+	return len(p), nil
+}
+
+func TestFilterAsyncConfig(t *testing.T) {
+	defer func(out io.Writer) {
+		stdout = out
+	}(stdout)
+	const delay = 5 * time.Millisecond
+
+	for _, async := range []string{"true", "false"} {
+		slow := &slowWriter{delay: delay}
+		stdout = slow
+
+		l := make(Logger)
+		l.ConfigToLogWriter("test", &Config{Filters: []kvFilter{{
+			Enabled: "true",
+			Tag:     "console",
+			Level:   "FINEST",
+			Type:    "console",
+			Properties: []kvProperty{
+				{Name: "color", Value: "false"},
+				{Name: "async", Value: async},
+				{Name: "buffer", Value: "1024"},
+			},
+		}}})
+		if f := l["console"]; async == "true" && f.QueueCap() != 1024 {
+			t.Errorf("async filter QueueCap = %d, want 1024", f.QueueCap())
+		}
+
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			l.Info("message %d", i)
+		}
+		elapsed := time.Since(start)
+		l.Close()
+
+		if async == "true" && elapsed >= delay {
+			t.Errorf("async filter blocked the caller for %s", elapsed)
+		}
+		if async == "false" && elapsed < 5*delay {
+			t.Errorf("sync filter returned after %s, before the writes were done", elapsed)
+		}
+		slow.mu.Lock()
+		if slow.n != 5 {
+			t.Errorf("async=%s: %d records written, want 5", async, slow.n)
+		}
+		slow.mu.Unlock()
+	}
+}
+
+func TestRegisterWriterType(t *testing.T) {
+	defer func() {
+		writerMakersMu.Lock()
+		delete(writerMakers, "memory")
+		writerMakersMu.Unlock()
+	}()
+
+	var got map[string]string
+	mw := NewMemoryLogWriter()
+	RegisterWriterType("memory", func(filename string, props map[string]string, enabled bool) (LogWriter, bool) {
+		got = props
+		if _, ok := props["bad"]; ok {
+			return nil, false
+		}
+		return mw, true
+	})
+
+	l := make(Logger)
+	cfg := NewConfigBuilder().Add("mem", "memory", INFO, Property("size", " 10 "), Property("async", false)).Build()
+	if err := l.ApplyConfig(cfg); err != nil {
+		t.Fatalf("ApplyConfig: %s", err)
+	}
+	if len(got) != 1 || got["size"] != "10" {
+		t.Errorf("properties = %v, want only size 10", got)
+	}
+	l.Info("registered")
+	l.Close()
+	if recs := mw.Records(); len(recs) != 1 || recs[0].Message != "registered" {
+		t.Errorf("records = %v, want the registered message", recs)
+	}
+
+	cfg = NewConfigBuilder().Add("mem", "memory", INFO, Property("bad", 1)).Build()
+	if err := l.ApplyConfig(cfg); err == nil || len(l) != 0 {
+		t.Errorf("invalid registered filter: ApplyConfig = %v with %d filters, want an error", err, len(l))
+	}
+
+	// A disabled filter of a type nobody registered is only a warning
+	defer func(w io.Writer) { stderr = w }(stderr)
+	warnings := new(bytes.Buffer)
+	stderr = warnings
+	cfg = NewConfigBuilder().AddConsole("stdout", INFO).Add("unregistered", "nosuchtype", INFO).Build()
+	cfg.Filters[1].Enabled = "false"
+	if err := l.ApplyConfig(cfg); err != nil || len(l) != 1 {
+		t.Errorf("disabled unregistered filter: ApplyConfig = %v with %d filters, want 1 filter", err, len(l))
+	}
+	l.Close()
+	if !strings.Contains(warnings.String(), `Unknown filter type "nosuchtype"`) {
+		t.Errorf("no warning for the unregistered type: %q", warnings)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("RegisterWriterType of a built-in type did not panic")
+			}
+		}()
+		RegisterWriterType("file", nil)
+	}()
+}
+
+// Files of dir open in this process, -1 if unknown
+func openFilesIn(dir string) int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	n := 0
+	for _, fd := range fds {
+		if target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); err == nil && strings.HasPrefix(target, dir+string(filepath.Separator)) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestShardedFileMaxOpenFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	const shards, maxOpen = 10, 3
+	w := NewShardedFileLogWriter(
+		func(rec *LogRecord) string { return rec.Source },
+		func(shard string) *FileLogWriter {
+			return NewFileLogWriter(filepath.Join(dir, shard+".log"), true).SetFormat("%M").SetHeadFoot("head", "foot")
+		}).SetMaxOpenFiles(maxOpen)
+
+	for round := 0; round < 3; round++ {
+		for i := 0; i < shards; i++ {
+			w.LogWrite(newLogRecord(INFO, fmt.Sprintf("shard%d", i), fmt.Sprintf("record %d", round)))
+
+			open := 0
+			for _, sh := range w.shards {
+				if sh.w.file != nil {
+					open++
+				}
+			}
+			if open > maxOpen {
+				t.Fatalf("%d files open, want at most %d", open, maxOpen)
+			}
+			if n := openFilesIn(dir); n > maxOpen {
+				t.Fatalf("%d descriptors open in %s, want at most %d", n, dir, maxOpen)
+			}
+		}
+	}
+	w.Close()
+
+	for i := 0; i < shards; i++ {
+		got, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("shard%d.log", i)))
+		if want := "head\nrecord 0\nrecord 1\nrecord 2\nfoot\n"; err != nil || string(got) != want {
+			t.Errorf("shard%d: %q, %v, want %q", i, got, err, want)
+		}
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != shards {
+		t.Errorf("%d files, want %d: a reopened file was rotated", len(files), shards)
+	}
+	if n := openFilesIn(dir); n > 0 {
+		t.Errorf("%d descriptors still open after Close", n)
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	contents, err := ioutil.ReadFile(filepath.Join("examples", "config.xml"))
+	if err != nil {
+		t.Fatalf("read example: %s", err)
+	}
+	// Keep the log files out of the tree
+	cfg := string(contents)
+	for _, name := range []string{"test.log", "trace.xml"} {
+		cfg = strings.Replace(cfg, ">"+name+"<", ">"+filepath.Join(dir, name)+"<", 1)
+	}
+
+	l := make(Logger)
+	defer l.Close()
+	if err := l.LoadConfigReader(strings.NewReader(cfg), "xml"); err != nil {
+		t.Fatalf("LoadConfigReader: %s", err)
+	}
+	for _, tag := range []string{"stdout", "file", "xmllog"} {
+		if l[tag] == nil {
+			t.Errorf("filter %q not loaded", tag)
+		}
+	}
+	if len(l) != 3 {
+		t.Errorf("%d filters loaded, want the 3 enabled ones", len(l))
+	}
+}
+
+func TestConfigBadOptionOpensNothing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "app.log")
+	l := make(Logger)
+	err = l.ApplyConfig(&Config{Filters: []kvFilter{{
+		Enabled: "true",
+		Tag:     "file",
+		Level:   "INFO",
+		Type:    "file",
+		Properties: []kvProperty{
+			{Name: "filename", Value: fname},
+			{Name: "buffer", Value: "-1"},
+		},
+	}}})
+	if err == nil {
+		t.Errorf("invalid buffer size accepted")
+	}
+	if _, err := os.Stat(fname); !os.IsNotExist(err) {
+		t.Errorf("log file opened for an invalid filter: %v", err)
+	}
+}
+
+func TestConfigFormatWarning(t *testing.T) {
+	errs := new(bytes.Buffer)
+	defer func(w io.Writer) {
+		stderr = w
+	}(stderr)
+	stderr = errs
+
+	l := make(Logger)
+	l.ConfigToLogWriter("test.xml", &Config{Filters: []kvFilter{{
+		Enabled: "false",
+		Tag:     "console",
+		Level:   "INFO",
+		Type:    "console",
+		Properties: []kvProperty{
+			{Name: "format", Value: "[%D %T] [%Q] %M %Q %y"},
+		},
+	}, {
+		Enabled: "false",
+		Tag:     "good",
+		Level:   "INFO",
+		Type:    "console",
+		Properties: []kvProperty{
+			{Name: "format", Value: "[%D %T] [%l] (%s) %M %K"},
+		},
+	}}})
+	l.Close()
+
+	want := `LoadConfig: Warning: Unknown format codes %Q %y in "[%D %T] [%Q] %M %Q %y" for console filter in test.xml` + "\n"
+	if got := errs.String(); got != want {
+		t.Errorf("warnings = %q, want %q", got, want)
+	}
+}
+
+func TestLoadConfigReader(t *testing.T) {
+	errs := new(bytes.Buffer)
+	defer func(w io.Writer) {
+		stderr = w
+	}(stderr)
+	stderr = errs
+
+	configs := map[string]string{
+		"xml": `<logging><filter enabled="true"><tag>mem</tag><type>console</type><level>WARNING</level></filter></logging>`,
+		"":    "\n  " + `{"filters": [{"enabled": "true", "tag": "mem", "type": "console", "level": "WARNING"}]}`,
+	}
+	for format, config := range configs {
+		l := make(Logger)
+		if err := l.LoadConfigReader(strings.NewReader(config), format); err != nil {
+			t.Fatalf("format %q: %s", format, err)
+		}
+		if filt, ok := l["mem"]; !ok || filt.level() != WARNING {
+			t.Errorf("format %q: filters = %v", format, l)
+		}
+		l.Close()
+	}
+
+	// A configuration which cannot be decoded keeps the filters
+	mem := NewMemoryLogWriter()
+	l := Logger{"mem": NewSyncFilter(INFO, mem)}
+	if err := l.LoadConfigReader(strings.NewReader(`<logging><filter>`), "xml"); err == nil {
+		t.Errorf("truncated configuration loaded")
+	}
+	if _, ok := l["mem"]; !ok {
+		t.Errorf("filters replaced by a broken configuration")
+	}
+	if !strings.HasPrefix(errs.String(), `LoadConfig: Error: Could not parse XML configuration in "LoadConfigReader"`) {
+		t.Errorf("stderr = %q", errs.String())
+	}
+	if err := l.LoadConfigReader(strings.NewReader(`{}`), "yaml"); err == nil {
+		t.Errorf("unknown format loaded")
+	}
+
+	// An invalid filter leaves the logger without filters
+	bad := `{"filters": [{"enabled": "true", "tag": "mem", "type": "nosuchtype", "level": "WARNING"}]}`
+	if err := l.LoadConfigReader(strings.NewReader(bad), "json"); err == nil {
+		t.Errorf("invalid configuration loaded")
+	}
+	if len(l) != 0 {
+		t.Errorf("filters after invalid configuration = %v", l)
+	}
+}
+
+func TestConfigDuplicateTag(t *testing.T) {
+	errs := new(bytes.Buffer)
+	defer func(w io.Writer) {
+		stderr = w
+	}(stderr)
+	stderr = errs
+
+	filter := func(format string) kvFilter {
+		return kvFilter{
+			Enabled: "false",
+			Tag:     "console",
+			Level:   "INFO",
+			Type:    "console",
+			Properties: []kvProperty{
+				{Name: "format", Value: format},
+			},
+		}
+	}
+	l := make(Logger)
+	l.ConfigToLogWriter("test.xml", &Config{Filters: []kvFilter{filter("%M"), filter("%L %M")}})
+	l.Close()
+
+	want := `LoadConfig: Warning: Duplicate tag "console" for filter in test.xml, the last one is used` + "\n"
+	if got := errs.String(); got != want {
+		t.Errorf("warnings = %q, want %q", got, want)
+	}
+}
+
+func TestJSONConfigForms(t *testing.T) {
+	const xmlConfig = `<logging>
+  <filter enabled="true">
+    <tag>stdout</tag>
+    <type>console</type>
+    <level>WARNING</level>
+    <property name="color">false</property>
+    <property name="format">%L %M</property>
+    <property name="buffer">64</property>
+  </filter>
+</logging>`
+	configs := map[string]string{
+		"config.xml":  xmlConfig,
+		"config.conf": xmlConfig, // unknown extensions are read as XML
+		"array.json": `{"filters": [{"enabled": "true", "tag": "stdout", "type": "console", "level": "WARNING",
+			"properties": [{"name": "color", "value": "false"}, {"name": "format", "value": "%L %M"}, {"name": "buffer", "value": "64"}]}]}`,
+		"map.json": `{"filters": [{"enabled": "true", "tag": "stdout", "type": "console", "level": "WARNING",
+			"properties": {"format": "%L %M", "color": false, "buffer": 64}}]}`,
+	}
+
+	var want []byte
+	for name, config := range configs {
+		l := make(Logger)
+		l.LoadConfigBuf(name, []byte(config))
+		dump, err := l.DumpConfig("json")
+		l.Close()
+		if err != nil {
+			t.Fatalf("%s: DumpConfig: %s", name, err)
+		}
+		if want == nil {
+			want = dump
+		} else if !bytes.Equal(dump, want) {
+			t.Errorf("%s loads as\n%s\nwant\n%s", name, dump, want)
+		}
+	}
+	if !bytes.Contains(want, []byte(`"%L %M"`)) {
+		t.Errorf("configuration not loaded:\n%s", want)
+	}
+}
+
+func TestConfigLevelEnv(t *testing.T) {
+	defer func(w io.Writer) {
+		stderr = w
+	}(stderr)
+	errs := new(bytes.Buffer)
+	stderr = errs
+	defer os.Unsetenv(LevelEnv)
+
+	cfg := NewConfigBuilder().
+		Add("warn", "console", WARNING).
+		Add("finest", "console", FINEST).
+		Build()
+	levels := func() string {
+		l := make(Logger)
+		if err := l.ApplyConfig(cfg); err != nil {
+			t.Fatalf("ApplyConfig: %s", err)
+		}
+		defer l.Close()
+		return l["warn"].level().String() + " " + l["finest"].level().String()
+	}
+
+	os.Setenv(LevelEnv, "DEBUG")
+	if got, want := levels(), "DEBG FNST"; got != want {
+		t.Errorf("%s=DEBUG: levels = %q, want %q", LevelEnv, got, want)
+	}
+	os.Setenv(LevelEnv, "LOUD")
+	if got, want := levels(), "WARN FNST"; got != want {
+		t.Errorf("%s=LOUD: levels = %q, want %q", LevelEnv, got, want)
+	}
+	if !strings.Contains(errs.String(), `Unknown level "LOUD"`) {
+		t.Errorf("no warning for an unknown level: %q", errs.String())
+	}
+	os.Unsetenv(LevelEnv)
+	if got, want := levels(), "WARN FNST"; got != want {
+		t.Errorf("unset: levels = %q, want %q", got, want)
+	}
+}
+
+func TestConfigDefaultFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "app.log")
+
+	for name, config := range map[string]string{
+		"config.xml": fmt.Sprintf(`<logging>
+  <format>%%L %%M</format>
+  <filter enabled="true">
+    <tag>stdout</tag>
+    <type>console</type>
+    <level>INFO</level>
+  </filter>
+  <filter enabled="true">
+    <tag>file</tag>
+    <type>file</type>
+    <level>INFO</level>
+    <property name="filename">%s</property>
+    <property name="format">%%M</property>
+  </filter>
+</logging>`, fname),
+		"config.json": fmt.Sprintf(`{"format": "%%L %%M", "filters": [
+  {"enabled": "true", "tag": "stdout", "type": "console", "level": "INFO"},
+  {"enabled": "true", "tag": "file", "type": "file", "level": "INFO",
+   "properties": {"filename": %q, "format": "%%M"}}
+]}`, fname),
+	} {
+		l := make(Logger)
+		l.LoadConfigBuf(name, []byte(config))
+		if got := l["stdout"].LogWriter.(*ConsoleLogWriter).format; got != "%L %M" {
+			t.Errorf("%s: console format = %q, want the default", name, got)
+		}
+		if got, _ := l["file"].LogWriter.(*FileLogWriter).GetOption("format"); got != "%M" {
+			t.Errorf("%s: file format = %q, want its own", name, got)
+		}
+		l.Close()
+	}
+}
+
+func TestConfigBuilder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "app.log")
+
+	xmlConfig := fmt.Sprintf(`<logging>
+  <filter enabled="true">
+    <tag>stdout</tag>
+    <type>console</type>
+    <level>WARNING</level>
+    <property name="color">false</property>
+    <property name="format">%%L %%M</property>
+  </filter>
+  <filter enabled="true">
+    <tag>file</tag>
+    <type>file</type>
+    <level>FINEST</level>
+    <property name="filename">%s</property>
+    <property name="rotate">true</property>
+    <property name="maxsize">1K</property>
+    <property name="async">false</property>
+  </filter>
+  <filter enabled="true">
+    <tag>socket</tag>
+    <type>socket</type>
+    <level>ERROR</level>
+    <property name="endpoint">127.0.0.1:12124</property>
+    <property name="protocol">udp</property>
+  </filter>
+</logging>`, fname)
+	fromXML := make(Logger)
+	fromXML.LoadConfigBuf("config.xml", []byte(xmlConfig))
+	want, err := fromXML.DumpConfig("xml")
+	fromXML.Close()
+	if err != nil {
+		t.Fatalf("DumpConfig of the XML configuration: %s", err)
+	}
+
+	cfg := NewConfigBuilder().
+		AddConsole("stdout", WARNING, Property("color", false), Property("format", "%L %M")).
+		AddFile("file", FINEST, fname, Property("rotate", true), Property("maxsize", "1K"), Property("async", false)).
+		AddSocket("socket", ERROR, "udp", "127.0.0.1:12124").
+		Build()
+	built := make(Logger)
+	if err := built.ApplyConfig(cfg); err != nil {
+		t.Fatalf("ApplyConfig: %s", err)
+	}
+	got, err := built.DumpConfig("xml")
+	built.Close()
+	if err != nil {
+		t.Fatalf("DumpConfig of the built configuration: %s", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("built configuration\n%s\nwant\n%s", got, want)
+	}
+
+	// Invalid configurations are returned as errors
+	bad := NewConfigBuilder().AddConsole("stdout", INFO).Add("other", "carrier-pigeon", INFO).Build()
+	if err := built.ApplyConfig(bad); err == nil || len(built) != 0 {
+		t.Errorf("ApplyConfig of an unknown type = %v with %d filters, want an error and none", err, len(built))
+	}
+}
+
+func TestDumpConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	l := make(Logger)
+	l.AddFilter("console", WARNING, NewConsoleLogWriter().SetFormat("%L %M"))
+	l.AddFilter("file", FINE, NewFileLogWriter(filepath.Join(dir, "app.log"), true).SetRotateSize(1024).SetRotateBackup(3))
+	l["socket"] = NewSyncFilter(ERROR, NewSocketLogWriter("udp", "127.0.0.1:12124"))
+	l["memory"] = NewFilter(INFO, NewMemoryRotateLogWriter(1024, 1))
+	if _, err := l.DumpConfig("xml"); err == nil {
+		t.Errorf("DumpConfig should fail for a memory writer")
+	}
+	l["memory"].Close()
+	delete(l, "memory")
+
+	for _, format := range []string{"xml", "json"} {
+		dump, err := l.DumpConfig(format)
+		if err != nil {
+			t.Fatalf("DumpConfig(%s): %s", format, err)
+		}
+
+		reloaded := make(Logger)
+		reloaded.LoadConfigBuf("dump."+format, dump)
+		again, err := reloaded.DumpConfig(format)
+		reloaded.Close()
+		if err != nil {
+			t.Fatalf("DumpConfig(%s) of the reloaded logger: %s", format, err)
+		}
+		if !bytes.Equal(dump, again) {
+			t.Errorf("%s round trip differs:\n%s\n---\n%s", format, dump, again)
+		}
+		if format == "xml" && !bytes.HasPrefix(dump, []byte("<logging>")) {
+			t.Errorf("xml dump root: %s", dump)
+		}
+	}
+	l.Close()
+
+	// The root of a configuration may have any name
+	other := make(Logger)
+	err = other.LoadConfigReader(strings.NewReader(`<config>
+  <filter enabled="true">
+    <tag>stdout</tag>
+    <type>console</type>
+    <level>INFO</level>
+  </filter>
+</config>`), "xml")
+	if err != nil || other["stdout"] == nil {
+		t.Errorf("root <config>: %v with %d filters, want the console filter", err, len(other))
+	}
+	other.Close()
+}
+
 func BenchmarkFormatLogRecord(b *testing.B) {
 	const updateEvery = 1
 	rec := &LogRecord{
@@ -442,6 +4243,44 @@ func BenchmarkFormatLogRecord(b *testing.B) {
 	}
 }
 
+func BenchmarkCompiledFormat(b *testing.B) {
+	const updateEvery = 1
+	rec := &LogRecord{
+		Level:   CRITICAL,
+		Created: now,
+		Source:  "source",
+		Message: "message",
+	}
+	long, short := CompileFormat(FORMAT_DEFAULT), CompileFormat(FORMAT_SHORT)
+	for i := 0; i < b.N; i++ {
+		rec.Created = rec.Created.Add(1 * time.Second / updateEvery)
+		if i%2 == 0 {
+			long.Format(rec)
+		} else {
+			short.Format(rec)
+		}
+	}
+}
+
+func BenchmarkMessageOnlyFormat(b *testing.B) {
+	rec := newLogRecord(INFO, "source", `{"event":"login","user":"kyle"}`)
+	cf := CompileFormat("%M")
+	for i := 0; i < b.N; i++ {
+		rec.Created = rec.Created.Add(time.Second)
+		cf.Format(rec)
+	}
+}
+
+func BenchmarkMessageOnlyFormatGeneral(b *testing.B) {
+	rec := newLogRecord(INFO, "source", `{"event":"login","user":"kyle"}`)
+	cf := CompileFormat("%M")
+	cf.msgOnly = false
+	for i := 0; i < b.N; i++ {
+		rec.Created = rec.Created.Add(time.Second)
+		cf.Format(rec)
+	}
+}
+
 func BenchmarkConsoleLog(b *testing.B) {
 	/* This doesn't seem to work on OS X
 	sink, err := os.Open(os.DevNull)
@@ -529,6 +4368,75 @@ func BenchmarkFileUtilNotLog(b *testing.B) {
 	os.Remove("benchlog.log")
 }
 
+// A writer dropping every record, to time the logging path alone
+type discardWriter struct{}
+
+func (discardWriter) LogWrite(rec *LogRecord) {}
+func (discardWriter) Close()                  {}
+
+// Stop the clock for stable time stamps, returning the function restoring it
+func stopClock() func() {
+	clock := timeNow
+	timeNow = func() time.Time { return now }
+	return func() { timeNow = clock }
+}
+
+func BenchmarkSuppressedDebug(b *testing.B) {
+	l := Logger{"discard": NewSyncFilter(INFO, discardWriter{})}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.Debug("%s is a log message", "This")
+	}
+}
+
+func BenchmarkInfoDiscard(b *testing.B) {
+	defer stopClock()()
+	l := Logger{"discard": NewSyncFilter(INFO, discardWriter{})}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.Info("%s is a log message", "This")
+	}
+}
+
+func BenchmarkFormatDefault(b *testing.B) {
+	rec := newLogRecord(INFO, "source", "This is a log message")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		FormatLogRecord(FORMAT_DEFAULT, rec)
+	}
+}
+
+// Time a file write through a filter made by newFilter
+func benchmarkFileWrite(b *testing.B, newFilter func(w LogWriter) *Filter) {
+	defer stopClock()()
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		b.Fatalf("tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	l := Logger{"file": newFilter(NewFileLogWriter(filepath.Join(dir, "bench.log"), false))}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Info("%s is a log message", "This")
+	}
+	b.StopTimer()
+	l.Close()
+}
+
+func BenchmarkFileWriteSync(b *testing.B) {
+	benchmarkFileWrite(b, func(w LogWriter) *Filter {
+		return NewSyncFilter(INFO, w)
+	})
+}
+
+func BenchmarkFileWriteBuffered(b *testing.B) {
+	benchmarkFileWrite(b, func(w LogWriter) *Filter {
+		return NewBufferedFilter(INFO, w, 1000)
+	})
+}
+
 // Benchmark results (windows amd64 10g)
 // BenchmarkFormatLogRecord-4        300000              4433 ns/op
 // BenchmarkConsoleLog-4            1000000              1746 ns/op
@@ -0,0 +1,189 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This log writer keeps every record in memory, for tests and for capturing
+// the output of a piece of work (see Logger.StartCapture).  It is safe for
+// concurrent use.
+type MemoryLogWriter struct {
+	mu   sync.Mutex
+	recs []*LogRecord
+
+	// The format of String
+	format   string
+	compiled *CompiledFormat
+}
+
+// NewMemoryLogWriter creates an empty MemoryLogWriter.
+func NewMemoryLogWriter() *MemoryLogWriter {
+	return &MemoryLogWriter{
+		format: "[%D %z %T] [%L] (%S) %M",
+	}
+}
+
+// Set the format of String (chainable).
+func (w *MemoryLogWriter) SetFormat(format string) *MemoryLogWriter {
+	noteFormat(format)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.format = format
+	return w
+}
+
+func (w *MemoryLogWriter) LogWrite(rec *LogRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.recs = append(w.recs, rec)
+}
+
+// Records returns the records written so far, oldest first.
+func (w *MemoryLogWriter) Records() []*LogRecord {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]*LogRecord(nil), w.recs...)
+}
+
+// String returns the records written so far in the format, one per line.
+func (w *MemoryLogWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.compiled = compiledFor(w.compiled, w.format, nil)
+	var out strings.Builder
+	for _, rec := range w.recs {
+		out.WriteString(w.compiled.Format(rec))
+	}
+	return out.String()
+}
+
+// Reset forgets the records written so far.
+func (w *MemoryLogWriter) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.recs = nil
+}
+
+// The records are kept after Close so that they can still be read.
+func (w *MemoryLogWriter) Close() {
+}
+
+// This log writer keeps the most recent output in memory, split into
+// segments like the rotated files of a FileLogWriter.  Useful where there is
+// no disk, or to attach the recent log to an error report.
+type MemoryRotateLogWriter struct {
+	mu sync.Mutex
+
+	// The logging format
+	format   string
+	compiled *CompiledFormat
+	loc      *time.Location
+
+	// Replaces the format if set
+	formatter Formatter
+
+	// Rotate when a record would grow the current segment beyond maxsize
+	maxsize int
+
+	// Number of archived segments to keep
+	rotate int
+
+	current  []byte
+	archived [][]byte // oldest first
+}
+
+// NewMemoryRotateLogWriter creates a writer keeping a current segment of up
+// to maxsize bytes and at most rotate archived segments.  A record longer than
+// maxsize gets a segment of its own.
+func NewMemoryRotateLogWriter(maxsize, rotate int) *MemoryRotateLogWriter {
+	return &MemoryRotateLogWriter{
+		format:  "[%D %z %T] [%L] (%S) %M",
+		maxsize: maxsize,
+		rotate:  rotate,
+	}
+}
+
+// Set the logging format (chainable).  Must be called before the first log
+// message is written.
+func (w *MemoryRotateLogWriter) SetFormat(format string) *MemoryRotateLogWriter {
+	noteFormat(format)
+	w.format = format
+	return w
+}
+
+// SetTimezone renders the times of the records in loc (chainable), e.g.
+// America/New_York for a report read in the US.  nil, the default, renders
+// them in the location they were created in.
+func (w *MemoryRotateLogWriter) SetTimezone(loc *time.Location) *MemoryRotateLogWriter {
+	w.loc = loc
+	return w
+}
+
+// SetFormatter renders the records with f instead of the format string
+// (chainable).  Records f fails to render are reported and dropped.  nil
+// returns to the format string.  Must be called before the first log message
+// is written.
+func (w *MemoryRotateLogWriter) SetFormatter(f Formatter) *MemoryRotateLogWriter {
+	w.formatter = f
+	return w
+}
+
+func (w *MemoryRotateLogWriter) LogWrite(rec *LogRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var line string
+	if w.formatter != nil {
+		out, err := w.formatter(rec)
+		if err != nil {
+			fmt.Fprintf(stderr, "MemoryRotateLogWriter: %s\n", err)
+			return
+		}
+		line = string(out)
+	} else {
+		w.compiled = compiledFor(w.compiled, w.format, w.loc)
+		line = w.compiled.Format(rec)
+	}
+	if w.maxsize > 0 && len(w.current) > 0 && len(w.current)+len(line) > w.maxsize {
+		w.intRotate()
+	}
+	w.current = append(w.current, line...)
+}
+
+// Archive the current segment, evicting the oldest beyond the rotate count
+func (w *MemoryRotateLogWriter) intRotate() {
+	if w.rotate > 0 {
+		w.archived = append(w.archived, w.current)
+		if len(w.archived) > w.rotate {
+			w.archived = w.archived[len(w.archived)-w.rotate:]
+		}
+	}
+	w.current = nil
+}
+
+// Current returns a copy of the segment being written.
+func (w *MemoryRotateLogWriter) Current() []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]byte(nil), w.current...)
+}
+
+// Archived returns copies of the archived segments, oldest first.
+func (w *MemoryRotateLogWriter) Archived() [][]byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	segs := make([][]byte, len(w.archived))
+	for i, seg := range w.archived {
+		segs[i] = append([]byte(nil), seg...)
+	}
+	return segs
+}
+
+// The segments are kept after Close so that they can still be read.
+func (w *MemoryRotateLogWriter) Close() {
+}
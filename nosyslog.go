@@ -0,0 +1,22 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build windows || plan9
+// +build windows plan9
+
+package log4go
+
+import (
+	"fmt"
+	"os"
+)
+
+// There is no log/syslog here
+func propToSyslogLogWriter(filename string, props []kvProperty, enabled bool) (LogWriter, bool) {
+	// A disabled filter does not need syslog, e.g. in a configuration shared
+	// with other systems
+	if !enabled {
+		return nil, true
+	}
+	fmt.Fprintf(os.Stderr, "LoadConfig: Error: syslog filters are not supported on this system in %s\n", filename)
+	return nil, false
+}
@@ -0,0 +1,29 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build windows || plan9
+// +build windows plan9
+
+package log4go
+
+import (
+	"testing"
+)
+
+func TestSyslogUnsupported(t *testing.T) {
+	filter := kvFilter{
+		Enabled: "false",
+		Tag:     "syslog",
+		Level:   "WARNING",
+		Type:    "syslog",
+	}
+	l := make(Logger)
+	if err := l.ApplyConfig(&Config{Filters: []kvFilter{filter}}); err != nil {
+		t.Errorf("disabled syslog filter: ApplyConfig = %v, want no error", err)
+	}
+
+	filter.Enabled = "true"
+	err := l.ApplyConfig(&Config{Filters: []kvFilter{filter}})
+	if err == nil || len(l) != 0 {
+		t.Errorf("ApplyConfig = %v with %d filters, want an error", err, len(l))
+	}
+}
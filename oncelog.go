@@ -0,0 +1,71 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"container/list"
+	"sync"
+)
+
+// This log writer writes only the first record with a given message, e.g.
+// for deprecation warnings which would otherwise repeat on every request.
+// Unlike StackDedupLogWriter the messages are remembered for the lifetime of
+// the writer, not for a window.  Only a hash of each message is kept.
+type OnceLogWriter struct {
+	w LogWriter
+
+	mu   sync.Mutex
+	max  int
+	seen map[uint64]*list.Element
+	lru  *list.List // hashes, most recently seen first
+}
+
+// NewOnceLogWriter wraps w, suppressing repeated messages.
+func NewOnceLogWriter(w LogWriter) *OnceLogWriter {
+	return &OnceLogWriter{
+		w:    w,
+		seen: make(map[uint64]*list.Element),
+		lru:  list.New(),
+	}
+}
+
+// SetMaxEntries caps the number of remembered messages (chainable).  When
+// the cap is reached the least recently seen message is forgotten, and will
+// be written again the next time it is logged.  Zero, the default, means no
+// cap.
+func (o *OnceLogWriter) SetMaxEntries(max int) *OnceLogWriter {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.max = max
+	o.trim()
+	return o
+}
+
+// Forget the least recently seen messages above the cap
+func (o *OnceLogWriter) trim() {
+	for o.max > 0 && o.lru.Len() > o.max {
+		e := o.lru.Back()
+		o.lru.Remove(e)
+		delete(o.seen, e.Value.(uint64))
+	}
+}
+
+func (o *OnceLogWriter) LogWrite(rec *LogRecord) {
+	key := stackKey(rec.Message)
+
+	o.mu.Lock()
+	if e, ok := o.seen[key]; ok {
+		o.lru.MoveToFront(e)
+		o.mu.Unlock()
+		return
+	}
+	o.seen[key] = o.lru.PushFront(key)
+	o.trim()
+	o.mu.Unlock()
+
+	o.w.LogWrite(rec)
+}
+
+func (o *OnceLogWriter) Close() {
+	o.w.Close()
+}
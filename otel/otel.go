@@ -0,0 +1,167 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+// Package otel sends log4go records to OpenTelemetry, following the
+// OpenTelemetry log data model: the level becomes the severity, the message
+// the body and the fields the attributes.  The OpenTelemetry dependencies are
+// confined to this package.
+//
+//	w, err := otel.NewOTLPLogWriter()
+//	if err != nil { ... }
+//	log.AddFilter("otel", l4g.INFO, w)
+package otel
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	l4g "github.com/ccpaging/log4go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Instrumentation scope of the records
+const ScopeName = "github.com/ccpaging/log4go"
+
+// Severity numbers of the levels (FINEST .. CRITICAL)
+var severities = [...]log.Severity{
+	log.SeverityTrace1, // FINEST
+	log.SeverityTrace2, // FINE
+	log.SeverityDebug1, // DEBUG
+	log.SeverityDebug2, // TRACE, which ranks above DEBUG in log4go
+	log.SeverityInfo1,  // INFO
+	log.SeverityWarn1,  // WARNING
+	log.SeverityError1, // ERROR
+	log.SeverityFatal1, // CRITICAL
+}
+
+// Severity returns the OpenTelemetry severity number of a level.
+func Severity(lvl l4g.Level) log.Severity {
+	if int(lvl) < 0 || int(lvl) >= len(severities) {
+		return log.SeverityUndefined
+	}
+	return severities[lvl]
+}
+
+// Keys of the fields returned by TraceFields
+const (
+	TraceIDKey = "trace_id"
+	SpanIDKey  = "span_id"
+)
+
+// TraceFields returns the trace and span ids of the span in ctx as fields, so
+// that records correlate with traces, or nil if ctx carries no valid span:
+//
+//	log.LogWith(l4g.INFO, "request served", otel.TraceFields(ctx)...)
+//
+// The ids are hex strings, rendered by the %K format verb and carried as
+// attributes by LogWriter.
+func TraceFields(ctx context.Context) []l4g.Field {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+	return []l4g.Field{
+		{Key: TraceIDKey, Value: sc.TraceID().String()},
+		{Key: SpanIDKey, Value: sc.SpanID().String()},
+	}
+}
+
+// This log writer emits records through an OpenTelemetry logger
+type LogWriter struct {
+	logger log.Logger
+
+	// Set when the writer owns the provider
+	provider *sdklog.LoggerProvider
+}
+
+// NewLogWriter emits records through a logger of provider.  Close does not
+// shut the provider down.
+func NewLogWriter(provider log.LoggerProvider) *LogWriter {
+	return &LogWriter{
+		logger: provider.Logger(ScopeName),
+	}
+}
+
+// NewExporterLogWriter emits records to exporter in batches.  Close flushes
+// the pending records and shuts the exporter down.
+func NewExporterLogWriter(exporter sdklog.Exporter) *LogWriter {
+	provider := sdklog.NewLoggerProvider(
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(exporter)),
+	)
+	return &LogWriter{
+		logger:   provider.Logger(ScopeName),
+		provider: provider,
+	}
+}
+
+// NewOTLPLogWriter exports records with OTLP over HTTP.  The endpoint and the
+// other settings come from opts and the OTEL_EXPORTER_OTLP_* environment
+// variables.
+func NewOTLPLogWriter(opts ...otlploghttp.Option) (*LogWriter, error) {
+	exporter, err := otlploghttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewExporterLogWriter(exporter), nil
+}
+
+// Convert a field to an attribute, keeping numbers and booleans typed
+func keyValue(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case int32:
+		return attribute.Int64(key, int64(v))
+	case float64:
+		return attribute.Float64(key, v)
+	case float32:
+		return attribute.Float64(key, float64(v))
+	case []byte:
+		return attribute.ByteSlice(key, v)
+	case fmt.Stringer:
+		return attribute.String(key, v.String())
+	}
+	return attribute.String(key, fmt.Sprint(value))
+}
+
+func (w *LogWriter) LogWrite(rec *l4g.LogRecord) {
+	var r log.Record
+	r.SetTimestamp(rec.Created)
+	r.SetObservedTimestamp(time.Now())
+	r.SetSeverity(Severity(rec.Level))
+	r.SetSeverityText(rec.Level.String())
+	if len(rec.Binary) > 0 {
+		r.SetBody(attribute.ByteSliceValue(rec.Binary))
+	} else {
+		r.SetBody(attribute.StringValue(rec.Message))
+	}
+	if len(rec.Source) > 0 {
+		r.AddAttributes(attribute.String("source", rec.Source))
+	}
+	for _, f := range rec.Fields {
+		r.AddAttributes(keyValue(f.Key, f.Value))
+	}
+	w.logger.Emit(context.Background(), r)
+}
+
+// Close flushes and shuts down the provider created by NewExporterLogWriter
+// or NewOTLPLogWriter.
+func (w *LogWriter) Close() {
+	if w.provider == nil {
+		return
+	}
+	if err := w.provider.Shutdown(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "otel.LogWriter: %s\n", err)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package otel
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	l4g "github.com/ccpaging/log4go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// What the memory exporter keeps of a record
+type exported struct {
+	severity log.Severity
+	body     string
+	attrs    map[string]attribute.Value
+}
+
+// Keeps the exported records in memory
+type memoryExporter struct {
+	mu   sync.Mutex
+	recs []exported
+}
+
+func (e *memoryExporter) Export(ctx context.Context, records []sdklog.Record) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range records {
+		ex := exported{
+			severity: r.Severity(),
+			body:     r.Body().AsString(),
+			attrs:    make(map[string]attribute.Value),
+		}
+		r.WalkAttributes(func(kv attribute.KeyValue) bool {
+			ex.attrs[string(kv.Key)] = kv.Value
+			return true
+		})
+		e.recs = append(e.recs, ex)
+	}
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(ctx context.Context) error   { return nil }
+func (e *memoryExporter) ForceFlush(ctx context.Context) error { return nil }
+
+func TestLogWriter(t *testing.T) {
+	exp := new(memoryExporter)
+	l := make(l4g.Logger)
+	l.AddFilter("otel", l4g.FINEST, NewExporterLogWriter(exp))
+	l.LogWith(l4g.WARNING, "disk almost full", l4g.Field{Key: "pct", Value: 93}, l4g.Field{Key: "volume", Value: "/data"})
+	l.Log(l4g.CRITICAL, "main", "giving up")
+	l.Close()
+
+	if len(exp.recs) != 2 {
+		t.Fatalf("exported %d records, want 2", len(exp.recs))
+	}
+	warn, crit := exp.recs[0], exp.recs[1]
+	if warn.severity != log.SeverityWarn || warn.body != "disk almost full" {
+		t.Errorf("warning exported as %v %q", warn.severity, warn.body)
+	}
+	if v := warn.attrs["pct"]; v.Type() != attribute.INT64 || v.AsInt64() != 93 {
+		t.Errorf("pct attribute = %v", v.Emit())
+	}
+	if v := warn.attrs["volume"]; v.AsString() != "/data" {
+		t.Errorf("volume attribute = %v", v.Emit())
+	}
+	if crit.severity != log.SeverityFatal || crit.attrs["source"].AsString() != "main" {
+		t.Errorf("critical exported as %v from %q", crit.severity, crit.attrs["source"].AsString())
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	for lvl, want := range map[l4g.Level]log.Severity{
+		l4g.FINEST:   log.SeverityTrace1,
+		l4g.DEBUG:    log.SeverityDebug1,
+		l4g.INFO:     log.SeverityInfo1,
+		l4g.ERROR:    log.SeverityError1,
+		l4g.CRITICAL: log.SeverityFatal1,
+	} {
+		if got := Severity(lvl); got != want {
+			t.Errorf("Severity(%v) = %v, want %v", lvl, got, want)
+		}
+	}
+}
+
+func TestTraceFields(t *testing.T) {
+	provider := sdktrace.NewTracerProvider()
+	defer provider.Shutdown(context.Background())
+	ctx, span := provider.Tracer("test").Start(context.Background(), "request")
+	defer span.End()
+	if !span.IsRecording() {
+		t.Fatalf("span is not recording")
+	}
+
+	mw := l4g.NewMemoryLogWriter().SetFormat("%M %K")
+	l := l4g.Logger{"mem": l4g.NewSyncFilter(l4g.INFO, mw)}
+	l.LogWith(l4g.INFO, "traced", TraceFields(ctx)...)
+	l.LogWith(l4g.INFO, "untraced", TraceFields(context.Background())...)
+	l.Close()
+
+	sc := span.SpanContext()
+	want := "traced trace_id=" + sc.TraceID().String() + " span_id=" + sc.SpanID().String() + "\nuntraced \n"
+	if got := mw.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// This log writer formats records like the standard library log package.
+// The flag bits are those of the log package (log.Ldate, log.Ltime,
+// log.Lmicroseconds, log.Lshortfile, log.Llongfile, log.LUTC and
+// log.Lmsgprefix).
+type OutLogWriter struct {
+	mu     sync.Mutex
+	out    io.Writer
+	prefix string
+	flag   int
+
+	// Prefixes replacing prefix for some levels
+	levelPrefix map[Level]string
+}
+
+// NewOutLogWriter creates a writer like log.New(out, prefix, flag)
+func NewOutLogWriter(out io.Writer, prefix string, flag int) *OutLogWriter {
+	return &OutLogWriter{
+		out:    out,
+		prefix: prefix,
+		flag:   flag,
+	}
+}
+
+// SetPrefix sets the prefix of every line (chainable).
+func (w *OutLogWriter) SetPrefix(prefix string) *OutLogWriter {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.prefix = prefix
+	return w
+}
+
+// Prefix returns the prefix of every line.
+func (w *OutLogWriter) Prefix() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.prefix
+}
+
+// SetLevelPrefix sets the prefix of the lines logged at lvl (chainable), so
+// that, for example, errors stand out from informational lines.  Other levels
+// keep the prefix given by SetPrefix.
+func (w *OutLogWriter) SetLevelPrefix(lvl Level, prefix string) *OutLogWriter {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.levelPrefix == nil {
+		w.levelPrefix = make(map[Level]string)
+	}
+	w.levelPrefix[lvl] = prefix
+	return w
+}
+
+// SetFlags sets the log package flags (chainable).
+func (w *OutLogWriter) SetFlags(flag int) *OutLogWriter {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flag = flag
+	return w
+}
+
+// Flags returns the log package flags.
+func (w *OutLogWriter) Flags() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flag
+}
+
+func (w *OutLogWriter) Close() {
+}
+
+// Append n zero padded to wid digits
+func itoa(buf *bytes.Buffer, n int, wid int) {
+	var b [20]byte
+	bp := len(b) - 1
+	for n >= 10 || wid > 1 {
+		wid--
+		q := n / 10
+		b[bp] = byte('0' + n - q*10)
+		bp--
+		n = q
+	}
+	b[bp] = byte('0' + n)
+	buf.Write(b[bp:])
+}
+
+func (w *OutLogWriter) LogWrite(rec *LogRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	prefix := w.prefix
+	if p, ok := w.levelPrefix[rec.Level]; ok {
+		prefix = p
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, 64+len(rec.Message)))
+	if w.flag&log.Lmsgprefix == 0 {
+		buf.WriteString(prefix)
+	}
+	if w.flag&(log.Ldate|log.Ltime|log.Lmicroseconds) != 0 {
+		t := rec.Created
+		if w.flag&log.LUTC != 0 {
+			t = t.UTC()
+		}
+		if w.flag&log.Ldate != 0 {
+			year, month, day := t.Date()
+			itoa(buf, year, 4)
+			buf.WriteByte('/')
+			itoa(buf, int(month), 2)
+			buf.WriteByte('/')
+			itoa(buf, day, 2)
+			buf.WriteByte(' ')
+		}
+		if w.flag&(log.Ltime|log.Lmicroseconds) != 0 {
+			hour, min, sec := t.Clock()
+			itoa(buf, hour, 2)
+			buf.WriteByte(':')
+			itoa(buf, min, 2)
+			buf.WriteByte(':')
+			itoa(buf, sec, 2)
+			if w.flag&log.Lmicroseconds != 0 {
+				buf.WriteByte('.')
+				itoa(buf, t.Nanosecond()/1e3, 6)
+			}
+			buf.WriteByte(' ')
+		}
+	}
+	if w.flag&(log.Lshortfile|log.Llongfile) != 0 {
+		file, line := rec.file, rec.line
+		if len(file) == 0 {
+			file, line = "???", 0
+		} else if w.flag&log.Lshortfile != 0 {
+			file = filepath.Base(file)
+		}
+		buf.WriteString(file)
+		buf.WriteByte(':')
+		itoa(buf, line, -1)
+		buf.WriteString(": ")
+	}
+	if w.flag&log.Lmsgprefix != 0 {
+		buf.WriteString(prefix)
+	}
+	buf.WriteString(rec.Message)
+	if !strings.HasSuffix(rec.Message, "\n") {
+		buf.WriteByte('\n')
+	}
+	w.out.Write(buf.Bytes())
+}
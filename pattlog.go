@@ -5,17 +5,26 @@ package log4go
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
 const (
 	FORMAT_DEFAULT = "[%D %T %z] [%L] (%S) %M"
 	FORMAT_SHORT   = "[%t %d] [%L] %M"
 	FORMAT_ABBREV  = "[%L] %M"
+	FORMAT_LOGFMT  = "%O"
 )
 
 type formatCacheType struct {
 	LastUpdateSeconds    int64
+	loc                  *time.Location
 	longTime, shortTime string
 	longZone, shortZone string
 	longDate, shortDate   string
@@ -23,6 +32,12 @@ type formatCacheType struct {
 
 var formatCache = &formatCacheType{}
 
+// TrimSourcePrefix is removed from the front of the source rendered by %S
+// and of the path rendered by %F, e.g. "/src/app/" to turn
+// "/src/app/pkg/file.go:12" into "pkg/file.go:12", or "github.com/org/repo/"
+// for sources given by hand.  Empty by default (no trimming).
+var TrimSourcePrefix = ""
+
 // Known format codes:
 // %T - Time (15:04:05)
 // %t - Time (15:04)
@@ -30,33 +45,279 @@ var formatCache = &formatCacheType{}
 // %z - Zone (MST)
 // %D - Date (2006/01/02)
 // %d - Date (01/02/06)
-// %L - Level (FNST, FINE, DEBG, TRAC, WARN, EROR, CRIT)
+// %L - Level (FNST, FINE, DEBG, TRAC, WARN, EROR, CRIT, or see SetLevelLabels)
+// %l - Level name (FINEST, FINE, DEBUG, TRACE, INFO, WARNING, ERROR, CRITICAL)
 // %S - Source
 // %s - Short Source
+// %F - Full path and line of the calling file (/src/app/main.go:12), or the
+//      source if given by hand
 // %M - Message
+// %K - Fields of the record (key=value, in the order they were added)
+// %c - Fields of the logger context, see Logger.SetContext
+// %A - All fields: the context overridden by the fields of the record, in
+//      the order of the context and then of the fields new to it
+// %+ - Time since the previous record rendered with the format (+12.3ms),
+//      i.e. by the same writer; +0s for FormatLogRecord, which has no previous
+// %g - Goroutine id of the logging call (? if unknown), see below
+// %O - The whole record as logfmt (time=... level=info source=... msg="..." key=value)
+// %P - Process id
+// %H - Host name (? if unknown)
+// Other codes are rendered by the functions given to RegisterFormatFunc
 // Ignores unknown formats
 // Recommended: "[%D %T] [%L] (%S) %M"
+//
+// Go does not expose goroutine ids: they are parsed from runtime.Stack, which
+// costs about a microsecond per record.  This is paid only once a format
+// with %g has been set or compiled; records logged before have no id.
+//
+// The format is parsed on every call; writers use a CompiledFormat instead.
 func FormatLogRecord(format string, rec *LogRecord) string {
+	return CompileFormat(format).Format(rec)
+}
+
+// A Formatter renders a record in a form of its own, e.g. protobuf or CEF,
+// and is used by a writer instead of its format string (see the SetFormatter
+// methods of the writers).  The output should end with a newline if the
+// records are meant to be lines.
+type Formatter func(rec *LogRecord) ([]byte, error)
+
+// A piece of a compiled format: either literal text or a format code
+type formatSegment struct {
+	verb    byte // format code, 0 for literal text
+	literal []byte
+}
+
+// A CompiledFormat is a format string (see FormatLogRecord) split once into
+// literal text and format codes, so that rendering a record only walks the
+// segments.
+type CompiledFormat struct {
+	format string
+	segs   []formatSegment
+
+	// Location of the times rendered, nil for the location of the record
+	loc *time.Location
+
+	// Wrap the level in the escape sequences of ColorBytes
+	colorLevel bool
+
+	// The format is "%M" followed by literal text only, e.g. for messages
+	// which are formatted already
+	msgOnly bool
+	suffix  string
+
+	// The format has %+: last is the creation time of the previous record
+	// rendered (UnixNano)
+	elapsed bool
+	last    int64
+}
+
+// CompileFormat parses format for repeated use.
+func CompileFormat(format string) *CompiledFormat {
+	noteFormat(format)
+	cf := &CompiledFormat{format: format}
+
+	// Split the string into pieces by % signs
+	pieces := bytes.Split([]byte(format), []byte{'%'})
+	for i, piece := range pieces {
+		if len(piece) == 0 {
+			continue
+		}
+		if i > 0 {
+			cf.segs = append(cf.segs, formatSegment{verb: piece[0]})
+			piece = piece[1:]
+			if len(piece) == 0 {
+				continue
+			}
+		}
+		// Merge adjacent literal text
+		if n := len(cf.segs); n > 0 && cf.segs[n-1].verb == 0 {
+			cf.segs[n-1].literal = append(cf.segs[n-1].literal, piece...)
+		} else {
+			cf.segs = append(cf.segs, formatSegment{literal: piece})
+		}
+	}
+
+	for _, seg := range cf.segs {
+		cf.elapsed = cf.elapsed || seg.verb == '+'
+	}
+	switch {
+	case len(cf.segs) == 1 && cf.segs[0].verb == 'M':
+		cf.msgOnly = true
+	case len(cf.segs) == 2 && cf.segs[0].verb == 'M' && cf.segs[1].verb == 0:
+		cf.msgOnly, cf.suffix = true, string(cf.segs[1].literal)
+	}
+	return cf
+}
+
+// In renders the times of the records in loc (chainable), e.g. UTC or
+// America/New_York, instead of the location they were created in.  nil
+// keeps the location of each record.
+func (cf *CompiledFormat) In(loc *time.Location) *CompiledFormat {
+	cf.loc = loc
+	return cf
+}
+
+// String returns the format the CompiledFormat was compiled from.
+func (cf *CompiledFormat) String() string {
+	return cf.format
+}
+
+// Format codes rendered by CompiledFormat.Format
+const formatVerbs = "TtZzDdLlSsFMKcA+OgPH"
+
+// The process id and host name of %P and %H, which do not change while the
+// process runs
+var (
+	processID = strconv.Itoa(os.Getpid())
+	hostName  = lookupHostName()
+)
+
+func lookupHostName() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "?"
+	}
+	return name
+}
+
+// List the codes of format which render nothing, e.g. "%Q" for a typo, once
+// each in order of appearance
+func unknownFormatVerbs(format string) []string {
+	var unknown []string
+	for _, seg := range CompileFormat(format).segs {
+		if seg.verb == 0 || strings.IndexByte(formatVerbs, seg.verb) >= 0 || formatFunc(seg.verb) != nil {
+			continue
+		}
+		code := "%" + string(seg.verb)
+		dup := false
+		for _, u := range unknown {
+			dup = dup || u == code
+		}
+		if !dup {
+			unknown = append(unknown, code)
+		}
+	}
+	return unknown
+}
+
+// Functions rendering the format codes added by RegisterFormatFunc,
+// replaced as a whole on registration
+var (
+	formatFuncsMu sync.Mutex
+	formatFuncs   atomic.Value // map[byte]func(*LogRecord) string
+)
+
+// RegisterFormatFunc adds the format code %verb, rendered by fn from the
+// record at format time, e.g. %h for the host name or %V for a build version.
+// The verb must be an ASCII character which is not a built-in format code.
+// Registering a verb again replaces its function.
+func RegisterFormatFunc(verb rune, fn func(*LogRecord) string) error {
+	if verb <= 0 || verb >= utf8.RuneSelf || verb == '%' {
+		return fmt.Errorf("RegisterFormatFunc: invalid verb %q", verb)
+	}
+	if strings.IndexByte(formatVerbs, byte(verb)) >= 0 {
+		return fmt.Errorf("RegisterFormatFunc: %%%c is a built-in format code", verb)
+	}
+	if fn == nil {
+		return fmt.Errorf("RegisterFormatFunc: nil function for %%%c", verb)
+	}
+
+	formatFuncsMu.Lock()
+	defer formatFuncsMu.Unlock()
+	old, _ := formatFuncs.Load().(map[byte]func(*LogRecord) string)
+	funcs := make(map[byte]func(*LogRecord) string, len(old)+1)
+	for v, f := range old {
+		funcs[v] = f
+	}
+	funcs[byte(verb)] = fn
+	formatFuncs.Store(funcs)
+	return nil
+}
+
+// The function registered for a format code, or nil
+func formatFunc(verb byte) func(*LogRecord) string {
+	funcs, _ := formatFuncs.Load().(map[byte]func(*LogRecord) string)
+	return funcs[verb]
+}
+
+// Set once a format with %g is used, from then on the goroutine id of every
+// record is captured
+var goroutineIDs int32
+
+// Note the format codes of format which need data captured at log time.
+// Writers call it when their format is set.
+func noteFormat(format string) {
+	if atomic.LoadInt32(&goroutineIDs) == 0 && strings.Contains(format, "%g") {
+		atomic.StoreInt32(&goroutineIDs, 1)
+	}
+}
+
+// The id of the calling goroutine, parsed from "goroutine 123 [running]:",
+// or 0 if it cannot be found
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// Return cf if it was compiled from format for loc, or format compiled anew.
+// Lets writers keep a compiled format in step with their format string and
+// timezone.
+func compiledFor(cf *CompiledFormat, format string, loc *time.Location) *CompiledFormat {
+	if cf == nil || cf.format != format || cf.loc != loc {
+		return CompileFormat(format).In(loc)
+	}
+	return cf
+}
+
+// Format renders the record like FormatLogRecord.
+func (cf *CompiledFormat) Format(rec *LogRecord) string {
 	if rec == nil {
 		return "<nil>"
 	}
-	if len(format) == 0 {
+	if len(cf.format) == 0 {
 		return ""
 	}
+	if cf.msgOnly {
+		return rec.Message + cf.suffix + "\n"
+	}
 
 	out := bytes.NewBuffer(make([]byte, 0, 64))
-	secs := rec.Created.UnixNano() / 1e9
+	created := rec.Created
+	if cf.loc != nil {
+		created = created.In(cf.loc)
+	}
+	secs := created.UnixNano() / 1e9
+
+	var elapsed time.Duration
+	if cf.elapsed {
+		if prev := atomic.SwapInt64(&cf.last, rec.Created.UnixNano()); prev != 0 {
+			// Records from concurrent callers may arrive slightly out of order
+			if d := rec.Created.Sub(time.Unix(0, prev)); d > 0 {
+				elapsed = d
+			}
+		}
+	}
 
 	cache := *formatCache
-	if cache.LastUpdateSeconds != secs {
-		month, day, year := rec.Created.Month(), rec.Created.Day(), rec.Created.Year()
-		hour, minute, second := rec.Created.Hour(), rec.Created.Minute(), rec.Created.Second()
+	if cache.LastUpdateSeconds != secs || cache.loc != created.Location() {
+		month, day, year := created.Month(), created.Day(), created.Year()
+		hour, minute, second := created.Hour(), created.Minute(), created.Second()
 		updated := &formatCacheType{
 			LastUpdateSeconds: secs,
+			loc:               created.Location(),
 			shortTime:         fmt.Sprintf("%02d:%02d", hour, minute),
 			longTime:          fmt.Sprintf("%02d:%02d:%02d", hour, minute, second),
-			shortZone:         rec.Created.Format("MST"),
-			longZone:          rec.Created.Format("-0700"),
+			shortZone:         created.Format("MST"),
+			longZone:          created.Format("-0700"),
 			shortDate:         fmt.Sprintf("%02d/%02d/%02d", day, month, year%100),
 			longDate:          fmt.Sprintf("%04d/%02d/%02d", year, month, day),
 		}
@@ -64,40 +325,67 @@ func FormatLogRecord(format string, rec *LogRecord) string {
 		formatCache = updated
 	}
 
-	// Split the string into pieces by % signs
-	pieces := bytes.Split([]byte(format), []byte{'%'})
-
-	// Iterate over the pieces, replacing known formats
-	for i, piece := range pieces {
-		if i > 0 && len(piece) > 0 {
-			switch piece[0] {
-			case 'T':
-				out.WriteString(cache.longTime)
-			case 't':
-				out.WriteString(cache.shortTime)
-			case 'Z':
-				out.WriteString(cache.longZone)
-			case 'z':
-				out.WriteString(cache.shortZone)
-			case 'D':
-				out.WriteString(cache.longDate)
-			case 'd':
-				out.WriteString(cache.shortDate)
-			case 'L':
-				out.WriteString(levelStrings[rec.Level])
-			case 'S':
-				out.WriteString(rec.Source)
-			case 's':
-				slice := strings.Split(rec.Source, "/")
-				out.WriteString(slice[len(slice)-1])
-			case 'M':
-				out.WriteString(rec.Message)
+	// Iterate over the segments, replacing known formats
+	for _, seg := range cf.segs {
+		switch seg.verb {
+		case 0:
+			out.Write(seg.literal)
+		case 'T':
+			out.WriteString(cache.longTime)
+		case 't':
+			out.WriteString(cache.shortTime)
+		case 'Z':
+			out.WriteString(cache.longZone)
+		case 'z':
+			out.WriteString(cache.shortZone)
+		case 'D':
+			out.WriteString(cache.longDate)
+		case 'd':
+			out.WriteString(cache.shortDate)
+		case 'L':
+			cf.writeLevel(out, rec.Level, levelLabel(rec.Level))
+		case 'l':
+			cf.writeLevel(out, rec.Level, levelName(rec.Level))
+		case 'S':
+			out.WriteString(strings.TrimPrefix(rec.Source, TrimSourcePrefix))
+		case 's':
+			slice := strings.Split(rec.Source, "/")
+			out.WriteString(slice[len(slice)-1])
+		case 'F':
+			if len(rec.file) > 0 {
+				out.WriteString(strings.TrimPrefix(rec.file, TrimSourcePrefix))
+				out.WriteByte(':')
+				out.WriteString(strconv.Itoa(rec.line))
+			} else {
+				out.WriteString(strings.TrimPrefix(rec.Source, TrimSourcePrefix))
 			}
-			if len(piece) > 1 {
-				out.Write(piece[1:])
+		case 'M':
+			out.WriteString(rec.Message)
+		case 'K':
+			out.WriteString(rec.recordFields().String())
+		case 'c':
+			out.WriteString(rec.context.String())
+		case 'A':
+			out.WriteString(rec.Fields.String())
+		case '+':
+			out.WriteByte('+')
+			out.WriteString(elapsed.String())
+		case 'O':
+			writeLogfmt(out, rec, created)
+		case 'g':
+			if rec.goid == 0 {
+				out.WriteByte('?')
+			} else {
+				out.WriteString(strconv.FormatUint(rec.goid, 10))
+			}
+		case 'P':
+			out.WriteString(processID)
+		case 'H':
+			out.WriteString(hostName)
+		default:
+			if fn := formatFunc(seg.verb); fn != nil {
+				out.WriteString(fn(rec))
 			}
-		} else if len(piece) > 0 {
-			out.Write(piece)
 		}
 	}
 	out.WriteByte('\n')
@@ -105,3 +393,65 @@ func FormatLogRecord(format string, rec *LogRecord) string {
 	return out.String()
 }
 
+// Write the label of a level, colored if asked for
+func (cf *CompiledFormat) writeLevel(out *bytes.Buffer, lvl Level, label string) {
+	if !cf.colorLevel || int(lvl) < 0 || int(lvl) >= len(ColorBytes) || ColorBytes[lvl] == nil {
+		out.WriteString(label)
+		return
+	}
+	out.Write(ColorBytes[lvl])
+	out.WriteString(label)
+	out.Write(ColorReset)
+}
+
+// Quote a logfmt value if it is empty or contains spaces, quotes, '=' or
+// control characters
+func logfmtValue(s string) string {
+	if len(s) == 0 {
+		return `""`
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == 0x7f || !strconv.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+// Make a logfmt key of s: keys cannot be quoted, so spaces, quotes, '=' and
+// control characters are replaced by '_'
+func logfmtKey(s string) string {
+	if len(s) == 0 {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == 0x7f || !strconv.IsPrint(r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// Write the record and its fields in logfmt, with the time of the record in
+// the location of created.  The level is the full name in lower case, e.g.
+// level=warning, as log pipelines expect.  The message is always quoted.
+func writeLogfmt(out *bytes.Buffer, rec *LogRecord, created time.Time) {
+	out.WriteString("time=")
+	out.WriteString(created.Format(time.RFC3339Nano))
+	out.WriteString(" level=")
+	out.WriteString(logfmtValue(strings.ToLower(levelName(rec.Level))))
+	out.WriteString(" source=")
+	out.WriteString(logfmtValue(rec.Source))
+	out.WriteString(" msg=")
+	if rec.parsed {
+		out.WriteString(strconv.Quote(rec.parsedMsg))
+	} else {
+		out.WriteString(strconv.Quote(rec.Message))
+	}
+	for _, f := range rec.Fields {
+		out.WriteByte(' ')
+		out.WriteString(logfmtKey(f.Key))
+		out.WriteByte('=')
+		out.WriteString(logfmtValue(fmt.Sprint(f.Value)))
+	}
+}
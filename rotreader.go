@@ -0,0 +1,126 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Reads a list of files one after the other, opening each only when the
+// previous one is read
+type rotatedReader struct {
+	names []string
+	file  *os.File
+	r     io.Reader // file, or its decompressed content
+}
+
+// OpenRotatedReader reads the log file basename after its rotated files
+// (basename.YYYY-MM-DD.NNN), oldest first, as one stream.  Rotated files
+// ending in ".gz" are decompressed.  The logger does not have to be running.
+// Returns an error if neither the log file nor a rotated file exists.
+func OpenRotatedReader(basename string) (io.ReadCloser, error) {
+	dir := filepath.Dir(basename)
+	prefix := filepath.Base(basename) + "."
+	fd, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := fd.Readdirnames(-1)
+	fd.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var rotated []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if _, ok := rotatedNumber(basename, strings.TrimSuffix(name, ".gz"), ""); ok {
+			rotated = append(rotated, name)
+		}
+	}
+	// The names sort by age, whether compressed or not
+	sort.Slice(rotated, func(i, j int) bool {
+		return strings.TrimSuffix(rotated[i], ".gz") < strings.TrimSuffix(rotated[j], ".gz")
+	})
+
+	r := &rotatedReader{}
+	for _, name := range rotated {
+		r.names = append(r.names, filepath.Join(dir, name))
+	}
+	if _, err := os.Stat(basename); err == nil {
+		r.names = append(r.names, basename)
+	} else if len(r.names) == 0 {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatedReader) Read(p []byte) (int, error) {
+	for {
+		if r.r == nil {
+			if len(r.names) == 0 {
+				return 0, io.EOF
+			}
+			if err := r.next(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		n, err := r.r.Read(p)
+		if err == io.EOF {
+			r.closeFile()
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Open the next file.  A file pruned since it was listed is skipped.
+func (r *rotatedReader) next() error {
+	name := r.names[0]
+	r.names = r.names[1:]
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	r.file, r.r = file, file
+	if strings.HasSuffix(name, ".gz") {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			r.file, r.r = nil, nil
+			return err
+		}
+		r.r = zr
+	}
+	return nil
+}
+
+// Close closes the file being read and skips the rest.
+func (r *rotatedReader) Close() error {
+	r.names = nil
+	return r.closeFile()
+}
+
+// Close the file being read
+func (r *rotatedReader) closeFile() error {
+	r.r = nil
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
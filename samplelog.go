@@ -0,0 +1,51 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"sync"
+)
+
+// This log writer thins out records below a level, e.g. DEBUG noise, by
+// passing only one of every N of them per level, starting with the first.
+// Records at or above the level are always passed.
+type SampleLogWriter struct {
+	w     LogWriter
+	every uint64
+	below Level
+
+	mu     sync.Mutex
+	counts map[Level]uint64 // records seen per level
+}
+
+// NewSampleWriter wraps w, passing one of every everyN records whose level
+// is below belowLevel.  everyN of 1 or less passes every record.
+func NewSampleWriter(w LogWriter, everyN int, belowLevel Level) *SampleLogWriter {
+	if everyN < 1 {
+		everyN = 1
+	}
+	return &SampleLogWriter{
+		w:      w,
+		every:  uint64(everyN),
+		below:  belowLevel,
+		counts: make(map[Level]uint64),
+	}
+}
+
+func (s *SampleLogWriter) LogWrite(rec *LogRecord) {
+	if rec.Level < s.below && s.every > 1 {
+		s.mu.Lock()
+		n := s.counts[rec.Level]
+		s.counts[rec.Level] = n + 1
+		s.mu.Unlock()
+		if n%s.every != 0 {
+			return
+		}
+	}
+	s.w.LogWrite(rec)
+}
+
+// Close closes the wrapped writer.
+func (s *SampleLogWriter) Close() {
+	s.w.Close()
+}
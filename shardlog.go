@@ -0,0 +1,109 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"container/list"
+	"sync"
+)
+
+// This log writer routes the records to a file per shard, e.g. per tenant or
+// per level, the shard of a record being named by a key function:
+//
+//	w := NewShardedFileLogWriter(
+//		func(rec *LogRecord) string { return rec.Level.String() },
+//		func(shard string) *FileLogWriter { return NewFileLogWriter("app."+shard+".log", false) })
+//
+// The FileLogWriter of a shard is made by the first record of the shard.
+type ShardedFileLogWriter struct {
+	key  func(rec *LogRecord) string
+	open func(shard string) *FileLogWriter
+
+	mu     sync.Mutex
+	max    int
+	shards map[string]*fileShard
+	lru    *list.List // shards with an open file, most recently written first
+}
+
+type fileShard struct {
+	w    *FileLogWriter
+	elem *list.Element // in the lru while the file is open
+}
+
+// NewShardedFileLogWriter writes each record with the writer open makes for
+// the shard key returns.  If open returns nil the records of the shard are
+// dropped until a later record makes it again.
+func NewShardedFileLogWriter(key func(rec *LogRecord) string, open func(shard string) *FileLogWriter) *ShardedFileLogWriter {
+	return &ShardedFileLogWriter{
+		key:    key,
+		open:   open,
+		shards: make(map[string]*fileShard),
+		lru:    list.New(),
+	}
+}
+
+// SetMaxOpenFiles caps the number of files open at once (chainable).  Over
+// the cap the file of the least recently written shard is closed, to be
+// reopened by the next record of the shard.  Zero, the default, means no
+// cap.
+func (s *ShardedFileLogWriter) SetMaxOpenFiles(max int) *ShardedFileLogWriter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.max = max
+	s.trim(max)
+	return s
+}
+
+// Close the files of the least recently written shards until keep are open
+func (s *ShardedFileLogWriter) trim(keep int) {
+	for s.max > 0 && s.lru.Len() > keep {
+		e := s.lru.Back()
+		s.lru.Remove(e)
+		sh := e.Value.(*fileShard)
+		sh.elem = nil
+		sh.w.release()
+	}
+}
+
+func (s *ShardedFileLogWriter) LogWrite(rec *LogRecord) {
+	name := s.key(rec)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh, ok := s.shards[name]
+	if ok && sh.elem != nil {
+		s.lru.MoveToFront(sh.elem)
+	} else {
+		// Make room for the file about to be opened
+		s.trim(s.max - 1)
+		if !ok {
+			w := s.open(name)
+			if w == nil {
+				return
+			}
+			sh = &fileShard{w: w}
+			s.shards[name] = sh
+		}
+		sh.elem = s.lru.PushFront(sh)
+	}
+	sh.w.LogWrite(rec)
+}
+
+// Flush writes the records buffered by the writers of the shards.
+func (s *ShardedFileLogWriter) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sh := range s.shards {
+		sh.w.Flush()
+	}
+}
+
+func (s *ShardedFileLogWriter) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sh := range s.shards {
+		sh.w.Close()
+	}
+	s.shards = make(map[string]*fileShard)
+	s.lru.Init()
+}
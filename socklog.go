@@ -3,10 +3,15 @@
 package log4go
 
 import (
+	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
-	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // This log writer sends output to a socket
@@ -14,9 +19,44 @@ type SocketLogWriter struct {
 	sock 	net.Conn
 	proto	string
 	hostport string
+
+	// Emit the source as file/line/func fields
+	structured bool
+
+	// Dial failures that retrying will not fix, e.g. unknown host
+	failures int
+	dropped  bool
+
+	// Gzip the stream of a TCP connection
+	compress bool
+	gz       *gzip.Writer
+	flushing bool // a flush of gz is scheduled
+
+	// Guards the options and the connection, which may change while
+	// records are written
+	mu sync.Mutex
 }
 
+// Number of permanent dial failures before a SocketLogWriter gives up
+const maxDialFailures = 3
+
+// Dial function, replaced in tests
+var netDial = net.Dial
+
+// Longest time a compressed record waits for more records before it is sent
+var compressFlushInterval = time.Second
+
 func (w *SocketLogWriter) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.intClose()
+}
+
+func (w *SocketLogWriter) intClose() {
+	if w.gz != nil {
+		w.gz.Close()
+		w.gz = nil
+	}
 	if w.sock != nil {
 		w.sock.Close()
 	}
@@ -31,34 +71,256 @@ func NewSocketLogWriter(proto, hostport string) *SocketLogWriter {
 	return s
 }
 
-func (s *SocketLogWriter) LogWrite(rec *LogRecord) {
+// Dial connects to the endpoint if not already connected.  The connection is
+// otherwise made lazily by the first log message.
+// Dialing UDP sends nothing, so it does not tell whether anyone listens.
+func (s *SocketLogWriter) Dial() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.intDial()
+}
 
-	// Marshall into JSON
-	js, err := json.Marshal(rec)
+func (s *SocketLogWriter) intDial() error {
+	if s.sock != nil {
+		return nil
+	}
+	sock, err := netDial(s.proto, s.hostport)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "SocketLogWriter(%s): %v\n", s.hostport, err)
+		return err
+	}
+	s.sock = sock
+	if s.compress {
+		if strings.HasPrefix(s.proto, "tcp") {
+			s.gz = gzip.NewWriter(sock)
+		} else {
+			fmt.Fprintf(stderr, "SocketLogWriter(%s): compression needs a stream, sending %s uncompressed\n", s.hostport, s.proto)
+		}
+	}
+	return nil
+}
+
+// Reports whether retrying the dial cannot succeed without a new endpoint
+func isPermanentDialError(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// Returned once the writer gave up on its endpoint
+var errSocketDropped = errors.New("endpoint unreachable, record dropped")
+
+// Record a failed dial.  After a few permanent failures the writer stops
+// trying and drops records until the endpoint is changed with SetOption.
+func (s *SocketLogWriter) dialFailed(err error) error {
+	if !isPermanentDialError(err) {
+		s.failures = 0
+		return err
+	}
+	s.failures++
+	if s.failures < maxDialFailures {
+		return err
+	}
+	s.dropped = true
+	fmt.Fprintf(stderr, "SocketLogWriter(%s): giving up on endpoint, dropping records: %v\n", s.hostport, err)
+	return errSocketDropped
+}
+
+// SetOption changes an option of the writer.  Known options are "endpoint"
+// and "protocol" (string) and "compress" (bool), which reconnect on the next
+// message, and "structured" (bool).  Options may be changed while records are
+// written.
+func (s *SocketLogWriter) SetOption(name string, v interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch name {
+	case "endpoint", "protocol":
+		str, ok := v.(string)
+		if !ok || len(str) == 0 {
+			return ErrBadValue
+		}
+		if name == "endpoint" {
+			s.hostport = str
+		} else {
+			s.proto = str
+		}
+		s.intClose()
+		s.sock = nil
+		s.failures, s.dropped = 0, false
+	case "compress":
+		compress, ok := v.(bool)
+		if !ok {
+			return ErrBadValue
+		}
+		if compress != s.compress {
+			s.compress = compress
+			s.intClose()
+			s.sock = nil
+		}
+	case "structured":
+		structured, ok := v.(bool)
+		if !ok {
+			return ErrBadValue
+		}
+		s.structured = structured
+	default:
+		return ErrBadOption
+	}
+	return nil
+}
+
+// GetOption returns the current value of an option; see SetOption.
+func (s *SocketLogWriter) GetOption(name string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch name {
+	case "endpoint":
+		return s.hostport, nil
+	case "protocol":
+		return s.proto, nil
+	case "compress":
+		return s.compress, nil
+	case "structured":
+		return s.structured, nil
+	}
+	return nil, ErrBadOption
+}
+
+// SetStructuredCaller changes whether the source is sent as a single string
+// or split into "file", "line" and "func" fields (chainable).  Must be called
+// before the first log message is written.
+func (s *SocketLogWriter) SetStructuredCaller(structured bool) *SocketLogWriter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.structured = structured
+	return s
+}
+
+// SetCompress gzips the records sent over a TCP connection (chainable), for
+// slow links to a collector.  Each connection carries one gzip stream of
+// JSON records, which the receiver reads with gzip.NewReader.  Records are
+// flushed within a second.  UDP cannot use stream compression: datagrams
+// are sent uncompressed.  Must be called before the first log message is
+// written.
+func (s *SocketLogWriter) SetCompress(compress bool) *SocketLogWriter {
+	s.SetOption("compress", compress)
+	return s
+}
+
+// Flush sends the records compressed so far.
+func (s *SocketLogWriter) Flush() {
+	s.flushCompressed()
+}
+
+// Flush the compressed records, scheduled after a write
+func (s *SocketLogWriter) flushCompressed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushing = false
+	if s.gz == nil {
 		return
 	}
+	if err := s.gz.Flush(); err != nil {
+		fmt.Fprintf(stderr, "SocketLogWriter(%s): %v\n", s.hostport, err)
+		s.gz = nil
+		s.sock.Close()
+		s.sock = nil
+	}
+}
 
-	if s.sock == nil {
-		s.sock, err = net.Dial(s.proto, s.hostport)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "SocketLogWriter(%s): %v\n", s.hostport, err)
-			if s.sock != nil {
-				s.sock.Close()
-				s.sock = nil
-			}
-			return
+// The source of a record split into its components
+type jsonCaller struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Func string `json:"func"`
+}
+
+// A LogRecord with a structured source, without its fields
+type jsonCallerRecord struct {
+	Level   Level
+	Created time.Time
+	Source  jsonCaller
+	Message string
+	Binary  []byte `json:",omitempty"`
+}
+
+// Split the record source into file, line and func.  Records with a manual
+// source only carry "func:line", so the file is left empty for them.
+func newJSONCaller(rec *LogRecord) jsonCaller {
+	if len(rec.fn) > 0 {
+		return jsonCaller{File: rec.file, Line: rec.line, Func: rec.fn}
+	}
+	c := jsonCaller{Func: rec.Source}
+	if i := strings.LastIndex(rec.Source, ":"); i >= 0 {
+		if line, err := strconv.Atoi(rec.Source[i+1:]); err == nil {
+			c.Func, c.Line = rec.Source[:i], line
 		}
 	}
+	return c
+}
+
+func (s *SocketLogWriter) marshal(rec *LogRecord) ([]byte, error) {
+	if !s.structured {
+		return json.Marshal(rec)
+	}
+	js, err := json.Marshal(&jsonCallerRecord{
+		Level:   rec.Level,
+		Created: rec.Created,
+		Source:  newJSONCaller(rec),
+		Message: rec.Message,
+		Binary:  rec.Binary,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return appendJSONFields(js, rec.Fields)
+}
+
+func (s *SocketLogWriter) LogWrite(rec *LogRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.intLogWrite(rec); err != nil && err != errSocketDropped {
+		fmt.Fprintf(stderr, "SocketLogWriter(%s): %v\n", s.hostport, err)
+	}
+}
 
-	_, err = s.sock.Write(js)
+// LogWriteErr sends the record like LogWrite, but returns any error instead
+// of printing it.
+func (s *SocketLogWriter) LogWriteErr(rec *LogRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.intLogWrite(rec)
+}
+
+func (s *SocketLogWriter) intLogWrite(rec *LogRecord) error {
+	if s.dropped {
+		return errSocketDropped
+	}
+
+	// Marshall into JSON
+	js, err := s.marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	if err = s.intDial(); err != nil {
+		return s.dialFailed(err)
+	}
+	s.failures = 0
+
+	if s.gz != nil {
+		_, err = s.gz.Write(js)
+		if err == nil && !s.flushing {
+			s.flushing = true
+			time.AfterFunc(compressFlushInterval, s.flushCompressed)
+		}
+	} else {
+		_, err = s.sock.Write(js)
+	}
 	if err == nil {
-		return
+		return nil
 	}
 
-	fmt.Fprintf(os.Stderr, "SocketLogWriter(%s): %v\n", s.hostport, err)
+	s.gz = nil
 	s.sock.Close()
 	s.sock = nil
+	return err
 }
-
@@ -0,0 +1,113 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Number of sources a rate limiter keeps track of.  Beyond it the least
+// recently seen source is forgotten and starts with a full bucket again.
+const maxRateSources = 1000
+
+// The token bucket of a source
+type sourceBucket struct {
+	source  string
+	tokens  float64
+	last    time.Time
+	dropped int
+}
+
+// Limits the records of every source to a rate, with token buckets kept in
+// a least recently used list
+type sourceLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // records per second, also the burst
+	buckets map[string]*list.Element
+	lru     *list.List // *sourceBucket, most recently seen first
+}
+
+func newSourceLimiter(perSecond int) *sourceLimiter {
+	return &sourceLimiter{
+		rate:    float64(perSecond),
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Take a token for a record of source.  Returns whether the record may be
+// written and, if so, how many records of the source were dropped since the
+// last one written.
+func (l *sourceLimiter) allow(source string, now time.Time) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var b *sourceBucket
+	if e, ok := l.buckets[source]; ok {
+		l.lru.MoveToFront(e)
+		b = e.Value.(*sourceBucket)
+		b.tokens += now.Sub(b.last).Seconds() * l.rate
+		if b.tokens > l.rate {
+			b.tokens = l.rate
+		}
+		b.last = now
+	} else {
+		b = &sourceBucket{source: source, tokens: l.rate, last: now}
+		l.buckets[source] = l.lru.PushFront(b)
+		for l.lru.Len() > maxRateSources {
+			e := l.lru.Back()
+			l.lru.Remove(e)
+			delete(l.buckets, e.Value.(*sourceBucket).source)
+		}
+	}
+
+	if b.tokens < 1 {
+		b.dropped++
+		return false, 0
+	}
+	b.tokens--
+	dropped := b.dropped
+	b.dropped = 0
+	return true, dropped
+}
+
+// Holds the limiter of SetPerSourceRate, which may be nil
+type rateLimit struct {
+	limiter *sourceLimiter
+}
+
+// SetPerSourceRate caps the records each source may log to perSecond per
+// second, in bursts of up to perSecond, so that one misbehaving package
+// cannot flood the log.  The source is the caller, or the source given to
+// Log.  Records above the rate are dropped; the next record written for the
+// source is preceded by a WARNING "source X dropped N records".  Zero
+// removes the cap.
+func (log Logger) SetPerSourceRate(perSecond int) {
+	var l rateLimit
+	if perSecond > 0 {
+		l.limiter = newSourceLimiter(perSecond)
+	}
+	log.state().rate.Store(l)
+}
+
+// Check the record against the per source rate.  Returns false if it must be
+// dropped, or a summary of the records dropped before it, if any.
+func (st *loggerState) limitRate(rec *LogRecord) (bool, *LogRecord) {
+	l, _ := st.rate.Load().(rateLimit)
+	if l.limiter == nil {
+		return true, nil
+	}
+	ok, dropped := l.limiter.allow(rec.Source, rec.Created)
+	if !ok || dropped == 0 {
+		return ok, nil
+	}
+	return true, &LogRecord{
+		Level:   WARNING,
+		Created: rec.Created,
+		Source:  rec.Source,
+		Message: fmt.Sprintf("source %s dropped %d records", rec.Source, dropped),
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Suffix of the spool files while they are written
+const spoolTempSuffix = ".tmp"
+
+// Rename function, replaced in tests
+var renameFile = os.Rename
+
+// This log writer writes each record to a file of its own in a spool
+// directory, for ingestion pipelines which consume one file per event.  The
+// files are named after the creation time and a sequence number, so they sort
+// by age.
+type SpoolLogWriter struct {
+	mu sync.Mutex
+
+	dir      string
+	ext      string
+	format   string
+	compiled *CompiledFormat
+	direct   bool // write the final names without a temporary file
+
+	seq      uint64
+	maxfiles int
+	files    []string // spooled files, oldest first; nil until listed
+}
+
+// NewSpoolLogWriter writes the records to files in dir, which is created if
+// needed.
+func NewSpoolLogWriter(dir string) *SpoolLogWriter {
+	return &SpoolLogWriter{
+		dir:    dir,
+		ext:    ".log",
+		format: "[%D %T] [%L] (%S) %M",
+	}
+}
+
+// Set the logging format (chainable).  Must be called before the first log
+// message is written.
+func (w *SpoolLogWriter) SetFormat(format string) *SpoolLogWriter {
+	noteFormat(format)
+	w.format = format
+	return w
+}
+
+// Set the extension of the file names, ".log" by default (chainable).  Must
+// be called before the first log message is written.
+func (w *SpoolLogWriter) SetExtension(ext string) *SpoolLogWriter {
+	w.ext = ext
+	return w
+}
+
+// SetAtomic sets whether each file is written under a ".tmp" suffix and then
+// renamed to its final name, so that a watcher of the directory never sees a
+// partial file (chainable).  On by default.  Must be called before the first
+// log message is written.
+func (w *SpoolLogWriter) SetAtomic(atomic bool) *SpoolLogWriter {
+	w.direct = !atomic
+	return w
+}
+
+// SetMaxFiles caps the number of files in the directory (chainable): the
+// oldest files above the cap are deleted.  Files consumed by a watcher are
+// counted until then.  Zero, the default, means no cap.  Must be called before
+// the first log message is written.
+func (w *SpoolLogWriter) SetMaxFiles(max int) *SpoolLogWriter {
+	w.maxfiles = max
+	return w
+}
+
+// List the spooled files already in the directory, oldest first
+func (w *SpoolLogWriter) listFiles() []string {
+	files := []string{}
+	fd, err := os.Open(w.dir)
+	if err != nil {
+		return files
+	}
+	names, _ := fd.Readdirnames(-1)
+	fd.Close()
+	for _, name := range names {
+		if strings.HasSuffix(name, w.ext) {
+			files = append(files, filepath.Join(w.dir, name))
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+func (w *SpoolLogWriter) LogWrite(rec *LogRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.files == nil {
+		if err := os.MkdirAll(w.dir, 0755); err != nil {
+			fmt.Fprintf(stderr, "SpoolLogWriter(%q): %s\n", w.dir, err)
+			return
+		}
+		w.files = w.listFiles()
+	}
+
+	w.seq++
+	name := filepath.Join(w.dir, fmt.Sprintf("%s-%06d%s",
+		rec.Created.UTC().Format("20060102T150405.000000000"), w.seq, w.ext))
+	w.compiled = compiledFor(w.compiled, w.format, nil)
+	if err := w.writeFile(name, []byte(w.compiled.Format(rec))); err != nil {
+		fmt.Fprintf(stderr, "SpoolLogWriter(%q): %s\n", w.dir, err)
+		return
+	}
+
+	w.files = append(w.files, name)
+	for w.maxfiles > 0 && len(w.files) > w.maxfiles {
+		if err := os.Remove(w.files[0]); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(stderr, "SpoolLogWriter(%q): %s\n", w.dir, err)
+		}
+		w.files = w.files[1:]
+	}
+}
+
+// Write a spool file, through a temporary file unless direct
+func (w *SpoolLogWriter) writeFile(name string, data []byte) error {
+	if w.direct {
+		return ioutil.WriteFile(name, data, 0660)
+	}
+	tmp := name + spoolTempSuffix
+	if err := ioutil.WriteFile(tmp, data, 0660); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := renameFile(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (w *SpoolLogWriter) Close() {
+}
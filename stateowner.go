@@ -0,0 +1,35 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build go1.24
+// +build go1.24
+
+package log4go
+
+import (
+	"runtime"
+	"unsafe"
+	"weak"
+)
+
+// Refers weakly to the map of a Logger, so that a Logger no longer used is
+// collected along with its state
+type stateOwner struct {
+	ref weak.Pointer[byte]
+}
+
+func newStateOwner(p unsafe.Pointer) stateOwner {
+	return stateOwner{ref: weak.Make((*byte)(p))}
+}
+
+// Reports whether the state belongs to the map at p, and not to a collected
+// map whose address was reused
+func (o stateOwner) is(p unsafe.Pointer) bool {
+	return unsafe.Pointer(o.ref.Value()) == p
+}
+
+// Remove the state from the index once the map at p is collected
+func dropWithOwner(p unsafe.Pointer, st *loggerState) {
+	runtime.AddCleanup((*byte)(p), func(st *loggerState) {
+		loggerStates.CompareAndDelete(st.key, st)
+	}, st)
+}
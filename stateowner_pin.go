@@ -0,0 +1,27 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build !go1.24
+// +build !go1.24
+
+package log4go
+
+import "unsafe"
+
+// Keeps the map of a Logger referenced, so that its address cannot be reused
+// by another Logger.  Without weak pointers (Go 1.24) the state of a Logger,
+// and the Logger, are kept for the life of the program.
+type stateOwner struct {
+	p unsafe.Pointer
+}
+
+func newStateOwner(p unsafe.Pointer) stateOwner {
+	return stateOwner{p: p}
+}
+
+// Reports whether the state belongs to the map at p
+func (o stateOwner) is(p unsafe.Pointer) bool {
+	return o.p == p
+}
+
+func dropWithOwner(p unsafe.Pointer, st *loggerState) {
+}
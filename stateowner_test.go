@@ -0,0 +1,38 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build go1.24
+// +build go1.24
+
+package log4go
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestLoggerStateCollected(t *testing.T) {
+	states := func() (n int) {
+		loggerStates.Range(func(_, _ interface{}) bool {
+			n++
+			return true
+		})
+		return n
+	}
+	before := states()
+	for i := 0; i < 100; i++ {
+		l := Logger{"mem": NewSyncFilter(INFO, NewMemoryLogWriter())}
+		l.SetCallerSkip(DefaultCallerSkip)
+		l.Info("logged")
+		l.Clone().Info("logged")
+	}
+
+	// The cleanups run in the background after a collection
+	for i := 0; i < 50 && states() > before; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := states() - before; n > 0 {
+		t.Errorf("%d states of unused loggers kept", n)
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package log4go
+
+import (
+	"fmt"
+	"log/syslog"
+	"os"
+	"strings"
+)
+
+// Facilities by their names in configurations
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// This log writer sends output to syslog, framed by the log/syslog package.
+// The levels map to syslog severities as by Level.ToSyslogSeverity, and to
+// the LOG_USER facility unless set otherwise.  The format renders the
+// message only; syslog adds the header.
+type SyslogLogWriter struct {
+	network, raddr, tag string
+
+	// A connection for every facility used, as log/syslog fixes the
+	// facility of a connection
+	facility   syslog.Priority
+	facilities map[Level]syslog.Priority
+	w          map[syslog.Priority]*syslog.Writer
+
+	// The logging format
+	format   string
+	compiled *CompiledFormat
+}
+
+// NewSyslogLogWriter connects to the syslog daemon at raddr over network
+// ("udp", "tcp" or "unix"), or to the local daemon if network is empty, and
+// tags the messages with tag (the program name if empty).  Returns nil if the
+// daemon cannot be reached.
+func NewSyslogLogWriter(network, raddr, tag string) *SyslogLogWriter {
+	w, err := syslog.Dial(network, raddr, syslog.LOG_USER|syslog.LOG_INFO, tag)
+	if err != nil {
+		fmt.Fprintf(stderr, "SyslogLogWriter(%s): %s\n", raddr, err)
+		return nil
+	}
+	return &SyslogLogWriter{
+		network:  network,
+		raddr:    raddr,
+		tag:      tag,
+		facility: syslog.LOG_USER,
+		w:        map[syslog.Priority]*syslog.Writer{syslog.LOG_USER: w},
+		format:   "(%S) %M",
+	}
+}
+
+// Set the facility of the levels without a facility of their own, one of the
+// log/syslog facilities, e.g. int(syslog.LOG_LOCAL0) (chainable).  Must be
+// called before the first log message is written.
+func (s *SyslogLogWriter) SetFacility(facility int) *SyslogLogWriter {
+	if s.dial(syslog.Priority(facility)) {
+		s.facility = syslog.Priority(facility)
+	}
+	return s
+}
+
+// Set the facility of the records of level lvl, one of the log/syslog
+// facilities, e.g. int(syslog.LOG_AUTH) for security events (chainable).
+// Must be called before the first log message is written.
+func (s *SyslogLogWriter) SetFacilityForLevel(lvl Level, facility int) *SyslogLogWriter {
+	if s.dial(syslog.Priority(facility)) {
+		if s.facilities == nil {
+			s.facilities = make(map[Level]syslog.Priority)
+		}
+		s.facilities[lvl] = syslog.Priority(facility)
+	}
+	return s
+}
+
+// Connect for facility if not connected yet.  Returns false if the facility
+// cannot be used.
+func (s *SyslogLogWriter) dial(facility syslog.Priority) bool {
+	if facility < 0 || facility > syslog.LOG_LOCAL7 || facility&7 != 0 {
+		fmt.Fprintf(stderr, "SyslogLogWriter(%s): invalid facility %d\n", s.raddr, facility)
+		return false
+	}
+	if _, ok := s.w[facility]; ok {
+		return true
+	}
+	w, err := syslog.Dial(s.network, s.raddr, facility|syslog.LOG_INFO, s.tag)
+	if err != nil {
+		fmt.Fprintf(stderr, "SyslogLogWriter(%s): %s\n", s.raddr, err)
+		return false
+	}
+	s.w[facility] = w
+	return true
+}
+
+// Set the format of the message (chainable).  Must be called before the
+// first log message is written.
+func (s *SyslogLogWriter) SetFormat(format string) *SyslogLogWriter {
+	noteFormat(format)
+	s.format = format
+	return s
+}
+
+func (s *SyslogLogWriter) LogWrite(rec *LogRecord) {
+	s.compiled = compiledFor(s.compiled, s.format, nil)
+	msg := strings.TrimSuffix(s.compiled.Format(rec), "\n")
+
+	facility, ok := s.facilities[rec.Level]
+	if !ok {
+		facility = s.facility
+	}
+	w := s.w[facility]
+
+	var err error
+	switch rec.Level.ToSyslogSeverity() {
+	case syslogCrit:
+		err = w.Crit(msg)
+	case syslogErr:
+		err = w.Err(msg)
+	case syslogWarning:
+		err = w.Warning(msg)
+	case syslogInfo:
+		err = w.Info(msg)
+	default:
+		err = w.Debug(msg)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "SyslogLogWriter: %s\n", err)
+	}
+}
+
+func (s *SyslogLogWriter) Close() {
+	for _, w := range s.w {
+		w.Close()
+	}
+}
+
+func propToSyslogLogWriter(filename string, props []kvProperty, enabled bool) (LogWriter, bool) {
+	network, address, tag := "", "", ""
+	format := "(%S) %M"
+	facility := syslog.LOG_USER
+	facilities := make(map[Level]syslog.Priority)
+	good := true
+
+	// Parse properties
+	for _, prop := range props {
+		switch prop.Name {
+		case "network":
+			network = strings.Trim(prop.Value, " \r\n")
+		case "address":
+			address = strings.Trim(prop.Value, " \r\n")
+		case "tag":
+			tag = strings.Trim(prop.Value, " \r\n")
+		case "format":
+			format = strings.Trim(prop.Value, " \r\n")
+		case "facility":
+			f, ok := syslogFacilities[strings.ToLower(strings.Trim(prop.Value, " \r\n"))]
+			if !ok {
+				fmt.Fprintf(os.Stderr, "LoadConfig: Error: Unknown facility %q for syslog filter in %s\n", prop.Value, filename)
+				good = false
+				continue
+			}
+			facility = f
+		default:
+			// facility.LEVEL, e.g. facility.WARNING, routes a level
+			name := strings.TrimPrefix(prop.Name, "facility.")
+			if name == prop.Name {
+				fmt.Fprintf(os.Stderr, "LoadConfig: Warning: Unknown property \"%s\" for syslog filter in %s\n", prop.Name, filename)
+				continue
+			}
+			lvl, ok := levelByName(name)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "LoadConfig: Error: Unknown level %q in property \"%s\" for syslog filter in %s\n", name, prop.Name, filename)
+				good = false
+				continue
+			}
+			f, ok := syslogFacilities[strings.ToLower(strings.Trim(prop.Value, " \r\n"))]
+			if !ok {
+				fmt.Fprintf(os.Stderr, "LoadConfig: Error: Unknown facility %q for syslog filter in %s\n", prop.Value, filename)
+				good = false
+				continue
+			}
+			facilities[lvl] = f
+		}
+	}
+	checkFormat(filename, "syslog", format)
+
+	// If it's disabled, we're just checking syntax
+	if !good || !enabled {
+		return nil, good
+	}
+
+	slw := NewSyslogLogWriter(network, address, tag)
+	if slw == nil {
+		return nil, false
+	}
+	slw.SetFormat(format)
+	if facility != syslog.LOG_USER {
+		slw.SetFacility(int(facility))
+	}
+	for lvl, f := range facilities {
+		slw.SetFacilityForLevel(lvl, int(f))
+	}
+	return slw, true
+}
@@ -0,0 +1,125 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package log4go
+
+import (
+	"io"
+	"io/ioutil"
+	"log/syslog"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogLogWriter(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer conn.Close()
+
+	l := make(Logger)
+	l.ConfigToLogWriter("test", &Config{Filters: []kvFilter{{
+		Enabled: "true",
+		Tag:     "syslog",
+		Level:   "FINEST",
+		Type:    "syslog",
+		Properties: []kvProperty{
+			{Name: "network", Value: "udp"},
+			{Name: "address", Value: conn.LocalAddr().String()},
+			{Name: "tag", Value: "myapp"},
+			{Name: "format", Value: "%M"},
+			{Name: "async", Value: "false"},
+		},
+	}}})
+	defer l.Close()
+
+	tests := []struct {
+		lvl      Level
+		priority string // LOG_USER (8) + severity
+	}{
+		{CRITICAL, "<10>"},
+		{ERROR, "<11>"},
+		{WARNING, "<12>"},
+		{INFO, "<14>"},
+		{TRACE, "<15>"},
+		{FINEST, "<15>"},
+	}
+	buf := make([]byte, 1024)
+	for _, test := range tests {
+		l.Log(test.lvl, "source", "message at "+test.lvl.String())
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("%v: read: %s", test.lvl, err)
+		}
+		// <PRI>TIMESTAMP HOSTNAME TAG[PID]: MSG
+		re := regexp.MustCompile(`^` + test.priority + `\S+ \S+ myapp\[\d+\]: message at ` + test.lvl.String() + `\n$`)
+		if !re.Match(buf[:n]) {
+			t.Errorf("%v: got %q, want priority %s", test.lvl, buf[:n], test.priority)
+		}
+	}
+}
+
+func TestSyslogFacilityForLevel(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer conn.Close()
+
+	l := make(Logger)
+	l.ConfigToLogWriter("test", &Config{Filters: []kvFilter{{
+		Enabled: "true",
+		Tag:     "syslog",
+		Level:   "FINEST",
+		Type:    "syslog",
+		Properties: []kvProperty{
+			{Name: "network", Value: "udp"},
+			{Name: "address", Value: conn.LocalAddr().String()},
+			{Name: "format", Value: "%M"},
+			{Name: "async", Value: "false"},
+			{Name: "facility", Value: "local0"},
+			{Name: "facility.CRITICAL", Value: "auth"},
+		},
+	}}})
+	defer l.Close()
+	l["syslog"].LogWriter.(*SyslogLogWriter).SetFacilityForLevel(ERROR, int(syslog.LOG_DAEMON))
+
+	tests := []struct {
+		lvl      Level
+		priority string // facility + severity
+	}{
+		{CRITICAL, "<34>"}, // LOG_AUTH (32) + 2
+		{ERROR, "<27>"},    // LOG_DAEMON (24) + 3
+		{WARNING, "<132>"}, // LOG_LOCAL0 (128) + 4
+		{INFO, "<134>"},    // LOG_LOCAL0 (128) + 6
+	}
+	buf := make([]byte, 1024)
+	for _, test := range tests {
+		l.Log(test.lvl, "source", "message")
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("%v: read: %s", test.lvl, err)
+		}
+		if !strings.HasPrefix(string(buf[:n]), test.priority) {
+			t.Errorf("%v: got %q, want priority %s", test.lvl, buf[:n], test.priority)
+		}
+	}
+
+	w := NewSyslogLogWriter("udp", conn.LocalAddr().String(), "")
+	defer w.Close()
+	defer func(w io.Writer) { stderr = w }(stderr)
+	stderr = ioutil.Discard
+	if w.SetFacilityForLevel(ERROR, 3); w.facilities[ERROR] != 0 || len(w.w) != 1 {
+		t.Errorf("invalid facility accepted")
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var stdout io.Writer = os.Stdout
@@ -13,6 +16,24 @@ var stdout io.Writer = os.Stdout
 var isColorful = (os.Getenv("TERM") != "" && os.Getenv("TERM") != "dumb") ||
 	 os.Getenv("ConEmuANSI") == "ON"
 
+// Reports whether w is a terminal, replaced in tests
+var isTerminal = terminalWriter
+
+// Decide whether to color the output written to w.  Following the
+// conventions of https://no-color.org and FORCE_COLOR, the environment wins:
+// FORCE_COLOR (any value) turns color on, else NO_COLOR (any value) turns it
+// off.  Otherwise the color asked for is used only if w is a terminal which
+// supports it.
+func colorDecision(color bool, w io.Writer) bool {
+	if _, ok := os.LookupEnv("FORCE_COLOR"); ok {
+		return true
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	return color && isColorful && isTerminal(w)
+}
+
 // 0, Black; 1, Red; 2, Green; 3, Yellow; 4, Blue; 5, Purple; 6, Cyan; 7, White
 var ColorBytes = [...][]byte{
 	[]byte("\x1b[0;34m"),	   // FINEST, Blue
@@ -31,6 +52,27 @@ type ConsoleLogWriter struct {
 	out		io.Writer
 	color 	bool	
 	format 	string
+	compiled	*CompiledFormat
+	loc	*time.Location
+	formatter	Formatter
+
+	// Written before the first record and on Close
+	header, trailer string
+	started	bool
+	mu	sync.Mutex	// guards started and the writing
+
+	// Stream of the records at or above errLevel, if set
+	errOut   io.Writer
+	errLevel Level
+
+	// Color decided by colorDecision on the first record
+	colored bool
+
+	// Queue of the records written by a goroutine, set by SetAsync
+	queue    chan *LogRecord
+	done     chan struct{}
+	blocking bool
+	dropped  uint64 // records dropped while the queue was full
 }
 
 // This creates a new ConsoleLogWriter
@@ -39,11 +81,16 @@ func NewConsoleLogWriter() *ConsoleLogWriter {
 		out:	stdout,
 		color:	false,
 		format: "[%T %D %Z] [%L] (%S) %M",
+		errLevel:	WARNING,
 	}
 	return c
 }
 
-// Must be called before the first log message is written.
+// Color the level of the records, rendered by %L and %l, by severity
+// (chainable).  Only output to a color terminal is colored, unless the
+// FORCE_COLOR environment variable is set; NO_COLOR turns color off.  The
+// output of a Formatter is never colored.  Must be called before the first
+// log message is written.
 func (c *ConsoleLogWriter) SetColor(color bool) *ConsoleLogWriter {
 	c.color = color
 	return c
@@ -52,17 +99,148 @@ func (c *ConsoleLogWriter) SetColor(color bool) *ConsoleLogWriter {
 // Set the logging format (chainable).  Must be called before the first log
 // message is written.
 func (c *ConsoleLogWriter) SetFormat(format string) *ConsoleLogWriter {
+	noteFormat(format)
 	c.format = format
 	return c
 }
 
+// SetErrStream writes the records at or above the level of SetErrLevel,
+// WARNING by default, to w instead of stdout (chainable), e.g. os.Stderr.
+// Both streams get the same format.  nil, the default, writes every record to
+// stdout.  Must be called before the first log message is written.
+func (c *ConsoleLogWriter) SetErrStream(w io.Writer) *ConsoleLogWriter {
+	c.errOut = w
+	return c
+}
+
+// SetErrLevel sets the lowest level written to the stream of SetErrStream
+// (chainable).  Must be called before the first log message is written.
+func (c *ConsoleLogWriter) SetErrLevel(lvl Level) *ConsoleLogWriter {
+	c.errLevel = lvl
+	return c
+}
+
+// SetTimezone renders the times of the records in loc (chainable), e.g.
+// America/New_York for a report read in the US.  nil, the default, renders
+// them in the location they were created in.
+func (c *ConsoleLogWriter) SetTimezone(loc *time.Location) *ConsoleLogWriter {
+	c.loc = loc
+	return c
+}
+
+// SetFormatter renders the records with f instead of the format string
+// (chainable).  Records f fails to render are reported and dropped.  nil
+// returns to the format string.  Must be called before the first log message
+// is written.
+func (c *ConsoleLogWriter) SetFormatter(f Formatter) *ConsoleLogWriter {
+	c.formatter = f
+	return c
+}
+
+// Set the header and footer (chainable).  These are formats like the one of
+// SetFormat, rendered with the current time: the header is written before the
+// first record and the footer on Close, if anything was written.  Must be
+// called before the first log message is written.
+func (c *ConsoleLogWriter) SetHeadFoot(head, foot string) *ConsoleLogWriter {
+	c.header, c.trailer = head, foot
+	return c
+}
+
+// SetAsync writes the records in a goroutine behind a queue of bufSize
+// records (chainable), so that a slow stdout, e.g. a pipe, does not stall the
+// logging.  Records logged while the queue is full are dropped and counted,
+// unless SetBlocking is set.  Close writes the queued records.  Must be called
+// before the first log message is written.
+func (c *ConsoleLogWriter) SetAsync(bufSize int) *ConsoleLogWriter {
+	if c.queue != nil {
+		return c
+	}
+	if bufSize <= 0 {
+		bufSize = DefaultBufferLength
+	}
+	c.queue, c.done = make(chan *LogRecord, bufSize), make(chan struct{})
+	go func() {
+		defer close(c.done)
+		for rec := range c.queue {
+			c.write(rec)
+		}
+	}()
+	return c
+}
+
+// SetBlocking makes logging wait while the queue of SetAsync is full instead
+// of dropping the record (chainable).  Must be called before the first log
+// message is written.
+func (c *ConsoleLogWriter) SetBlocking(blocking bool) *ConsoleLogWriter {
+	c.blocking = blocking
+	return c
+}
+
+// Dropped returns the number of records dropped because the queue of
+// SetAsync was full.
+func (c *ConsoleLogWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
 func (c *ConsoleLogWriter) Close() {
+	if c.queue != nil {
+		close(c.queue)
+		<-c.done
+		c.queue = nil
+		if n := c.Dropped(); n > 0 {
+			fmt.Fprintf(stderr, "ConsoleLogWriter: %d records dropped, the queue was full\n", n)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.started && len(c.trailer) > 0 {
+		fmt.Fprint(c.out, FormatLogRecord(c.trailer, &LogRecord{Created: time.Now()}))
+	}
 }
 
 func (c *ConsoleLogWriter) LogWrite(rec *LogRecord) {
-	if c.color {
-		c.out.Write(ColorBytes[rec.Level])
-		defer c.out.Write(ColorReset)
+	if c.queue == nil {
+		c.write(rec)
+		return
+	}
+	if c.blocking {
+		c.queue <- rec
+		return
+	}
+	select {
+	case c.queue <- rec:
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+	}
+}
+
+func (c *ConsoleLogWriter) write(rec *LogRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.started {
+		c.started = true
+		c.colored = colorDecision(c.color, c.out)
+		if len(c.header) > 0 {
+			fmt.Fprint(c.out, FormatLogRecord(c.header, &LogRecord{Created: time.Now()}))
+		}
+	}
+	w := c.out
+	if c.errOut != nil && rec.Level >= c.errLevel {
+		w = c.errOut
+	}
+	var out []byte
+	if c.formatter != nil {
+		var err error
+		if out, err = c.formatter(rec); err != nil {
+			fmt.Fprintf(stderr, "ConsoleLogWriter: %s\n", err)
+			return
+		}
+	}
+	if c.formatter != nil {
+		w.Write(out)
+		return
 	}
-	fmt.Fprint(c.out, FormatLogRecord(c.format, rec))
+	c.compiled = compiledFor(c.compiled, c.format, c.loc)
+	c.compiled.colorLevel = c.colored
+	fmt.Fprint(w, c.compiled.Format(rec))
 }
@@ -0,0 +1,14 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build windows
+// +build windows
+
+package log4go
+
+import "io"
+
+// The Windows console may not interpret escape sequences, so it is never
+// taken for a color terminal; FORCE_COLOR still turns color on
+func terminalWriter(w io.Writer) bool {
+	return false
+}
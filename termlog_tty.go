@@ -0,0 +1,21 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+//go:build !windows
+// +build !windows
+
+package log4go
+
+import (
+	"io"
+	"os"
+)
+
+// Reports whether w is a terminal, i.e. a character device like a tty
+func terminalWriter(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
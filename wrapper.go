@@ -5,10 +5,12 @@ package log4go
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"runtime"
 	"path/filepath"
+	"time"
 )
 
 var (
@@ -30,16 +32,53 @@ func LoadConfigBuf(filename string, buf []byte) {
 	Global.LoadConfigBuf(filename, buf)
 }
 
+// Wrapper for (*Logger).LoadConfigReader
+func LoadConfigReader(r io.Reader, format string) error {
+	return Global.LoadConfigReader(r, format)
+}
+
 // Wrapper for (*Logger).AddFilter
 func AddFilter(name string, lvl Level, writer LogWriter) {
 	Global.AddFilter(name, lvl, writer)
 }
 
+// Wrapper for (*Logger).Flush
+func Flush() {
+	Global.Flush()
+}
+
 // Wrapper for (*Logger).Close (closes and removes all logwriters)
 func Close() {
 	Global.Close()
 }
 
+// New creates a Logger which writes every message to out like log.New of the
+// standard library.  The writer is kept under the "out" filter, so the prefix
+// and flags can be changed later:
+//
+//	l["out"].LogWriter.(*OutLogWriter).SetFlags(log.LstdFlags | log.Lshortfile)
+func New(out io.Writer, prefix string, flag int) Logger {
+	return Logger{
+		"out": NewFilter(FINEST, NewOutLogWriter(out, prefix, flag)),
+	}
+}
+
+// Close the global logger, so that the last record reaches every writer
+// before the program exits.  A writer stuck e.g. on a slow socket is given up
+// after ExitCloseTimeout.
+func closeBeforeExit() {
+	done := make(chan struct{})
+	go func() {
+		Global.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(ExitCloseTimeout):
+		fmt.Fprintf(stderr, "log4go: writers not closed after %s, exiting anyway\n", ExitCloseTimeout)
+	}
+}
+
 // Compatibility with `log`
 func compat(lvl Level, calldepth int, args ...interface{}) {
 	// Determine caller func
@@ -57,10 +96,10 @@ func compat(lvl Level, calldepth int, args ...interface{}) {
 
 	Global.Log(lvl, src, msg)
 	if lvl == ERROR {
-		Global.Close()
+		closeBeforeExit()
 		os.Exit(0)
 	} else if lvl == CRITICAL {
-		Global.Close()
+		closeBeforeExit()
 		panic(msg)
 	}
 }
@@ -78,64 +117,64 @@ func compatf(lvl Level, calldepth int, format string, args ...interface{}) {
 
 	Global.Log(lvl, src, msg)
 	if lvl == ERROR {
-		Global.Close()
+		closeBeforeExit()
 		os.Exit(0)
 	} else if lvl == CRITICAL {
-		Global.Close()
+		closeBeforeExit()
 		panic(msg)
 	}
 }
 
 func Crash(args ...interface{}) {
-	compat(CRITICAL, DefaultCallerSkip, args ...)
+	compat(CRITICAL, Global.callerSkip(), args ...)
 }
 
 // Logs the given message and crashes the program
 func Crashf(format string, args ...interface{}) {
-	compatf(CRITICAL, DefaultCallerSkip, format, args ...)
+	compatf(CRITICAL, Global.callerSkip(), format, args ...)
 }
 
 // Compatibility with `log`
 func Exit(args ...interface{}) {
-	compat(ERROR, DefaultCallerSkip, args ...)
+	compat(ERROR, Global.callerSkip(), args ...)
 }
 
 // Compatibility with `log`
 func Exitf(format string, args ...interface{}) {
-	compatf(ERROR, DefaultCallerSkip, format, args ...)
+	compatf(ERROR, Global.callerSkip(), format, args ...)
 }
 
 // Compatibility with `log`
 func Stderr(args ...interface{}) {
-	compat(WARNING, DefaultCallerSkip, args ...)
+	compat(WARNING, Global.callerSkip(), args ...)
 }
 
 // Compatibility with `log`
 func Stderrf(format string, args ...interface{}) {
-	compatf(WARNING, DefaultCallerSkip, format, args ...)
+	compatf(WARNING, Global.callerSkip(), format, args ...)
 }
 
 // Compatibility with `log`
 func Stdout(args ...interface{}) {
-	compat(INFO, DefaultCallerSkip, args ...)
+	compat(INFO, Global.callerSkip(), args ...)
 }
 
 // Compatibility with `log`
 func Stdoutf(format string, args ...interface{}) {
-	compatf(INFO, DefaultCallerSkip, format, args ...)
+	compatf(INFO, Global.callerSkip(), format, args ...)
 }
 
 // Compatibility with `log`
 func Fatal(v ...interface{}) {
-	compat(ERROR, DefaultCallerSkip, v ...)
+	compat(ERROR, Global.callerSkip(), v ...)
 }
 
 func Fatalf(format string, v ...interface{}) {
-	compatf(ERROR, DefaultCallerSkip, format, v ...)
+	compatf(ERROR, Global.callerSkip(), format, v ...)
 }
 
 func Fatalln(v ...interface{}) {
-	compat(ERROR, DefaultCallerSkip, v ...)
+	compat(ERROR, Global.callerSkip(), v ...)
 }
 
 func Output(calldepth int, s string) error {
@@ -144,27 +183,27 @@ func Output(calldepth int, s string) error {
 }
 
 func Panic(v ...interface{}) {
-	compat(CRITICAL, DefaultCallerSkip, v ...)
+	compat(CRITICAL, Global.callerSkip(), v ...)
 }
 
 func Panicf(format string, v ...interface{}) {
-	compatf(CRITICAL, DefaultCallerSkip, format, v ...)
+	compatf(CRITICAL, Global.callerSkip(), format, v ...)
 }
 
 func Panicln(v ...interface{}) {
-	compat(CRITICAL, DefaultCallerSkip, v ...)
+	compat(CRITICAL, Global.callerSkip(), v ...)
 }
 
 func Print(v ...interface{}) {
-	compat(INFO, DefaultCallerSkip, v ...)
+	compat(INFO, Global.callerSkip(), v ...)
 }
 
 func Printf(format string, v ...interface{}) {
-	compatf(INFO, DefaultCallerSkip, format, v ...)
+	compatf(INFO, Global.callerSkip(), format, v ...)
 }
 
 func Println(v ...interface{}) {
-	compat(INFO, DefaultCallerSkip, v ...)
+	compat(INFO, Global.callerSkip(), v ...)
 }
 
 // Send a log message manually
@@ -173,6 +212,18 @@ func Log(lvl Level, source, message string) {
 	Global.Log(lvl, source, message)
 }
 
+// Send a formatted log message with a manual source
+// Wrapper for (*Logger).LogfSource
+func LogfSource(lvl Level, source, format string, args ...interface{}) {
+	Global.LogfSource(lvl, source, format, args...)
+}
+
+// Send raw data as a log message
+// Wrapper for (*Logger).LogBytes
+func LogBytes(lvl Level, source string, data []byte) {
+	Global.LogBytes(lvl, source, data)
+}
+
 // Send a formatted log message easily
 // Wrapper for (*Logger).Logf
 func Logf(lvl Level, format string, args ...interface{}) {
@@ -185,42 +236,19 @@ func Logc(lvl Level, closure func() string) {
 	Global.intLogc(lvl, closure)
 }
 
-// Utility for finest log messages (see Debug() for parameter explanation)
-// Wrapper for (*Logger).Finest
-func Finest(arg0 interface{}, args ...interface{}) {
-	const (
-		lvl = FINEST
-	)
-	switch first := arg0.(type) {
-	case string:
-		// Use the string as a format string
-		Global.intLogf(lvl, first, args...)
-	case func() string:
-		// Log the closure (no other arguments used)
-		Global.intLogc(lvl, first)
-	default:
-		// Build a format string so that it will be similar to Sprint
-		Global.intLogf(lvl, fmt.Sprint(arg0)+strings.Repeat(" %v", len(args)), args...)
-	}
+// Send a log message with key/value fields
+// Wrapper for (*Logger).LogWith
+func LogWith(lvl Level, msg string, fields ...Field) {
+	Global.intLogw(lvl, msg, fields)
 }
 
-// Utility for fine log messages (see Debug() for parameter explanation)
-// Wrapper for (*Logger).Fine
-func Fine(arg0 interface{}, args ...interface{}) {
-	const (
-		lvl = FINE
-	)
-	switch first := arg0.(type) {
-	case string:
-		// Use the string as a format string
-		Global.intLogf(lvl, first, args...)
-	case func() string:
-		// Log the closure (no other arguments used)
-		Global.intLogc(lvl, first)
-	default:
-		// Build a format string so that it will be similar to Sprint
-		Global.intLogf(lvl, fmt.Sprint(arg0)+strings.Repeat(" %v", len(args)), args...)
+// Send a log message with the fields of a map
+// Wrapper for (*Logger).LogFields
+func LogFields(lvl Level, msg string, fields map[string]interface{}) {
+	if Global.skip(lvl) {
+		return
 	}
+	Global.intLogw(lvl, msg, mapFields(fields))
 }
 
 // Utility for debug log messages